@@ -0,0 +1,101 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// map interface names to human-friendly aliases from a file
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// InterfaceAliases maps interface names to aliases, such as "wan" or "lan", read from a
+// file of "interface alias" lines, e.g.
+//
+//	enp3s0 wan
+//	enp4s0 lan
+//
+// Blank lines and lines starting with "#" are ignored. The mapping can be reloaded
+// while in use, lookups always see either the previous or the new mapping.
+type InterfaceAliases struct {
+	path    string
+	aliases atomic.Pointer[map[string]string]
+}
+
+// NewInterfaceAliases creates aliases from the file at path, returning an error if the
+// file can't be read or is invalid.
+func NewInterfaceAliases(path string) (*InterfaceAliases, error) {
+	a := &InterfaceAliases{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Path returns the path of the file aliases are read from.
+func (a *InterfaceAliases) Path() string {
+	return a.path
+}
+
+// Reload reads the file again, replacing the mapping. The previous mapping is kept if
+// the file can't be read or is invalid.
+func (a *InterfaceAliases) Reload() error {
+	aliases, err := readInterfaceAliases(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.aliases.Store(&aliases)
+	return nil
+}
+
+// Alias returns the alias of the interface or an empty string if it doesn't have one.
+func (a *InterfaceAliases) Alias(iface string) string {
+	return (*a.aliases.Load())[iface]
+}
+
+func readInterfaceAliases(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	aliases := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %d of alias file %s: expected interface and alias", lineNum, path)
+		}
+
+		aliases[fields[0]] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
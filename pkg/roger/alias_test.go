@@ -0,0 +1,55 @@
+package roger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeAliasFixture(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "aliases")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestInterfaceAliases_Alias(t *testing.T) {
+	path := writeAliasFixture(t, "# uplinks\nenp3s0 wan\n\nenp4s0   lan\n")
+
+	aliases, err := NewInterfaceAliases(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "wan", aliases.Alias("enp3s0"))
+	assert.Equal(t, "lan", aliases.Alias("enp4s0"))
+	assert.Equal(t, "", aliases.Alias("lo"))
+}
+
+func TestInterfaceAliases_Invalid(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		_, err := NewInterfaceAliases(filepath.Join(t.TempDir(), "aliases"))
+		assert.Error(t, err)
+	})
+
+	t.Run("no alias", func(t *testing.T) {
+		_, err := NewInterfaceAliases(writeAliasFixture(t, "enp3s0\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestInterfaceAliases_Reload(t *testing.T) {
+	path := writeAliasFixture(t, "enp3s0 wan\n")
+
+	aliases, err := NewInterfaceAliases(path)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("enp3s0 uplink\n"), 0644))
+	require.NoError(t, aliases.Reload())
+	assert.Equal(t, "uplink", aliases.Alias("enp3s0"))
+
+	// Invalid files leave the previous mapping in place
+	require.NoError(t, os.WriteFile(path, []byte("enp3s0 uplink extra\n"), 0644))
+	assert.Error(t, aliases.Reload())
+	assert.Equal(t, "uplink", aliases.Alias("enp3s0"))
+}
@@ -0,0 +1,189 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Protocols that can be used to query DNS servers for stats.
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+	ProtocolTLS = "tcp-tls"
+)
+
+// NewDNSClient creates a client for querying the DNS server at address using
+// the given protocol. For DNS over TLS the server name used for SNI and certificate
+// verification is tlsServerName if set or the host part of address otherwise.
+func NewDNSClient(protocol string, address string, tlsServerName string) *dns.Client {
+	client := &dns.Client{Net: protocol}
+	if protocol == ProtocolTLS {
+		client.TLSConfig = &tls.Config{ServerName: tlsServerName}
+		if tlsServerName == "" {
+			client.TLSConfig.ServerName = hostName(address)
+		}
+	}
+
+	return client
+}
+
+// transport is a client for querying a DNS server using a single protocol.
+type transport struct {
+	protocol string
+	client   dnsClient
+}
+
+// FallbackClient queries a DNS server using each of an ordered list of protocols
+// until one of them returns a response that isn't truncated. This allows using UDP
+// when possible and falling back to TCP for responses that don't fit in a datagram.
+// Truncated responses that were retried with another protocol are counted for each
+// address so that they're included in the number of truncated responses of a server.
+type FallbackClient struct {
+	transports []transport
+
+	lock    sync.Mutex
+	retried map[string]uint64
+}
+
+// NewFallbackClient creates a client that tries each protocol in order, using
+// NewDNSClient to create a client for each of them.
+func NewFallbackClient(protocols []string, address string, tlsServerName string) *FallbackClient {
+	transports := make([]transport, len(protocols))
+	for i, p := range protocols {
+		transports[i] = transport{protocol: p, client: NewDNSClient(p, address, tlsServerName)}
+	}
+
+	return &FallbackClient{transports: transports}
+}
+
+func (c *FallbackClient) Exchange(m *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	r, rtt, _, err := c.ExchangeTransport(m, address)
+	return r, rtt, err
+}
+
+// ExchangeTransport sends the message using each protocol in order, returning the
+// first response that isn't truncated along with the protocol used to get it. If
+// every protocol fails or returns a truncated response, the first truncated response
+// is returned if there was one and errors from each protocol otherwise. Truncated
+// responses that aren't returned are counted, see TruncatedRetries.
+func (c *FallbackClient) ExchangeTransport(m *dns.Msg, address string) (*dns.Msg, time.Duration, string, error) {
+	var (
+		truncated         *dns.Msg
+		truncatedRTT      time.Duration
+		truncatedProtocol string
+		truncatedCount    uint64
+		failures          []error
+	)
+
+	for _, t := range c.transports {
+		r, rtt, err := t.client.Exchange(m, address)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %w", t.protocol, err))
+			continue
+		}
+
+		if !r.Truncated {
+			c.countRetried(address, truncatedCount)
+			return r, rtt, t.protocol, nil
+		}
+
+		truncatedCount++
+		if truncated == nil {
+			truncated, truncatedRTT, truncatedProtocol = r, rtt, t.protocol
+		}
+	}
+
+	if truncated != nil {
+		c.countRetried(address, truncatedCount-1)
+		return truncated, truncatedRTT, truncatedProtocol, nil
+	}
+
+	return nil, 0, "", errors.Join(failures...)
+}
+
+// countRetried adds to the number of truncated responses from the server at address
+// that were retried with another protocol.
+func (c *FallbackClient) countRetried(address string, n uint64) {
+	if n == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.retried == nil {
+		c.retried = make(map[string]uint64)
+	}
+	c.retried[address] += n
+}
+
+// TruncatedRetries returns the number of truncated responses from the server at
+// address that were retried with another protocol, which aren't returned and so
+// aren't otherwise seen by callers.
+func (c *FallbackClient) TruncatedRetries(address string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.retried[address]
+}
+
+// dialerTimeout is the default time allowed for dialing and making a query over a
+// connection from a ContextDialer, the same as the default dial and read timeouts of
+// dns.Client.
+const dialerTimeout = 2 * time.Second
+
+// ContextDialer creates connections to DNS servers. It's satisfied by *net.Dialer and
+// can be implemented to make queries through a proxy or tunnel, such as an SSH tunnel
+// through a bastion host, when embedding Roger as a library.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// exchangeWithDialer makes a query over a new TCP connection from the dialer, allowing
+// timeout (dialerTimeout when zero) for both. TCP is always used since tunnels and
+// proxies are usually stream based.
+func exchangeWithDialer(dialer ContextDialer, timeout time.Duration, m *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	if timeout == 0 {
+		timeout = dialerTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, ProtocolTCP, address)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	client := &dns.Client{Net: ProtocolTCP}
+	return client.ExchangeWithConnContext(ctx, m, &dns.Conn{Conn: conn})
+}
+
+// hostName returns the host part of address, stripping the port if there is one.
+func hostName(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	return host
+}
@@ -0,0 +1,176 @@
+package roger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDNSClient(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		client := NewDNSClient(ProtocolUDP, "127.0.0.1:53", "")
+		assert.Equal(t, "udp", client.Net)
+		assert.Nil(t, client.TLSConfig)
+	})
+
+	t.Run("tls server name from hostname", func(t *testing.T) {
+		client := NewDNSClient(ProtocolTLS, "dns.example.com:853", "")
+		assert.Equal(t, "tcp-tls", client.Net)
+		require.NotNil(t, client.TLSConfig)
+		assert.Equal(t, "dns.example.com", client.TLSConfig.ServerName)
+	})
+
+	t.Run("tls server name without port", func(t *testing.T) {
+		client := NewDNSClient(ProtocolTLS, "dns.example.com", "")
+		require.NotNil(t, client.TLSConfig)
+		assert.Equal(t, "dns.example.com", client.TLSConfig.ServerName)
+	})
+
+	t.Run("tls server name override", func(t *testing.T) {
+		client := NewDNSClient(ProtocolTLS, "192.168.1.1:853", "dns.example.com")
+		require.NotNil(t, client.TLSConfig)
+		assert.Equal(t, "dns.example.com", client.TLSConfig.ServerName)
+	})
+}
+
+func TestFallbackClient_ExchangeTransport(t *testing.T) {
+	complete := statsMsg("100", "100", "100")
+	truncated := statsMsg("100", "100", "100")
+	truncated.Truncated = true
+
+	t.Run("first transport succeeds", func(t *testing.T) {
+		client := &FallbackClient{transports: []transport{
+			{protocol: ProtocolUDP, client: &mockDNSClient{msg: complete}},
+			{protocol: ProtocolTCP, client: &mockDNSClient{err: errors.New("not used")}},
+		}}
+
+		r, _, protocol, err := client.ExchangeTransport(&dns.Msg{}, "127.0.0.1:53")
+		require.NoError(t, err)
+		assert.False(t, r.Truncated)
+		assert.Equal(t, ProtocolUDP, protocol)
+	})
+
+	t.Run("fallback on truncation", func(t *testing.T) {
+		client := &FallbackClient{transports: []transport{
+			{protocol: ProtocolUDP, client: &mockDNSClient{msg: truncated}},
+			{protocol: ProtocolTCP, client: &mockDNSClient{msg: complete}},
+		}}
+
+		r, _, protocol, err := client.ExchangeTransport(&dns.Msg{}, "127.0.0.1:53")
+		require.NoError(t, err)
+		assert.False(t, r.Truncated)
+		assert.Equal(t, ProtocolTCP, protocol)
+		assert.Equal(t, uint64(1), client.TruncatedRetries("127.0.0.1:53"))
+		assert.Equal(t, uint64(0), client.TruncatedRetries("127.0.0.2:53"))
+	})
+
+	t.Run("fallback on error", func(t *testing.T) {
+		client := &FallbackClient{transports: []transport{
+			{protocol: ProtocolUDP, client: &mockDNSClient{err: errors.New("timeout")}},
+			{protocol: ProtocolTCP, client: &mockDNSClient{msg: complete}},
+		}}
+
+		_, _, protocol, err := client.ExchangeTransport(&dns.Msg{}, "127.0.0.1:53")
+		require.NoError(t, err)
+		assert.Equal(t, ProtocolTCP, protocol)
+	})
+
+	t.Run("truncated response when others fail", func(t *testing.T) {
+		client := &FallbackClient{transports: []transport{
+			{protocol: ProtocolUDP, client: &mockDNSClient{msg: truncated}},
+			{protocol: ProtocolTCP, client: &mockDNSClient{err: errors.New("connection refused")}},
+		}}
+
+		r, _, protocol, err := client.ExchangeTransport(&dns.Msg{}, "127.0.0.1:53")
+		require.NoError(t, err)
+		assert.True(t, r.Truncated)
+		assert.Equal(t, ProtocolUDP, protocol)
+		assert.Equal(t, uint64(0), client.TruncatedRetries("127.0.0.1:53"))
+	})
+
+	t.Run("all transports fail", func(t *testing.T) {
+		client := &FallbackClient{transports: []transport{
+			{protocol: ProtocolUDP, client: &mockDNSClient{err: errors.New("timeout")}},
+			{protocol: ProtocolTCP, client: &mockDNSClient{err: errors.New("connection refused")}},
+		}}
+
+		_, _, _, err := client.ExchangeTransport(&dns.Msg{}, "127.0.0.1:53")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "udp: timeout")
+		assert.Contains(t, err.Error(), "tcp: connection refused")
+	})
+}
+
+// pipeDialer returns one end of an in-memory connection to a fake DNS server
+// that responds to each query with a canned message.
+type pipeDialer struct {
+	response *dns.Msg
+	network  string
+	address  string
+}
+
+func (d *pipeDialer) DialContext(_ context.Context, network string, address string) (net.Conn, error) {
+	d.network = network
+	d.address = address
+
+	client, server := net.Pipe()
+	go func() {
+		conn := &dns.Conn{Conn: server}
+		defer func() { _ = conn.Close() }()
+
+		req, err := conn.ReadMsg()
+		if err != nil {
+			return
+		}
+
+		res := d.response.Copy()
+		res.SetReply(req)
+		res.Answer = d.response.Answer
+		_ = conn.WriteMsg(res)
+	}()
+
+	return client, nil
+}
+
+// deadlineDialer records the deadline of the context used to dial and fails.
+type deadlineDialer struct {
+	deadline time.Time
+}
+
+func (d *deadlineDialer) DialContext(ctx context.Context, _ string, _ string) (net.Conn, error) {
+	d.deadline, _ = ctx.Deadline()
+	return nil, errors.New("unreachable")
+}
+
+func TestExchangeWithDialer(t *testing.T) {
+	t.Run("query", func(t *testing.T) {
+		dialer := &pipeDialer{response: statsMsg("100", "100", "100")}
+		m := &dns.Msg{Question: []dns.Question{question("hits.bind.")}}
+
+		r, _, err := exchangeWithDialer(dialer, 0, m, "10.0.0.1:53")
+		require.NoError(t, err)
+		assert.Equal(t, "tcp", dialer.network)
+		assert.Equal(t, "10.0.0.1:53", dialer.address)
+		assert.Len(t, r.Answer, 7)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		m := &dns.Msg{Question: []dns.Question{question("hits.bind.")}}
+
+		dialer := &deadlineDialer{}
+		_, _, err := exchangeWithDialer(dialer, 0, m, "10.0.0.1:53")
+		require.Error(t, err)
+		assert.WithinDuration(t, time.Now().Add(dialerTimeout), dialer.deadline, time.Second)
+
+		_, _, err = exchangeWithDialer(dialer, 10*time.Second, m, "10.0.0.1:53")
+		require.Error(t, err)
+		assert.WithinDuration(t, time.Now().Add(10*time.Second), dialer.deadline, time.Second)
+	})
+}
@@ -0,0 +1,360 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// load settings from a YAML configuration file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	subsystemPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Config is the contents of a configuration file. Settings correspond to command
+// line flags, see Flags. Fields that aren't set leave the flag default unchanged.
+type Config struct {
+	DNS        DNSConfig        `yaml:"dns"`
+	Collectors CollectorsConfig `yaml:"collectors"`
+	Proc       ProcConfig       `yaml:"proc"`
+	Sys        SysConfig        `yaml:"sys"`
+	Web        WebConfig        `yaml:"web"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	NetDev     NetDevConfig     `yaml:"netdev"`
+}
+
+type DNSConfig struct {
+	Servers       []DNSServerConfig `yaml:"servers"`
+	Protocol      string            `yaml:"protocol"`
+	Transports    []string          `yaml:"transports"`
+	TLSServerName string            `yaml:"tls_server_name"`
+}
+
+// DNSServerConfig is a DNS server to export metrics for. Labels are added to every
+// metric for the server.
+type DNSServerConfig struct {
+	Address string            `yaml:"address"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+type CollectorsConfig struct {
+	Go        *bool `yaml:"go"`
+	Process   *bool `yaml:"process"`
+	Host      *bool `yaml:"host"`
+	Conntrack *bool `yaml:"conntrack"`
+}
+
+type ProcConfig struct {
+	Path         string              `yaml:"path"`
+	NetStat      []string            `yaml:"netstat"`
+	NetStatFiles []NetStatFileConfig `yaml:"netstat_files"`
+}
+
+// NetStatFileConfig describes how to export metrics for a file under /proc/net/stat,
+// allowing files Roger doesn't know about to be exported correctly. Files configured
+// this way are exported in addition to those given by --proc.netstat.
+type NetStatFileConfig struct {
+	// File is the name of the file under /proc/net/stat, e.g. ndisc_cache.
+	File string `yaml:"file"`
+
+	// Subsystem is used for metric names, e.g. roger_$subsystem_entries. Defaults
+	// to the name of the file.
+	Subsystem string `yaml:"subsystem"`
+
+	// Base is the numeric base of values in the file. Defaults to 16, which is
+	// used by every file in /proc/net/stat so far.
+	Base int `yaml:"base"`
+
+	// Gauges are columns whose values can go up or down. All other columns are
+	// counters, except for the "entries" column and known columns of the file.
+	Gauges []string `yaml:"gauges"`
+}
+
+// subsystem returns the subsystem used for metric names, the name of the file if no
+// subsystem is set.
+func (f NetStatFileConfig) subsystem() string {
+	if f.Subsystem == "" {
+		return f.File
+	}
+
+	return f.Subsystem
+}
+
+type SysConfig struct {
+	Path string `yaml:"path"`
+}
+
+type WebConfig struct {
+	ListenAddresses          []string `yaml:"listen_addresses"`
+	TelemetryPath            string   `yaml:"telemetry_path"`
+	Debug                    *bool    `yaml:"debug"`
+	DisableDefaultCollectors *bool    `yaml:"disable_default_collectors"`
+}
+
+type MetricsConfig struct {
+	Allowlist []string `yaml:"allowlist"`
+	Denylist  []string `yaml:"denylist"`
+}
+
+type NetDevConfig struct {
+	UpOnly       *bool    `yaml:"up_only"`
+	IncludeCIDRs []string `yaml:"include_cidrs"`
+}
+
+// LoadConfig reads and validates a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	cfg, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func parseConfig(r io.Reader) (*Config, error) {
+	var cfg Config
+
+	// Unknown fields are rejected so that typos aren't silently ignored
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	seen := make(map[string]bool)
+	for _, s := range c.DNS.Servers {
+		if s.Address == "" {
+			return errors.New("DNS server without an address")
+		}
+
+		for name := range s.Labels {
+			if !labelNamePattern.MatchString(name) {
+				return fmt.Errorf("invalid label name %q for DNS server %s", name, s.Address)
+			}
+
+			if strings.HasPrefix(name, "__") || metricLabelNames[name] {
+				return fmt.Errorf("label name %q for DNS server %s is already used by Roger metrics", name, s.Address)
+			}
+		}
+
+		// Labels are looked up by address so each server can only have one set
+		if seen[s.Address] {
+			return fmt.Errorf("DNS server %s is configured more than once", s.Address)
+		}
+		seen[s.Address] = true
+	}
+
+	files := make(map[string]bool)
+	for _, f := range c.Proc.NetStatFiles {
+		if f.File == "" || f.File != filepath.Base(f.File) || strings.HasPrefix(f.File, ".") {
+			return fmt.Errorf("invalid net/stat file name %q", f.File)
+		}
+
+		// Files whose names can't be used in metric names need a subsystem set
+		if !subsystemPattern.MatchString(f.subsystem()) {
+			return fmt.Errorf("invalid subsystem %q for net/stat file %s", f.subsystem(), f.File)
+		}
+
+		if f.Base != 0 && (f.Base < 2 || f.Base > 36) {
+			return fmt.Errorf("invalid base %d for net/stat file %s, must be between 2 and 36", f.Base, f.File)
+		}
+
+		if files[f.File] {
+			return fmt.Errorf("net/stat file %s is configured more than once", f.File)
+		}
+		files[f.File] = true
+	}
+
+	return nil
+}
+
+// DNSServerLabels are the labels of each configured DNS server keyed by address.
+type DNSServerLabels map[string]map[string]string
+
+// DNSServerLabels returns the labels for each configured DNS server keyed by address.
+func (c *Config) DNSServerLabels() DNSServerLabels {
+	out := make(DNSServerLabels, len(c.DNS.Servers))
+	for _, s := range c.DNS.Servers {
+		out[s.Address] = s.Labels
+	}
+
+	return out
+}
+
+// For returns the labels to add to metrics of the server. Metrics with the same name
+// must have the same label names, so every server (including those not in the config
+// file) has the name of every label of any server, empty unless the server sets it.
+func (l DNSServerLabels) For(server string) map[string]string {
+	out := make(map[string]string)
+	for _, labels := range l {
+		for name := range labels {
+			out[name] = ""
+		}
+	}
+
+	for name, value := range l[server] {
+		out[name] = value
+	}
+
+	return out
+}
+
+// Flags returns the value of each setting keyed by the name of the command line flag
+// it corresponds to. Settings that aren't set in the file are not included.
+func (c *Config) Flags() map[string][]string {
+	out := make(map[string][]string)
+
+	setString := func(name string, val string) {
+		if val != "" {
+			out[name] = []string{val}
+		}
+	}
+
+	setStrings := func(name string, vals []string) {
+		if len(vals) > 0 {
+			out[name] = vals
+		}
+	}
+
+	setBool := func(name string, val *bool) {
+		if val != nil {
+			out[name] = []string{strconv.FormatBool(*val)}
+		}
+	}
+
+	servers := make([]string, len(c.DNS.Servers))
+	for i, s := range c.DNS.Servers {
+		servers[i] = s.Address
+	}
+
+	setStrings("dns.server", servers)
+	setString("dns.protocol", c.DNS.Protocol)
+	setString("dns.transport", strings.Join(c.DNS.Transports, ","))
+	setString("dns.tls-servername", c.DNS.TLSServerName)
+	setBool("collector.go", c.Collectors.Go)
+	setBool("collector.process", c.Collectors.Process)
+	setBool("collector.host", c.Collectors.Host)
+	setBool("collector.conntrack", c.Collectors.Conntrack)
+	setString("proc.path", c.Proc.Path)
+	setStrings("proc.netstat", c.Proc.NetStat)
+	setString("sys.path", c.Sys.Path)
+	setStrings("web.listen-address", c.Web.ListenAddresses)
+	setString("web.telemetry-path", c.Web.TelemetryPath)
+	setBool("web.debug", c.Web.Debug)
+	setBool("web.disable-default-collectors", c.Web.DisableDefaultCollectors)
+	setStrings("metric.allowlist", c.Metrics.Allowlist)
+	setStrings("metric.denylist", c.Metrics.Denylist)
+	setBool("netdev.up-only", c.NetDev.UpOnly)
+	setStrings("netdev.include-cidr", c.NetDev.IncludeCIDRs)
+
+	return out
+}
+
+// LabelsKey returns a string that's the same for equal sets of labels, for use
+// as a map key.
+func LabelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+		sb.WriteByte(',')
+	}
+
+	return sb.String()
+}
+
+// metricLabelNames are the names of labels of metrics exported by Roger, including
+// roger_build_info and the Go runtime metrics, which labels added to every metric can't
+// use. Labels of new metrics must be added here, TestMetricLabelNames checks the labels
+// of metrics emitted by every reader.
+var metricLabelNames = map[string]bool{
+	"alias":     true,
+	"branch":    true,
+	"collector": true,
+	"counter":   true,
+	"cpu":       true,
+	"event":     true,
+	"family":    true,
+	"file":      true,
+	"goversion": true,
+	"interface": true,
+	"kind":      true,
+	"le":        true,
+	"protocol":  true,
+	"quantile":  true,
+	"reason":    true,
+	"revision":  true,
+	"section":   true,
+	"server":    true,
+	"state":     true,
+	"transport": true,
+	"upstream":  true,
+	"version":   true,
+}
+
+// ParseInstanceLabel parses a label to add to every metric so that metrics from many
+// hosts or namespaces can be told apart once aggregated, either "name=value" or a
+// value for the "instance" label. An error is returned if the name is invalid or is
+// the name of a label of metrics exported by Roger.
+func ParseInstanceLabel(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		name, value = "instance", s
+	}
+
+	if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return "", "", fmt.Errorf("invalid label name %q", name)
+	}
+
+	if metricLabelNames[name] {
+		return "", "", fmt.Errorf("label name %q is already used by Roger metrics", name)
+	}
+
+	if value == "" {
+		return "", "", fmt.Errorf("empty value for label %q", name)
+	}
+
+	return name, value, nil
+}
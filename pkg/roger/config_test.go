@@ -0,0 +1,256 @@
+package roger
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const configFixture = `
+dns:
+  servers:
+    - address: 10.0.0.1:53
+      labels:
+        site: east
+    - address: 10.0.0.2:53
+      labels:
+        site: west
+  transports: [udp, tcp]
+collectors:
+  host: true
+  go: false
+proc:
+  path: /host/proc
+  netstat: [nf_conntrack]
+web:
+  listen_addresses: [127.0.0.1:9779, "[::1]:9779"]
+  debug: true
+metrics:
+  denylist: [roger_net_.*_compressed]
+`
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "roger.yml", configFixture)
+
+		cfg, err := LoadConfig(filepath.Join(base, "roger.yml"))
+		require.NoError(t, err)
+		require.Len(t, cfg.DNS.Servers, 2)
+		assert.Equal(t, map[string]string{"site": "east"}, cfg.DNS.Servers[0].Labels)
+		assert.Equal(t, "/host/proc", cfg.Proc.Path)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "roger.yml"))
+		assert.Error(t, err)
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		cfg, err := parseConfig(strings.NewReader(""))
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Flags())
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("dns:\n  server: 127.0.0.1:53\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("server without address", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("dns:\n  servers:\n    - labels: {site: east}\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid label name", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("dns:\n  servers:\n    - address: 127.0.0.1:53\n      labels: {site-name: east}\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("reserved label name", func(t *testing.T) {
+		for _, name := range []string{"server", "collector", "reason", "upstream", "family", "transport", "le", "__name__"} {
+			_, err := parseConfig(strings.NewReader("dns:\n  servers:\n    - address: 127.0.0.1:53\n      labels: {" + name + ": east}\n"))
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("duplicate server", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("dns:\n  servers:\n    - address: 127.0.0.1:53\n    - address: 127.0.0.1:53\n"))
+		assert.Error(t, err)
+
+		_, err = parseConfig(strings.NewReader("dns:\n  servers:\n    - address: 127.0.0.1:53\n      labels: {site: east}\n    - address: 127.0.0.1:53\n      labels: {site: west}\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("net/stat files", func(t *testing.T) {
+		cfg, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ndisc_cache\n      subsystem: ndisc\n      base: 16\n      gauges: [entries]\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []NetStatFileConfig{{File: "ndisc_cache", Subsystem: "ndisc", Base: 16, Gauges: []string{"entries"}}}, cfg.Proc.NetStatFiles)
+		assert.Empty(t, cfg.Flags())
+	})
+
+	t.Run("net/stat file outside directory", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ../dev\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("net/stat file invalid subsystem", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ndisc_cache\n      subsystem: ndisc-cache\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("net/stat file name invalid as subsystem", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ndisc-cache\n"))
+		assert.Error(t, err)
+
+		cfg, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ndisc-cache\n      subsystem: ndisc\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "ndisc", cfg.Proc.NetStatFiles[0].subsystem())
+	})
+
+	t.Run("net/stat file invalid base", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ndisc_cache\n      base: 64\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("duplicate net/stat file", func(t *testing.T) {
+		_, err := parseConfig(strings.NewReader("proc:\n  netstat_files:\n    - file: ndisc_cache\n    - file: ndisc_cache\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestConfig_Flags(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(configFixture))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]string{
+		"dns.server":         {"10.0.0.1:53", "10.0.0.2:53"},
+		"dns.transport":      {"udp,tcp"},
+		"collector.host":     {"true"},
+		"collector.go":       {"false"},
+		"proc.path":          {"/host/proc"},
+		"proc.netstat":       {"nf_conntrack"},
+		"web.listen-address": {"127.0.0.1:9779", "[::1]:9779"},
+		"web.debug":          {"true"},
+		"metric.denylist":    {"roger_net_.*_compressed"},
+	}, cfg.Flags())
+}
+
+func TestConfig_DNSServerLabels(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(configFixture))
+	require.NoError(t, err)
+
+	assert.Equal(t, DNSServerLabels{
+		"10.0.0.1:53": {"site": "east"},
+		"10.0.0.2:53": {"site": "west"},
+	}, cfg.DNSServerLabels())
+}
+
+func TestDNSServerLabels_For(t *testing.T) {
+	labels := DNSServerLabels{
+		"10.0.0.1:53": {"site": "east", "rack": "r1"},
+		"10.0.0.2:53": {"site": "west"},
+		"10.0.0.3:53": nil,
+	}
+
+	assert.Equal(t, map[string]string{"site": "east", "rack": "r1"}, labels.For("10.0.0.1:53"))
+	assert.Equal(t, map[string]string{"site": "west", "rack": ""}, labels.For("10.0.0.2:53"))
+	assert.Equal(t, map[string]string{"site": "", "rack": ""}, labels.For("10.0.0.3:53"))
+	assert.Equal(t, map[string]string{"site": "", "rack": ""}, labels.For("10.0.0.4:53"))
+	assert.Empty(t, DNSServerLabels(nil).For("10.0.0.1:53"))
+}
+
+func TestLabelsKey(t *testing.T) {
+	assert.Equal(t, "", LabelsKey(nil))
+	assert.Equal(t, LabelsKey(map[string]string{"a": "1", "b": "2"}), LabelsKey(map[string]string{"b": "2", "a": "1"}))
+	assert.NotEqual(t, LabelsKey(map[string]string{"a": "1,b=2"}), LabelsKey(map[string]string{"a": "1", "b": "2"}))
+}
+
+func TestParseInstanceLabel(t *testing.T) {
+	name, value, err := ParseInstanceLabel("db1")
+	require.NoError(t, err)
+	assert.Equal(t, "instance", name)
+	assert.Equal(t, "db1", value)
+
+	name, value, err = ParseInstanceLabel("host=db1.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "host", name)
+	assert.Equal(t, "db1.example.com", value)
+
+	for _, invalid := range []string{"", "host=", "1host=db1", "__name__=db1", "server=db1", "interface=db1", "cpu=db1", "file=db1"} {
+		_, _, err := ParseInstanceLabel(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMetricLabelNames(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+	writeProcFixture(t, base, "net/softnet_stat", softnetFixture)
+	writeProcFixture(t, base, "net/nf_conntrack", conntrackFixture)
+	writeProcFixture(t, base, "loadavg", loadAvgFixture)
+	writeProcFixture(t, base, "meminfo", memInfoFixture)
+	writeProcessFixture(t, base, "1234")
+	writeSysFixture(t, base, "eth0", "operstate", "up")
+	writeSysFixture(t, base, "eth0", "mtu", "1500")
+
+	aliases, err := NewInterfaceAliases(writeAliasFixture(t, "eth0 wan\n"))
+	require.NoError(t, err)
+
+	netDev := NewProcNetDevReader(base, log.NewNopLogger())
+	netDev.LinkFaults = true
+	netDev.Aliases = aliases
+
+	dnsmasq := NewDnsmasqReader(&staticDNSClient{msg: statsMsg("1", "2", "3")}, "127.0.0.1:53", log.NewNopLogger())
+	dnsmasq.MetricLayout = DnsMetricLayoutLabeled
+
+	inventory := NewInventoryCollector()
+	inventory.AddServer("127.0.0.1:53")
+	inventory.AddCollector("netdev")
+	inventory.SetProcFile("net/dev", true)
+
+	collectors := []prometheus.Collector{
+		netDev,
+		NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger()),
+		NewProcNetSoftnetReader(base, log.NewNopLogger()),
+		NewProcNetConntrackReader(base, log.NewNopLogger()),
+		NewHostReader(base, log.NewNopLogger()),
+		NewProcessReader(base, 1234, "", log.NewNopLogger()),
+		NewSysClassNetReader(base, log.NewNopLogger()),
+		NewPoller("netdev", netDev, time.Minute, log.NewNopLogger()),
+		dnsmasq,
+		inventory,
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range collectors {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			c.Collect(ch)
+			close(ch)
+		}()
+
+		for m := range ch {
+			var out dto.Metric
+			require.NoError(t, m.Write(&out))
+			for _, l := range out.GetLabel() {
+				seen[l.GetName()] = true
+				assert.True(t, metricLabelNames[l.GetName()], "label %q of %s isn't in metricLabelNames", l.GetName(), m.Desc())
+			}
+		}
+	}
+
+	for _, name := range []string{"server", "interface", "alias", "cpu", "file", "collector", "protocol"} {
+		assert.True(t, seen[name], name)
+	}
+}
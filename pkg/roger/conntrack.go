@@ -0,0 +1,213 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// count connection tracking entries by protocol and state from /proc/net/nf_conntrack
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultConntrackMaxEntries is the default number of entries of the connection
+// tracking table read on each collection.
+const DefaultConntrackMaxEntries = 100000
+
+// conntrackNoState is the state of entries for protocols without states, e.g. udp.
+const conntrackNoState = "none"
+
+type ConntrackKey struct {
+	Protocol string
+	State    string
+}
+
+type ConntrackResults struct {
+	Entries map[ConntrackKey]uint64
+	// Truncated is true if there were more entries than the limit of the reader
+	// and the remaining entries were not counted.
+	Truncated bool
+}
+
+// ProcNetConntrackReader counts entries of the connection tracking table by protocol
+// and state. The table can have millions of entries on a busy firewall so it's read a
+// line at a time and only counts are kept, never anything about each connection.
+type ProcNetConntrackReader struct {
+	// MaxEntries is the most entries read on each collection to limit how long
+	// collection can take. Defaults to DefaultConntrackMaxEntries, no limit when
+	// negative.
+	MaxEntries int
+
+	// Timeout, if positive, is the most time spent reading the table on each
+	// collection. Collection fails instead of emitting partial counts if the table
+	// can't be read in time.
+	Timeout time.Duration
+
+	path      string
+	entries   *prometheus.Desc
+	truncated *prometheus.Desc
+	logger    log.Logger
+	errLog    *errorLogLimiter
+}
+
+func NewProcNetConntrackReader(base string, logger log.Logger) *ProcNetConntrackReader {
+	return &ProcNetConntrackReader{
+		MaxEntries: DefaultConntrackMaxEntries,
+		path:       filepath.Join(base, "net", "nf_conntrack"),
+		entries: prometheus.NewDesc(
+			"roger_conntrack_entries_by",
+			"Number of entries in the connection tracking table by protocol and state",
+			[]string{"protocol", "state"},
+			nil,
+		),
+		truncated: prometheus.NewDesc(
+			"roger_conntrack_entries_truncated",
+			"1 if the connection tracking table had more entries than the limit and some were not counted, 0 otherwise",
+			nil,
+			nil,
+		),
+		logger: logger,
+		errLog: newErrorLogLimiter(procErrorLogInterval),
+	}
+}
+
+func (p *ProcNetConntrackReader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.entries
+	ch <- p.truncated
+}
+
+func (p *ProcNetConntrackReader) Collect(ch chan<- prometheus.Metric) {
+	err := p.CollectWithError(ch)
+	if err == nil {
+		if p.errLog.recovered() {
+			level.Info(p.logger).Log("msg", "conntrack metrics collected successfully after failures", "path", p.path)
+		}
+
+		return
+	}
+
+	if ok, suppressed := p.errLog.failed(); ok {
+		level.Error(p.logger).Log("msg", "failed to read conntrack metrics during collection", "path", p.path, "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits the number of entries for each protocol and state, returning
+// an error if the conntrack table could not be read.
+func (p *ProcNetConntrackReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	ctx, cancel := collectContext(p.Timeout)
+	defer cancel()
+
+	res, err := p.ReadMetricsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Emit metrics sorted by labels so that output is stable between collections
+	keys := make([]ConntrackKey, 0, len(res.Entries))
+	for k := range res.Entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Protocol != keys[j].Protocol {
+			return keys[i].Protocol < keys[j].Protocol
+		}
+		return keys[i].State < keys[j].State
+	})
+
+	for _, k := range keys {
+		ch <- prometheus.MustNewConstMetric(p.entries, prometheus.GaugeValue, float64(res.Entries[k]), k.Protocol, k.State)
+	}
+
+	var truncated float64
+	if res.Truncated {
+		level.Debug(p.logger).Log("msg", "stopped reading conntrack table at entry limit", "path", p.path, "limit", p.MaxEntries)
+		truncated = 1
+	}
+	ch <- prometheus.MustNewConstMetric(p.truncated, prometheus.GaugeValue, truncated)
+
+	return nil
+}
+
+func (p *ProcNetConntrackReader) Exists() bool {
+	if _, err := os.Stat(p.path); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
+
+func (p *ProcNetConntrackReader) ReadMetrics() (*ConntrackResults, error) {
+	return p.ReadMetricsContext(context.Background())
+}
+
+// ReadMetricsContext counts entries of the table, returning an error wrapping the
+// error of the context if it's canceled or its deadline is exceeded before reading
+// all entries (or MaxEntries entries).
+func (p *ProcNetConntrackReader) ReadMetricsContext(ctx context.Context) (*ConntrackResults, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	res := &ConntrackResults{Entries: make(map[ConntrackKey]uint64)}
+	read := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := procScanErr(ctx, read); err != nil {
+			return nil, fmt.Errorf("stopped reading %s after %d entries: %w", p.path, read, err)
+		}
+
+		if p.MaxEntries >= 0 && read >= p.MaxEntries {
+			res.Truncated = true
+			break
+		}
+
+		read++
+		if key, ok := parseConntrackEntry(scanner.Text()); ok {
+			res.Entries[key]++
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+// parseConntrackEntry returns the protocol and state of an entry of the conntrack
+// table, e.g.
+//
+//	ipv4     2 tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=53122 dport=22 ...
+//	ipv4     2 udp      17 28 src=10.0.0.1 dst=10.0.0.53 sport=41234 dport=53 [UNREPLIED] ...
+//
+// The state follows the timeout for protocols that have states and is missing otherwise.
+func parseConntrackEntry(line string) (ConntrackKey, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 {
+		return ConntrackKey{}, false
+	}
+
+	state := conntrackNoState
+	if !strings.Contains(fields[5], "=") && !strings.HasPrefix(fields[5], "[") {
+		state = fields[5]
+	}
+
+	return ConntrackKey{Protocol: fields[2], State: state}, true
+}
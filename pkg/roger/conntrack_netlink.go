@@ -0,0 +1,41 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// read conntrack stats over netlink for kernels without /proc/net/stat/nf_conntrack
+
+import (
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// netlinkConntrackPath is used in place of a path in logs and help text of the reader
+// for conntrack stats over netlink.
+const netlinkConntrackPath = "netlink:ctnetlink"
+
+// NewNetlinkConntrackStatReader creates a reader for conntrack stats from the kernel over
+// netlink (ctnetlink) instead of /proc/net/stat/nf_conntrack, which doesn't exist on
+// kernels built without CONFIG_NF_CONNTRACK_PROCFS. The same roger_nf_conntrack_* metrics
+// are emitted. Reading stats over netlink is only supported on Linux, requires the
+// nf_conntrack_netlink module, and requires CAP_NET_ADMIN.
+func NewNetlinkConntrackStatReader(logger log.Logger) *ProcNetStatReader {
+	reader := NewProcNetStatReaderWithSubsystem("", "nf_conntrack", "nf_conntrack", logger)
+	reader.path = netlinkConntrackPath
+	reader.source = newCtnetlinkSource()
+	reader.cpus = prometheus.NewDesc(
+		prometheus.BuildFQName("roger", "nf_conntrack", "cpus"),
+		"Number of CPUs conntrack stats were read for over netlink",
+		nil,
+		nil,
+	)
+
+	return reader
+}
@@ -0,0 +1,73 @@
+package roger
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const conntrackFixture = `ipv4     2 tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=53122 dport=22 src=10.0.0.2 dst=10.0.0.1 sport=22 dport=53122 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 tcp      6 431950 ESTABLISHED src=10.0.0.1 dst=10.0.0.3 sport=53124 dport=443 src=10.0.0.3 dst=10.0.0.1 sport=443 dport=53124 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 tcp      6 95 TIME_WAIT src=10.0.0.1 dst=10.0.0.4 sport=53126 dport=80 src=10.0.0.4 dst=10.0.0.1 sport=80 dport=53126 [ASSURED] mark=0 zone=0 use=2
+ipv4     2 udp      17 28 src=10.0.0.1 dst=10.0.0.53 sport=41234 dport=53 [UNREPLIED] src=10.0.0.53 dst=10.0.0.1 sport=53 dport=41234 mark=0 zone=0 use=2
+ipv6     10 udp      17 15 src=fd00::1 dst=fd00::53 sport=41235 dport=53 src=fd00::53 dst=fd00::1 sport=53 dport=41235 mark=0 zone=0 use=2
+`
+
+func TestProcNetConntrackReader_ReadMetrics(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/nf_conntrack", conntrackFixture)
+
+	reader := NewProcNetConntrackReader(base, log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	assert.Equal(t, map[ConntrackKey]uint64{
+		{Protocol: "tcp", State: "ESTABLISHED"}: 2,
+		{Protocol: "tcp", State: "TIME_WAIT"}:   1,
+		{Protocol: "udp", State: "none"}:        2,
+	}, res.Entries)
+	assert.False(t, res.Truncated)
+}
+
+func TestProcNetConntrackReader_ReadMetricsContextCanceled(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/nf_conntrack", conntrackFixture)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader := NewProcNetConntrackReader(base, log.NewNopLogger())
+	_, err := reader.ReadMetricsContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestProcNetConntrackReader_MaxEntries(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/nf_conntrack", conntrackFixture)
+
+	reader := NewProcNetConntrackReader(base, log.NewNopLogger())
+	reader.MaxEntries = 3
+
+	expected := `
+# HELP roger_conntrack_entries_by Number of entries in the connection tracking table by protocol and state
+# TYPE roger_conntrack_entries_by gauge
+roger_conntrack_entries_by{protocol="tcp",state="ESTABLISHED"} 2
+roger_conntrack_entries_by{protocol="tcp",state="TIME_WAIT"} 1
+# HELP roger_conntrack_entries_truncated 1 if the connection tracking table had more entries than the limit and some were not counted, 0 otherwise
+# TYPE roger_conntrack_entries_truncated gauge
+roger_conntrack_entries_truncated 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected)))
+}
+
+func TestProcNetConntrackReader_Missing(t *testing.T) {
+	reader := NewProcNetConntrackReader(t.TempDir(), log.NewNopLogger())
+	assert.False(t, reader.Exists())
+	assert.Error(t, reader.CollectWithError(make(chan<- prometheus.Metric)))
+}
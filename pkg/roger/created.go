@@ -0,0 +1,89 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// track when counter series were created for OpenMetrics _created timestamps
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type createdKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+type createdSeries struct {
+	created time.Time
+	last    float64
+	seen    bool
+}
+
+// createdTimes tracks when each counter series was first seen so that counters can
+// be emitted with a created timestamp, letting OpenMetrics consumers tell when they
+// were reset. Counters read from the DNS server or /proc were created before Roger
+// saw them but the time they were created isn't known, so the first time they were
+// seen is used instead. A counter that decreased was reset and is considered created
+// at the time the decrease was seen. Series that stop being emitted, such as those of
+// interfaces that were removed, are forgotten by sweep.
+type createdTimes struct {
+	now func() time.Time
+
+	lock   sync.Mutex
+	series map[createdKey]*createdSeries
+}
+
+func newCreatedTimes() *createdTimes {
+	return &createdTimes{now: time.Now, series: make(map[createdKey]*createdSeries)}
+}
+
+// counter returns a counter metric with the time the series was created.
+func (c *createdTimes) counter(desc *prometheus.Desc, val float64, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetricWithCreatedTimestamp(desc, prometheus.CounterValue, val, c.created(desc, val, labels), labels...)
+}
+
+// created returns the time the series was created, updating the last value of the series.
+func (c *createdTimes) created(desc *prometheus.Desc, val float64, labels []string) time.Time {
+	key := createdKey{desc: desc, labels: strings.Join(labels, "\xff")}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.series[key]
+	if !ok || val < s.last {
+		s = &createdSeries{created: c.now()}
+		c.series[key] = s
+	}
+
+	s.last = val
+	s.seen = true
+	return s.created
+}
+
+// sweep forgets series that weren't emitted since the previous sweep. It should be
+// called after each successful collection so that series missing from a failed one
+// keep their created time.
+func (c *createdTimes) sweep() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, s := range c.series {
+		if !s.seen {
+			delete(c.series, key)
+		} else {
+			s.seen = false
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package roger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatedTimes_Counter(t *testing.T) {
+	desc := prometheus.NewDesc("roger_test_total", "Test counter", []string{"server"}, nil)
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	created := newCreatedTimes()
+	created.now = func() time.Time { return now }
+
+	createdAt := func(val float64, server string) time.Time {
+		var m dto.Metric
+		require.NoError(t, created.counter(desc, val, server).Write(&m))
+		assert.Equal(t, val, m.GetCounter().GetValue())
+		return m.GetCounter().GetCreatedTimestamp().AsTime()
+	}
+
+	t.Run("first seen", func(t *testing.T) {
+		assert.Equal(t, start, createdAt(10, "127.0.0.1:53"))
+	})
+
+	t.Run("increase", func(t *testing.T) {
+		now = start.Add(time.Minute)
+		assert.Equal(t, start, createdAt(15, "127.0.0.1:53"))
+	})
+
+	t.Run("other series", func(t *testing.T) {
+		assert.Equal(t, now, createdAt(1, "[::1]:53"))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		now = start.Add(2 * time.Minute)
+		assert.Equal(t, now, createdAt(3, "127.0.0.1:53"))
+	})
+}
+
+func TestCreatedTimes_Sweep(t *testing.T) {
+	desc := prometheus.NewDesc("roger_test_total", "Test counter", []string{"interface"}, nil)
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	created := newCreatedTimes()
+	created.now = func() time.Time { return now }
+
+	created.created(desc, 1, []string{"eth0"})
+	created.created(desc, 1, []string{"veth1a2b"})
+	created.sweep()
+	assert.Len(t, created.series, 2)
+
+	// The veth interface went away so its series is forgotten after the next sweep
+	now = start.Add(time.Minute)
+	assert.Equal(t, start, created.created(desc, 2, []string{"eth0"}))
+	created.sweep()
+	assert.Len(t, created.series, 1)
+
+	assert.Equal(t, now, created.created(desc, 2, []string{"veth1a2b"}))
+}
@@ -0,0 +1,329 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// query conntrack stats from the kernel using netfilter netlink messages
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Message types and attributes from linux/netfilter/nfnetlink.h and
+// linux/netfilter/nfnetlink_conntrack.h
+const (
+	nfnetlinkV0            = 0
+	nfgenmsgLen            = 4
+	nfnlSubsysCtnetlink    = 1
+	nfnlSubsysCtnetlinkExp = 2
+
+	ipctnlMsgCtGetStatsCPU  = 4
+	ipctnlMsgCtGetStats     = 5
+	ipctnlMsgExpGetStatsCPU = 3
+
+	ctaStatsGlobalEntries = 1
+
+	// nlaTypeMask clears the nested and byte order flags from attribute types
+	nlaTypeMask = 0x3fff
+	nlaAlignTo  = 4
+)
+
+// ctnetlinkTimeout is the time allowed for the kernel to answer each request.
+const ctnetlinkTimeout = 2 * time.Second
+
+// ctnetlinkStatsCPU are the columns of /proc/net/stat/nf_conntrack for each per-CPU
+// conntrack stats attribute, keyed by attribute type.
+var ctnetlinkStatsCPU = map[uint16]string{
+	1:  "searched",
+	2:  "found",
+	3:  "new",
+	4:  "invalid",
+	5:  "ignore",
+	6:  "delete",
+	7:  "delete_list",
+	8:  "insert",
+	9:  "insert_failed",
+	10: "drop",
+	11: "early_drop",
+	12: "icmp_error",
+	13: "search_restart",
+	14: "clashres",
+	15: "chaintoolong",
+}
+
+// ctnetlinkExpStatsCPU are the columns of /proc/net/stat/nf_conntrack for each
+// per-CPU expectation stats attribute, keyed by attribute type.
+var ctnetlinkExpStatsCPU = map[uint16]string{
+	1: "expect_new",
+	2: "expect_create",
+	3: "expect_delete",
+}
+
+var (
+	errShortNetlinkMessage = errors.New("netlink message too short")
+	errMalformedAttribute  = errors.New("malformed netlink attribute")
+)
+
+// nativeEndian is the byte order of netlink headers and attribute headers, values of
+// netfilter attributes are big endian.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}()
+
+type ctnetlinkSource struct {
+	// noExpectations is set once reading expectation stats fails so that collections
+	// don't wait on a subsystem that doesn't answer.
+	noExpectations atomic.Bool
+}
+
+func newCtnetlinkSource() netStatSource {
+	return &ctnetlinkSource{}
+}
+
+// exists returns true if conntrack stats can be read over netlink, which requires the
+// nf_conntrack_netlink module and CAP_NET_ADMIN.
+func (s *ctnetlinkSource) exists() bool {
+	_, err := s.rows()
+	return err == nil
+}
+
+// rows returns the conntrack stats of each CPU with the same columns as
+// /proc/net/stat/nf_conntrack, including the number of entries in every row.
+func (s *ctnetlinkSource) rows() ([]map[string]uint64, error) {
+	conn, err := dialNetfilter()
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.close()
+
+	byCPU := make(map[uint16]map[string]uint64)
+	addStats := func(columns map[uint16]string) func(cpu uint16, attrs []netlinkAttr) {
+		return func(cpu uint16, attrs []netlinkAttr) {
+			row, ok := byCPU[cpu]
+			if !ok {
+				row = make(map[string]uint64)
+				byCPU[cpu] = row
+			}
+
+			addCtnetlinkStats(row, columns, attrs)
+		}
+	}
+
+	if err := conn.request(nfnlSubsysCtnetlink, ipctnlMsgCtGetStatsCPU, true, addStats(ctnetlinkStatsCPU)); err != nil {
+		return nil, fmt.Errorf("unable to read conntrack stats over netlink: %w", err)
+	}
+
+	// Expectations (used by helpers for protocols such as FTP) are a separate subsystem
+	// that isn't always available, only their columns are missing if it isn't.
+	if !s.noExpectations.Load() {
+		if err := conn.request(nfnlSubsysCtnetlinkExp, ipctnlMsgExpGetStatsCPU, true, addStats(ctnetlinkExpStatsCPU)); err != nil {
+			s.noExpectations.Store(true)
+		}
+	}
+
+	var entries *uint64
+	err = conn.request(nfnlSubsysCtnetlink, ipctnlMsgCtGetStats, false, func(_ uint16, attrs []netlinkAttr) {
+		for _, a := range attrs {
+			if a.typ == ctaStatsGlobalEntries && len(a.data) >= 4 {
+				val := uint64(binary.BigEndian.Uint32(a.data))
+				entries = &val
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read conntrack entries over netlink: %w", err)
+	}
+
+	cpus := make([]int, 0, len(byCPU))
+	for cpu := range byCPU {
+		cpus = append(cpus, int(cpu))
+	}
+	sort.Ints(cpus)
+
+	// Like /proc/net/stat/nf_conntrack, every row includes the number of entries in
+	// the table which is shared by all CPUs.
+	out := make([]map[string]uint64, 0, len(cpus))
+	for _, cpu := range cpus {
+		row := byCPU[uint16(cpu)]
+		if entries != nil {
+			row[entriesHeader] = *entries
+		}
+
+		out = append(out, row)
+	}
+
+	return out, nil
+}
+
+// addCtnetlinkStats adds the value of each known attribute to the row using the
+// column for the attribute. Values are 32 bit, big endian integers.
+func addCtnetlinkStats(row map[string]uint64, columns map[uint16]string, attrs []netlinkAttr) {
+	for _, a := range attrs {
+		column, ok := columns[a.typ]
+		if !ok || len(a.data) < 4 {
+			continue
+		}
+
+		row[column] = uint64(binary.BigEndian.Uint32(a.data))
+	}
+}
+
+type netlinkAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// parseNetlinkAttrs parses a sequence of netlink attributes, each a length and type
+// followed by a value padded to a multiple of four bytes.
+func parseNetlinkAttrs(b []byte) ([]netlinkAttr, error) {
+	var out []netlinkAttr
+
+	for len(b) >= 4 {
+		length := int(nativeEndian.Uint16(b[0:2]))
+		typ := nativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		if length < 4 || length > len(b) {
+			return nil, errMalformedAttribute
+		}
+
+		out = append(out, netlinkAttr{typ: typ, data: b[4:length]})
+
+		aligned := (length + nlaAlignTo - 1) &^ (nlaAlignTo - 1)
+		if aligned >= len(b) {
+			break
+		}
+
+		b = b[aligned:]
+	}
+
+	return out, nil
+}
+
+// netfilterConn is a netlink socket for making requests to netfilter subsystems.
+type netfilterConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialNetfilter() (*netfilterConn, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open netfilter netlink socket: %w", err)
+	}
+
+	tv := syscall.NsecToTimeval(ctnetlinkTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("unable to set netfilter netlink socket timeout: %w", err)
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("unable to bind netfilter netlink socket: %w", err)
+	}
+
+	return &netfilterConn{fd: fd}, nil
+}
+
+func (c *netfilterConn) close() {
+	_ = syscall.Close(c.fd)
+}
+
+// netfilterRequest builds a netlink message of the given type for a netfilter subsystem
+// with an nfgenmsg header and no attributes.
+func netfilterRequest(subsys uint8, msg uint8, dump bool, seq uint32) []byte {
+	flags := uint16(syscall.NLM_F_REQUEST)
+	if dump {
+		flags |= syscall.NLM_F_DUMP
+	}
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+nfgenmsgLen)
+	nativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	nativeEndian.PutUint16(req[4:6], uint16(subsys)<<8|uint16(msg))
+	nativeEndian.PutUint16(req[6:8], flags)
+	nativeEndian.PutUint32(req[8:12], seq)
+	req[syscall.NLMSG_HDRLEN] = syscall.AF_UNSPEC
+	req[syscall.NLMSG_HDRLEN+1] = nfnetlinkV0
+
+	return req
+}
+
+// request sends a message of the given type to a netfilter subsystem, calling fn with
+// the resource ID (the CPU for per-CPU stats) and attributes of each message in the
+// response. Dump requests may have any number of messages in the response, others
+// have a single message.
+func (c *netfilterConn) request(subsys uint8, msg uint8, dump bool, fn func(resID uint16, attrs []netlinkAttr)) error {
+	c.seq++
+
+	req := netfilterRequest(subsys, msg, dump, c.seq)
+	if err := syscall.Sendto(c.fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return errShortNetlinkMessage
+				}
+
+				if code := int32(nativeEndian.Uint32(m.Data[:4])); code != 0 {
+					return syscall.Errno(-code)
+				}
+
+				return nil
+			}
+
+			if len(m.Data) < nfgenmsgLen {
+				return errShortNetlinkMessage
+			}
+
+			attrs, err := parseNetlinkAttrs(m.Data[nfgenmsgLen:])
+			if err != nil {
+				return err
+			}
+
+			fn(binary.BigEndian.Uint16(m.Data[2:4]), attrs)
+			if !dump {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package roger
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// netlinkAttrBytes encodes an attribute with a 32 bit big endian value.
+func netlinkAttrBytes(typ uint16, val uint32) []byte {
+	b := make([]byte, 8)
+	nativeEndian.PutUint16(b[0:2], 8)
+	nativeEndian.PutUint16(b[2:4], typ)
+	b[4], b[5], b[6], b[7] = byte(val>>24), byte(val>>16), byte(val>>8), byte(val)
+	return b
+}
+
+func TestParseNetlinkAttrs(t *testing.T) {
+	t.Run("attributes", func(t *testing.T) {
+		var b []byte
+		b = append(b, netlinkAttrBytes(2, 100)...)
+		b = append(b, netlinkAttrBytes(9, 3)...)
+		// Padding after a 2 byte value
+		b = append(b, 6, 0, 20, 0, 0xab, 0xcd, 0, 0)
+		nativeEndian.PutUint16(b[16:18], 6)
+		nativeEndian.PutUint16(b[18:20], 20)
+
+		attrs, err := parseNetlinkAttrs(b)
+		require.NoError(t, err)
+		require.Len(t, attrs, 3)
+		assert.Equal(t, uint16(2), attrs[0].typ)
+		assert.Equal(t, uint16(9), attrs[1].typ)
+		assert.Equal(t, netlinkAttr{typ: 20, data: []byte{0xab, 0xcd}}, attrs[2])
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		b := netlinkAttrBytes(2, 100)
+		nativeEndian.PutUint16(b[0:2], 12)
+
+		_, err := parseNetlinkAttrs(b)
+		assert.ErrorIs(t, err, errMalformedAttribute)
+	})
+}
+
+func TestAddCtnetlinkStats(t *testing.T) {
+	var b []byte
+	b = append(b, netlinkAttrBytes(2, 100)...)
+	b = append(b, netlinkAttrBytes(12, 7)...)
+	b = append(b, netlinkAttrBytes(99, 1)...)
+
+	attrs, err := parseNetlinkAttrs(b)
+	require.NoError(t, err)
+
+	row := make(map[string]uint64)
+	addCtnetlinkStats(row, ctnetlinkStatsCPU, attrs)
+	assert.Equal(t, map[string]uint64{"found": 100, "icmp_error": 7}, row)
+}
+
+func TestNetfilterRequest(t *testing.T) {
+	t.Run("expectation stats", func(t *testing.T) {
+		// IPCTNL_MSG_EXP_GET_STATS_CPU, not IPCTNL_MSG_EXP_DELETE which flushes expectations
+		req := netfilterRequest(nfnlSubsysCtnetlinkExp, ipctnlMsgExpGetStatsCPU, true, 7)
+		require.Len(t, req, 20)
+		assert.Equal(t, uint32(20), nativeEndian.Uint32(req[0:4]))
+		assert.Equal(t, uint16(2<<8|3), nativeEndian.Uint16(req[4:6]))
+		assert.Equal(t, uint16(syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP), nativeEndian.Uint16(req[6:8]))
+		assert.Equal(t, uint32(7), nativeEndian.Uint32(req[8:12]))
+		assert.Equal(t, []byte{syscall.AF_UNSPEC, nfnetlinkV0, 0, 0}, req[16:20])
+	})
+
+	t.Run("conntrack stats", func(t *testing.T) {
+		req := netfilterRequest(nfnlSubsysCtnetlink, ipctnlMsgCtGetStatsCPU, true, 1)
+		assert.Equal(t, uint16(1<<8|4), nativeEndian.Uint16(req[4:6]))
+		assert.Equal(t, uint16(syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP), nativeEndian.Uint16(req[6:8]))
+
+		req = netfilterRequest(nfnlSubsysCtnetlink, ipctnlMsgCtGetStats, false, 1)
+		assert.Equal(t, uint16(1<<8|5), nativeEndian.Uint16(req[4:6]))
+		assert.Equal(t, uint16(syscall.NLM_F_REQUEST), nativeEndian.Uint16(req[6:8]))
+	})
+}
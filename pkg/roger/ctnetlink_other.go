@@ -0,0 +1,33 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+//go:build !linux
+
+package roger
+
+// netlink isn't available on other platforms
+
+import "errors"
+
+var errCtnetlinkUnsupported = errors.New("conntrack stats over netlink are only supported on Linux")
+
+type ctnetlinkSource struct{}
+
+func newCtnetlinkSource() netStatSource {
+	return ctnetlinkSource{}
+}
+
+func (ctnetlinkSource) exists() bool {
+	return false
+}
+
+func (ctnetlinkSource) rows() ([]map[string]uint64, error) {
+	return nil, errCtnetlinkUnsupported
+}
@@ -11,10 +11,17 @@
 package roger
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
+	"net/netip"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/log"
@@ -28,22 +35,129 @@ var (
 	ErrNumAnswers   = errors.New("unexpected number of answers")
 	ErrNumQuestions = errors.New("unexpected number of questions")
 	ErrParseAnswer  = errors.New("error parsing answer")
+
+	errNotTXT    = errors.New("not a TXT record")
+	errEmptyTXT  = errors.New("empty TXT record")
+	errTruncated = errors.New("truncated response without answers")
+)
+
+// rcodeError is the response code of a response from the server indicating an error.
+type rcodeError int
+
+func (e rcodeError) Error() string {
+	return "server returned " + dns.RcodeToString[int(e)]
+}
+
+// Layouts of DNS cache metrics, see DnsmasqReader.MetricLayout.
+const (
+	// DnsMetricLayoutSeparate emits a metric for each cache event, e.g.
+	// roger_dns_cache_hits_total and roger_dns_cache_misses_total.
+	DnsMetricLayoutSeparate = "separate"
+
+	// DnsMetricLayoutLabeled emits a single roger_dns_cache_events_total metric
+	// with an "event" label for each cache event.
+	DnsMetricLayoutLabeled = "labeled"
+)
+
+// Names of the classes stats queries can be made with, see DnsmasqReader.QueryClass.
+const (
+	QueryClassChaos = "chaos"
+	QueryClassINET  = "inet"
 )
 
+var queryClasses = map[string]uint16{
+	QueryClassChaos: dns.ClassCHAOS,
+	QueryClassINET:  dns.ClassINET,
+}
+
+// ParseQueryClass returns the class for the name of a class, QueryClassChaos or
+// QueryClassINET.
+func ParseQueryClass(name string) (uint16, error) {
+	class, ok := queryClasses[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown query class %q", name)
+	}
+
+	return class, nil
+}
+
+// Reasons that answers were dropped, used as the "reason" label for the
+// roger_dns_answers_dropped_total metric.
+const (
+	dropReasonNotTXT  = "not_txt"
+	dropReasonEmpty   = "empty"
+	dropReasonInvalid = "invalid"
+)
+
+// Sections of a response that unexpected records were found in, used as the "section"
+// label for the roger_dns_unexpected_records_total metric.
+const (
+	sectionAnswer     = "answer"
+	sectionAuthority  = "authority"
+	sectionAdditional = "additional"
+)
+
+// Reasons that scrapes failed, used as the "reason" label for the
+// roger_dns_scrape_errors_total metric.
+const (
+	scrapeErrorTimeout     = "timeout"
+	scrapeErrorConnRefused = "connection_refused"
+	scrapeErrorTruncated   = "truncated"
+	scrapeErrorServFail    = "servfail"
+	scrapeErrorRefused     = "refused"
+	scrapeErrorOther       = "other"
+)
+
+// DefaultInfoTTL is how long the version of the DNS server is cached for by default
+// since it only changes when the server is upgraded.
+const DefaultInfoTTL = 1 * time.Hour
+
+// DefaultRTTBuckets are the buckets of the stats query RTT histogram, in seconds. They
+// range from a fraction of a millisecond for local resolvers to hundreds of milliseconds.
+var DefaultRTTBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
 // dnsClient is an interface for to allow testing of DnsmasqReader
 type dnsClient interface {
 	Exchange(m *dns.Msg, address string) (r *dns.Msg, rtt time.Duration, err error)
 }
 
+// transportClient is a dnsClient that can try multiple transports and report
+// which of them the response was received over, such as FallbackClient.
+type transportClient interface {
+	ExchangeTransport(m *dns.Msg, address string) (r *dns.Msg, rtt time.Duration, protocol string, err error)
+}
+
+// retryingClient is a dnsClient that retries truncated responses with another
+// transport, such as FallbackClient, counting the truncated responses it retried.
+type retryingClient interface {
+	TruncatedRetries(address string) uint64
+}
+
 type descriptions struct {
 	dnsCacheSize       *prometheus.Desc
 	dnsCacheInsertions *prometheus.Desc
 	dnsCacheEvictions  *prometheus.Desc
 	dnsCacheMisses     *prometheus.Desc
 	dnsCacheHits       *prometheus.Desc
+	dnsCacheEvents     *prometheus.Desc
 	dnsAuthoritative   *prometheus.Desc
 	dnsUpstreamQueries *prometheus.Desc
 	dnsUpstreamErrors  *prometheus.Desc
+	dnsQueriesPerSec   *prometheus.Desc
+	dnsRecentHitRatio  *prometheus.Desc
+	dnsEDNS0Supported  *prometheus.Desc
+	dnsAnswersDropped  *prometheus.Desc
+	dnsScrapeAttempts  *prometheus.Desc
+	dnsScrapeErrors    *prometheus.Desc
+	dnsResponseRTT     *prometheus.Desc
+	dnsResponseSize    *prometheus.Desc
+	dnsRespQuestions   *prometheus.Desc
+	dnsRespAnswers     *prometheus.Desc
+	dnsServerInfo      *prometheus.Desc
+	dnsUnexpected      *prometheus.Desc
+	dnsAnomalies       *prometheus.Desc
+	dnsTruncated       *prometheus.Desc
+	dnsUp              *prometheus.Desc
 }
 
 func newDescriptions() *descriptions {
@@ -79,6 +193,12 @@ func newDescriptions() *descriptions {
 			[]string{"server"},
 			nil,
 		),
+		dnsCacheEvents: prometheus.NewDesc(
+			"roger_dns_cache_events_total",
+			"Number of insertions, evictions, hits, and misses in the DNS cache, by event",
+			[]string{"server", "event"},
+			nil,
+		),
 		dnsAuthoritative: prometheus.NewDesc(
 			"roger_dns_authoritative_total",
 			"Number of authoritative DNS queries answered",
@@ -88,129 +208,621 @@ func newDescriptions() *descriptions {
 		dnsUpstreamQueries: prometheus.NewDesc(
 			"roger_dns_upstream_queries_total",
 			"Number of queries sent to upstream servers",
-			[]string{"server", "upstream"},
+			[]string{"server", "upstream", "family"},
 			nil,
 		),
 		dnsUpstreamErrors: prometheus.NewDesc(
 			"roger_dns_upstream_errors_total",
 			"Number of errors from upstream servers",
-			[]string{"server", "upstream"},
+			[]string{"server", "upstream", "family"},
+			nil,
+		),
+		dnsQueriesPerSec: prometheus.NewDesc(
+			"roger_dns_queries_per_second",
+			"Queries (cache hits, misses, and authoritative) per second since the previous collection",
+			[]string{"server"},
+			nil,
+		),
+		dnsRecentHitRatio: prometheus.NewDesc(
+			"roger_dns_cache_hit_ratio_recent",
+			"Ratio of cache hits to cache hits and misses since the previous collection",
+			[]string{"server"},
+			nil,
+		),
+		dnsEDNS0Supported: prometheus.NewDesc(
+			"roger_dns_edns0_supported",
+			"If the DNS server returned an EDNS0 OPT record (1) or not (0)",
+			[]string{"server"},
+			nil,
+		),
+		dnsAnswersDropped: prometheus.NewDesc(
+			"roger_dns_answers_dropped_total",
+			"Number of answers from the DNS server that could not be parsed, by reason",
+			[]string{"server", "reason"},
+			nil,
+		),
+		dnsUnexpected: prometheus.NewDesc(
+			"roger_dns_unexpected_records_total",
+			"Number of records from the DNS server that weren't asked for and were ignored, by section of the response",
+			[]string{"server", "section"},
+			nil,
+		),
+		dnsAnomalies: prometheus.NewDesc(
+			"roger_dns_counter_anomaly_total",
+			"Number of times a counter from the DNS server decreased without the server restarting, by counter",
+			[]string{"server", "counter"},
+			nil,
+		),
+		dnsTruncated: prometheus.NewDesc(
+			"roger_dns_truncated_responses_total",
+			"Number of responses from the DNS server that were truncated",
+			[]string{"server"},
+			nil,
+		),
+		dnsUp: prometheus.NewDesc(
+			"roger_dns_up",
+			"If the most recent attempt to read metrics from the DNS server succeeded (1) or not (0)",
+			[]string{"server"},
+			nil,
+		),
+		dnsScrapeAttempts: prometheus.NewDesc(
+			"roger_dns_scrape_attempts_total",
+			"Number of attempts to read metrics from the DNS server",
+			[]string{"server"},
+			nil,
+		),
+		dnsScrapeErrors: prometheus.NewDesc(
+			"roger_dns_scrape_errors_total",
+			"Number of failed attempts to read metrics from the DNS server, by reason",
+			[]string{"server", "reason"},
+			nil,
+		),
+		dnsResponseRTT: prometheus.NewDesc(
+			"roger_dns_response_rtt_seconds",
+			"Round trip time of the most recent stats query in seconds, by transport used",
+			[]string{"server", "transport"},
+			nil,
+		),
+		dnsResponseSize: prometheus.NewDesc(
+			"roger_dns_response_size_bytes",
+			"Size of the most recent stats response in bytes, by transport used",
+			[]string{"server", "transport"},
+			nil,
+		),
+		dnsRespQuestions: prometheus.NewDesc(
+			"roger_dns_response_questions",
+			"Number of questions in the most recent response from the DNS server",
+			[]string{"server"},
+			nil,
+		),
+		dnsRespAnswers: prometheus.NewDesc(
+			"roger_dns_response_answers",
+			"Number of answers in the most recent response from the DNS server",
+			[]string{"server"},
+			nil,
+		),
+		dnsServerInfo: prometheus.NewDesc(
+			"roger_dns_server_info",
+			"DNS server version from a version.bind. query, always 1",
+			[]string{"server", "version"},
 			nil,
 		),
 	}
 }
 
 type DnsmasqResult struct {
-	CacheSize       uint64
-	CacheInsertions uint64
-	CacheEvictions  uint64
-	CacheMisses     uint64
-	CacheHits       uint64
-	Authoritative   uint64
-	Servers         []ServerStats
+	CacheSize       uint64        `json:"cache_size"`
+	CacheInsertions uint64        `json:"cache_insertions"`
+	CacheEvictions  uint64        `json:"cache_evictions"`
+	CacheMisses     uint64        `json:"cache_misses"`
+	CacheHits       uint64        `json:"cache_hits"`
+	Authoritative   uint64        `json:"authoritative"`
+	Servers         []ServerStats `json:"servers"`
+	EDNS0           bool          `json:"edns0"`
+	// Transport is the protocol the response was received over.
+	Transport    string        `json:"transport"`
+	RTT          time.Duration `json:"rtt"`
+	ResponseSize int           `json:"response_size"`
+	// Extra are the values of ExtraQueries, keyed by name without the ChaosSuffix.
+	Extra map[string]uint64 `json:"extra,omitempty"`
+	// Dropped are answers that could not be parsed. The corresponding values
+	// above are not set.
+	Dropped []DroppedAnswer `json:"dropped,omitempty"`
+	// Unexpected are the number of records that weren't asked for and were ignored,
+	// keyed by section of the response. OPT records aren't unexpected.
+	Unexpected map[string]uint64 `json:"unexpected,omitempty"`
+}
+
+type DroppedAnswer struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// dropped returns true if the answer with the given name was dropped.
+func (r *DnsmasqResult) dropped(name string) bool {
+	for _, d := range r.Dropped {
+		if d.Name == name {
+			return true
+		}
+	}
+
+	return false
 }
 
 type ServerStats struct {
-	Address     string
-	QueriesSent uint64
-	QueryErrors uint64
+	Address     string `json:"address"`
+	QueriesSent uint64 `json:"queries_sent"`
+	QueryErrors uint64 `json:"query_errors"`
+	// Family is the address family of the server, "ipv4", "ipv6", or
+	// "unknown" when the address can't be parsed.
+	Family string `json:"family"`
 }
 
 type DnsmasqReader struct {
+	// EDNS0Size is the UDP buffer size to advertise to the server using
+	// EDNS0. EDNS0 is not used when zero.
+	EDNS0Size uint16
+
+	// RecursionDesired sets the RD bit on stats queries. Recursion isn't relevant
+	// for CHAOS queries but is set by default for compatibility, some strict
+	// servers reject queries that set it.
+	RecursionDesired bool
+
+	// IDGenerator returns the ID to use for each stats query. Defaults to
+	// dns.Id which returns a random ID. Tests and proxies that match queries
+	// by ID can use a fixed or sequential ID instead.
+	IDGenerator func() uint16
+
+	// LogRawAnswers logs the name and contents of each answer from the server
+	// at debug level before parsing, to help diagnose parse failures.
+	LogRawAnswers bool
+
+	// Identity, if set, is sent in an EDNS0 NSID option of each stats query so
+	// that server logs can attribute the queries to Roger. An OPT record is added
+	// to queries for this even if EDNS0Size is zero, advertising the same 512 byte
+	// buffer size that is implied without EDNS0.
+	Identity string
+
+	// RTTBuckets are the buckets of the roger_dns_scrape_rtt_seconds histogram.
+	// Defaults to DefaultRTTBuckets. Must be set before the reader is registered.
+	RTTBuckets []float64
+
+	// Dialer, if set, is used to create a TCP connection to the server for each
+	// query instead of the client. This allows making queries through a tunnel or
+	// proxy whose lifecycle is managed outside of Roger.
+	Dialer ContextDialer
+
+	// DialerTimeout is the time allowed for dialing and making each query with the
+	// Dialer. Defaults to two seconds, the default timeouts of dns.Client, when zero.
+	DialerTimeout time.Duration
+
+	// NumberBase is the base of the integers in answers from the server. dnsmasq
+	// uses base 10, the default, but other servers may use a different base.
+	NumberBase int
+
+	// ExtraQueries are names of additional counters to query, without the ChaosSuffix,
+	// for counters only exposed by some builds of dnsmasq. Each is emitted as an untyped
+	// roger_dns_<name> metric since whether it's a counter or gauge isn't known.
+	ExtraQueries []string
+
+	// ChaosSuffix is appended to the name of each counter to build the name of the
+	// CHAOS TXT query for it. Defaults to ".bind." as used by dnsmasq.
+	ChaosSuffix string
+
+	// QueryClass is the class of each query. Defaults to dns.ClassCHAOS as used by
+	// dnsmasq, some resolvers answer the same names with dns.ClassINET instead. See
+	// WithQueryClass.
+	QueryClass uint16
+
+	// Retries is the number of times to retry a query that fails, e.g. due to a
+	// timeout, before giving up. Queries are not retried when zero.
+	Retries int
+
+	// DetectAnomalies compares counters from the server to their values as of the
+	// previous collection and counts those that decreased without the server restarting,
+	// which indicates a parsing bug or a misbehaving server. See checkCounters.
+	DetectAnomalies bool
+
+	// InfoTTL is how long the answer to the version.bind. query for roger_dns_server_info
+	// is cached for, so that it's only queried again periodically instead of on every
+	// collection. Defaults to DefaultInfoTTL.
+	InfoTTL time.Duration
+
+	// ServerLabel is the value of the server label of metrics for the server. Defaults
+	// to the address of the server, see ServerHostname for labeling by hostname instead.
+	// Must be set before the reader is registered.
+	ServerLabel string
+
+	// MetricLayout selects how cache insertions, evictions, hits, and misses are
+	// emitted, either DnsMetricLayoutSeparate (the default when empty) or
+	// DnsMetricLayoutLabeled.
+	MetricLayout string
+
+	// UpstreamFilter, if set, selects which upstream servers roger_dns_upstream_*
+	// metrics are emitted for, by address as reported by the server. Metrics for
+	// all upstream servers are emitted when nil.
+	UpstreamFilter func(upstream string) bool
+
 	client       dnsClient
 	address      string
 	descriptions *descriptions
+	extraDescs   *descriptionCache
 	logger       log.Logger
+	now          func() time.Time
+
+	// State kept between collections: total queries and time of the previous
+	// collection, used to estimate the current query rate, and running totals
+	// for counters. Guarded by lock since collectors must be safe to be called
+	// concurrently.
+	lock           sync.Mutex
+	prevQueries    uint64
+	prevCollected  time.Time
+	prevLookups    *cacheLookups
+	created        *createdTimes
+	dropped        map[string]uint64
+	unexpected     map[string]uint64
+	prevCounters   map[string]uint64
+	anomalies      map[string]uint64
+	scrapeAttempts uint64
+	truncated      uint64
+	scrapeErrors   map[string]uint64
+	lastResponse   *responseCounts
+	version        string
+	versionChecked time.Time
+
+	rttOnce      sync.Once
+	rttHistogram prometheus.Histogram
+}
+
+// cacheLookups are the number of cache hits and misses as of a collection.
+type cacheLookups struct {
+	hits   uint64
+	misses uint64
+}
+
+// responseCounts are the number of questions and answers in a response from the server.
+type responseCounts struct {
+	questions int
+	answers   int
 }
 
 func NewDnsmasqReader(client dnsClient, address string, logger log.Logger) *DnsmasqReader {
+	return newDnsmasqReader(client, address, newDescriptions(), newDescriptionCache(), logger)
+}
+
+// newDnsmasqReader creates a reader using existing descriptions, allowing them to be
+// shared between readers for different servers.
+func newDnsmasqReader(client dnsClient, address string, descriptions *descriptions, extraDescs *descriptionCache, logger log.Logger) *DnsmasqReader {
 	return &DnsmasqReader{
+		RecursionDesired: true,
+		IDGenerator:      dns.Id,
+		NumberBase:       10,
+		ChaosSuffix:      ".bind.",
+		QueryClass:       dns.ClassCHAOS,
+		InfoTTL:          DefaultInfoTTL,
+
 		client:       client,
 		address:      address,
-		descriptions: newDescriptions(),
+		descriptions: descriptions,
+		extraDescs:   extraDescs,
 		logger:       logger,
+		now:          time.Now,
+		created:      newCreatedTimes(),
+		dropped:      make(map[string]uint64),
+		unexpected:   map[string]uint64{sectionAnswer: 0, sectionAuthority: 0, sectionAdditional: 0},
+		anomalies:    make(map[string]uint64),
+		scrapeErrors: newScrapeErrorCounts(),
 	}
 }
 
-// ReadMetrics makes a DNS request to get all known dnsmasq metrics
+// exchange sends the message to the server, retrying up to Retries times, returning
+// the response along with the protocol it was received over.
+func (d *DnsmasqReader) exchange(m *dns.Msg) (*dns.Msg, time.Duration, string, error) {
+	for attempt := 0; ; attempt++ {
+		r, rtt, protocol, err := d.exchangeOnce(m)
+		if err == nil || attempt >= d.Retries {
+			return r, rtt, protocol, err
+		}
+
+		level.Debug(d.logger).Log("msg", "retrying failed DNS query", "addr", d.address, "attempt", attempt+1, "err", err)
+	}
+}
+
+// exchangeOnce sends the message to the server, returning the response along with
+// the protocol it was received over. The protocol is "unknown" if the client doesn't
+// support reporting it.
+func (d *DnsmasqReader) exchangeOnce(m *dns.Msg) (*dns.Msg, time.Duration, string, error) {
+	if d.Dialer != nil {
+		r, rtt, err := exchangeWithDialer(d.Dialer, d.DialerTimeout, m, d.address)
+		return r, rtt, ProtocolTCP, err
+	}
+
+	switch c := d.client.(type) {
+	case transportClient:
+		return c.ExchangeTransport(m, d.address)
+	case *dns.Client:
+		protocol := c.Net
+		if protocol == "" {
+			protocol = ProtocolUDP
+		}
+
+		r, rtt, err := c.Exchange(m, d.address)
+		return r, rtt, protocol, err
+	default:
+		r, rtt, err := c.Exchange(m, d.address)
+		return r, rtt, "unknown", err
+	}
+}
+
+// logRawAnswer logs the TXT contents of an answer as returned by the server or the
+// entire record if it isn't a TXT record.
+func (d *DnsmasqReader) logRawAnswer(ans dns.RR) {
+	if txt, ok := ans.(*dns.TXT); ok {
+		level.Debug(d.logger).Log("msg", "raw answer from DNS server", "name", txt.Hdr.Name, "txt", fmt.Sprintf("%q", txt.Txt))
+	} else {
+		level.Debug(d.logger).Log("msg", "raw answer from DNS server", "name", ans.Header().Name, "record", ans.String())
+	}
+}
+
+// ReadMetrics makes a DNS request to get all known dnsmasq metrics. If some of the
+// answers could not be parsed, the result for the remaining answers is returned along
+// with an error wrapping ErrParseAnswer.
 func (d *DnsmasqReader) ReadMetrics() (*DnsmasqResult, error) {
 	m := &dns.Msg{}
-	m.MsgHdr = dns.MsgHdr{Id: dns.Id(), RecursionDesired: true}
+	m.MsgHdr = dns.MsgHdr{Id: d.IDGenerator(), RecursionDesired: d.RecursionDesired}
 	m.Question = []dns.Question{
-		question("cachesize.bind."),
-		question("insertions.bind."),
-		question("evictions.bind."),
-		question("misses.bind."),
-		question("hits.bind."),
-		question("auth.bind."),
-		question("servers.bind."),
+		d.question(d.queryName("cachesize")),
+		d.question(d.queryName("insertions")),
+		d.question(d.queryName("evictions")),
+		d.question(d.queryName("misses")),
+		d.question(d.queryName("hits")),
+		d.question(d.queryName("auth")),
+		d.question(d.queryName("servers")),
+	}
+
+	for _, name := range d.ExtraQueries {
+		m.Question = append(m.Question, d.question(d.queryName(name)))
+	}
+
+	if d.EDNS0Size > 0 {
+		m.SetEdns0(d.EDNS0Size, false)
+	} else if d.Identity != "" {
+		m.SetEdns0(dns.MinMsgSize, false)
 	}
 
-	// TODO(56quarters) emit RTT as a metric
-	res, _, err := d.client.Exchange(m, d.address)
+	if d.Identity != "" {
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(d.Identity))})
+	}
+
+	res, rtt, protocol, err := d.exchange(m)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrUpstream, err)
+		return nil, fmt.Errorf("%w: %w", ErrUpstream, err)
+	}
+
+	d.lock.Lock()
+	d.lastResponse = &responseCounts{questions: len(res.Question), answers: len(res.Answer)}
+	if res.Truncated {
+		d.truncated++
+	}
+	d.lock.Unlock()
+
+	if res.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%w: %w", ErrUpstream, rcodeError(res.Rcode))
+	}
+
+	// Truncated responses with some answers are still used, the answers that are
+	// missing are the same as ones the server didn't answer.
+	if res.Truncated && len(res.Answer) == 0 {
+		return nil, fmt.Errorf("%w: %w", ErrUpstream, errTruncated)
+	}
+
+	out := &DnsmasqResult{
+		EDNS0:        res.IsEdns0() != nil,
+		Transport:    protocol,
+		RTT:          rtt,
+		ResponseSize: res.Len(),
+	}
+	var parseErrs []error
+
+	// Answers that can't be parsed are dropped and recorded in the result instead of
+	// failing the entire read, so that the remaining values can still be used.
+	drop := func(ans dns.RR, what string, err error) {
+		out.Dropped = append(out.Dropped, DroppedAnswer{Name: ans.Header().Name, Reason: dropReason(err)})
+		parseErrs = append(parseErrs, fmt.Errorf("%w %s: %s", ErrParseAnswer, what, err))
+	}
+
+	// Records that weren't asked for are ignored rather than failing the read, some
+	// servers or middleboxes add them to every response.
+	unexpected := func(section string) {
+		if out.Unexpected == nil {
+			out.Unexpected = make(map[string]uint64)
+		}
+		out.Unexpected[section]++
+	}
+
+	for range res.Ns {
+		unexpected(sectionAuthority)
 	}
 
-	var (
-		cacheSize       uint64
-		cacheInsertions uint64
-		cacheEvictions  uint64
-		cacheMisses     uint64
-		cacheHits       uint64
-		authoritative   uint64
-		servers         []ServerStats
-	)
+	for _, rr := range res.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			unexpected(sectionAdditional)
+		}
+	}
 
 	for _, ans := range res.Answer {
+		if d.LogRawAnswers {
+			d.logRawAnswer(ans)
+		}
+
 		switch ans.Header().Name {
-		case "cachesize.bind.":
-			cacheSize, err = parseIntRecord(ans)
+		case d.queryName("cachesize"):
+			out.CacheSize, err = parseIntRecord(ans, d.NumberBase)
+			if err != nil {
+				drop(ans, "cache size", err)
+			}
+		case d.queryName("insertions"):
+			out.CacheInsertions, err = parseIntRecord(ans, d.NumberBase)
 			if err != nil {
-				return nil, fmt.Errorf("%w cache size: %s", ErrParseAnswer, err)
+				drop(ans, "cache insertions", err)
 			}
-		case "insertions.bind.":
-			cacheInsertions, err = parseIntRecord(ans)
+		case d.queryName("evictions"):
+			out.CacheEvictions, err = parseIntRecord(ans, d.NumberBase)
 			if err != nil {
-				return nil, fmt.Errorf("%w cache insertions: %s", ErrParseAnswer, err)
+				drop(ans, "cache evictions", err)
 			}
-		case "evictions.bind.":
-			cacheEvictions, err = parseIntRecord(ans)
+		case d.queryName("misses"):
+			out.CacheMisses, err = parseIntRecord(ans, d.NumberBase)
 			if err != nil {
-				return nil, fmt.Errorf("%w cache evictions: %s", ErrParseAnswer, err)
+				drop(ans, "cache misses", err)
 			}
-		case "misses.bind.":
-			cacheMisses, err = parseIntRecord(ans)
+		case d.queryName("hits"):
+			out.CacheHits, err = parseIntRecord(ans, d.NumberBase)
 			if err != nil {
-				return nil, fmt.Errorf("%w cache misses: %s", ErrParseAnswer, err)
+				drop(ans, "cache hits", err)
 			}
-		case "hits.bind.":
-			cacheHits, err = parseIntRecord(ans)
+		case d.queryName("auth"):
+			out.Authoritative, err = parseIntRecord(ans, d.NumberBase)
 			if err != nil {
-				return nil, fmt.Errorf("%w cache hits: %s", ErrParseAnswer, err)
+				drop(ans, "authoritative", err)
 			}
-		case "auth.bind.":
-			authoritative, err = parseIntRecord(ans)
+		case d.queryName("servers"):
+			out.Servers, err = parseServersRecord(ans, d.NumberBase, d.logger)
 			if err != nil {
-				return nil, fmt.Errorf("%w authoritative: %s", ErrParseAnswer, err)
+				drop(ans, "servers", err)
+			}
+		default:
+			name, ok := d.extraQuery(ans.Header().Name)
+			if !ok {
+				unexpected(sectionAnswer)
+				continue
 			}
-		case "servers.bind.":
-			servers, err = parseServersRecord(ans)
+
+			val, err := parseIntRecord(ans, d.NumberBase)
 			if err != nil {
-				return nil, fmt.Errorf("%w servers: %s", ErrParseAnswer, err)
+				drop(ans, name, err)
+				continue
 			}
+
+			if out.Extra == nil {
+				out.Extra = make(map[string]uint64)
+			}
+			out.Extra[name] = val
 		}
 	}
 
-	return &DnsmasqResult{
-		CacheSize:       cacheSize,
-		CacheInsertions: cacheInsertions,
-		CacheEvictions:  cacheEvictions,
-		CacheMisses:     cacheMisses,
-		CacheHits:       cacheHits,
-		Authoritative:   authoritative,
-		Servers:         servers,
-	}, nil
+	return out, errors.Join(parseErrs...)
+}
+
+// queryName returns the name of the CHAOS TXT query for a counter, e.g. "hits.bind.".
+func (d *DnsmasqReader) queryName(name string) string {
+	return name + d.ChaosSuffix
+}
+
+// extraQuery returns the name of the extra query that an answer is for, if any.
+func (d *DnsmasqReader) extraQuery(answerName string) (string, bool) {
+	for _, name := range d.ExtraQueries {
+		if answerName == d.queryName(name) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// ServerVersion makes a version.bind. CHAOS TXT query to get the name and version of
+// the DNS server, e.g. "dnsmasq-2.85".
+func (d *DnsmasqReader) ServerVersion() (string, error) {
+	return d.queryText("version")
+}
+
+// ServerHostname makes a hostname.bind. query to get the hostname the server reports
+// for itself, e.g. to use as the ServerLabel. An error is returned if the server
+// doesn't answer the query.
+func (d *DnsmasqReader) ServerHostname() (string, error) {
+	return d.queryText("hostname")
+}
+
+// LabelByHostname sets the ServerLabel of each reader to the hostname its server
+// reports. Readers keep their address as the label if their server doesn't answer
+// or reports the same hostname as another server, such as replicas that share a
+// hostname, so that metrics of different servers never have the same labels.
+func LabelByHostname(readers []*DnsmasqReader, logger log.Logger) {
+	hostnames := make(map[*DnsmasqReader]string, len(readers))
+	addresses := make(map[string]map[string]bool)
+	for _, r := range readers {
+		hostname, err := r.ServerHostname()
+		if err != nil {
+			level.Warn(logger).Log("msg", "unable to get DNS server hostname, using address as server label", "server", r.address, "err", err)
+			continue
+		}
+
+		if addresses[hostname] == nil {
+			addresses[hostname] = make(map[string]bool)
+		}
+
+		hostnames[r] = hostname
+		addresses[hostname][r.address] = true
+	}
+
+	for _, r := range readers {
+		hostname, ok := hostnames[r]
+		if !ok {
+			continue
+		}
+
+		if len(addresses[hostname]) > 1 {
+			level.Warn(logger).Log("msg", "DNS server hostname is reported by multiple servers, using address as server label", "server", r.address, "hostname", hostname)
+			continue
+		}
+
+		r.ServerLabel = hostname
+	}
+}
+
+// queryText makes a query for a single name, e.g. "version", returning all values of
+// the TXT answer joined by spaces.
+func (d *DnsmasqReader) queryText(counter string) (string, error) {
+	m := &dns.Msg{}
+	m.MsgHdr = dns.MsgHdr{Id: d.IDGenerator(), RecursionDesired: d.RecursionDesired}
+	name := d.queryName(counter)
+	m.Question = []dns.Question{d.question(name)}
+
+	res, _, _, err := d.exchange(m)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrUpstream, err)
+	}
+
+	if res.Rcode != dns.RcodeSuccess {
+		return "", fmt.Errorf("%w: %s query returned %s", ErrUpstream, name, dns.RcodeToString[res.Rcode])
+	}
+
+	for _, ans := range res.Answer {
+		if ans.Header().Name != name {
+			continue
+		}
+
+		txt, ok := ans.(*dns.TXT)
+		if !ok {
+			return "", fmt.Errorf("%w %s: %s", ErrParseAnswer, counter, errNotTXT)
+		} else if len(txt.Txt) == 0 {
+			return "", fmt.Errorf("%w %s: %s", ErrParseAnswer, counter, errEmptyTXT)
+		}
+
+		return strings.Join(txt.Txt, " "), nil
+	}
+
+	return "", fmt.Errorf("%w: no %s answer", ErrNumAnswers, name)
+}
+
+// server returns the value of the server label of metrics.
+func (d *DnsmasqReader) server() string {
+	if d.ServerLabel != "" {
+		return d.ServerLabel
+	}
+
+	return d.address
 }
 
 func (d *DnsmasqReader) Describe(ch chan<- *prometheus.Desc) {
@@ -219,58 +831,469 @@ func (d *DnsmasqReader) Describe(ch chan<- *prometheus.Desc) {
 	ch <- d.descriptions.dnsCacheEvictions
 	ch <- d.descriptions.dnsCacheMisses
 	ch <- d.descriptions.dnsCacheHits
+	ch <- d.descriptions.dnsCacheEvents
 	ch <- d.descriptions.dnsAuthoritative
 	ch <- d.descriptions.dnsUpstreamQueries
 	ch <- d.descriptions.dnsUpstreamErrors
+	ch <- d.descriptions.dnsQueriesPerSec
+	ch <- d.descriptions.dnsRecentHitRatio
+	ch <- d.descriptions.dnsEDNS0Supported
+	ch <- d.descriptions.dnsAnswersDropped
+	ch <- d.descriptions.dnsScrapeAttempts
+	ch <- d.descriptions.dnsScrapeErrors
+	ch <- d.descriptions.dnsResponseRTT
+	ch <- d.descriptions.dnsResponseSize
+	ch <- d.descriptions.dnsRespQuestions
+	ch <- d.descriptions.dnsRespAnswers
+	ch <- d.descriptions.dnsServerInfo
+	ch <- d.descriptions.dnsUnexpected
+	ch <- d.descriptions.dnsAnomalies
+	ch <- d.descriptions.dnsTruncated
+	ch <- d.descriptions.dnsUp
+	d.scrapeRTT().Describe(ch)
+
+	for _, name := range d.ExtraQueries {
+		ch <- d.extraDesc(name)
+	}
+}
+
+func (d *DnsmasqReader) extraDesc(name string) *prometheus.Desc {
+	return d.extraDescs.get(extraMetricName(name), fmt.Sprintf("Value of %s from the DNS server", d.queryName(name)), []string{"server"})
+}
+
+// scrapeRTT returns the histogram of stats query RTTs, creating it using the configured
+// buckets the first time it's used. Unlike other metrics, this accumulates observations
+// from each scrape instead of being created from the values returned by the server.
+func (d *DnsmasqReader) scrapeRTT() prometheus.Histogram {
+	d.rttOnce.Do(func() {
+		buckets := d.RTTBuckets
+		if len(buckets) == 0 {
+			buckets = DefaultRTTBuckets
+		}
+
+		d.rttHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "roger_dns_scrape_rtt_seconds",
+			Help:        "Round trip time of stats queries to the DNS server in seconds",
+			ConstLabels: prometheus.Labels{"server": d.server()},
+			Buckets:     buckets,
+		})
+	})
+
+	return d.rttHistogram
 }
 
 func (d *DnsmasqReader) Collect(ch chan<- prometheus.Metric) {
-	res, err := d.ReadMetrics()
-	if err != nil {
+	if err := d.CollectWithError(ch); err != nil {
 		level.Error(d.logger).Log("msg", "failed to read dnsmasq metrics during collection", "addr", d.address, "err", err)
-		return
 	}
+}
+
+// CollectWithError queries the server and emits metrics, returning an
+// error if the query or parsing the answers fails.
+func (d *DnsmasqReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	d.lock.Lock()
+	d.scrapeAttempts++
+	d.lock.Unlock()
+
+	res, err := d.ReadMetrics()
+	if res == nil {
+		d.collectScrapeCounts(ch, err)
+		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsUp, prometheus.GaugeValue, 0, d.server())
+	} else {
+		d.collectScrapeCounts(ch, nil)
+		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsUp, prometheus.GaugeValue, 1, d.server())
+	}
+	d.collectResponseCounts(ch)
+	d.collectTruncated(ch)
+
+	if res == nil {
+		d.scrapeRTT().Collect(ch)
+		return err
+	} else if err != nil {
+		level.Warn(d.logger).Log("msg", "dropped dnsmasq answers that could not be parsed", "addr", d.address, "err", err)
+	}
+
+	d.scrapeRTT().Observe(res.RTT.Seconds())
+	d.scrapeRTT().Collect(ch)
+
+	emit := func(name string, desc *prometheus.Desc, valueType prometheus.ValueType, val uint64) {
+		if res.dropped(name) {
+			return
+		}
+
+		if valueType == prometheus.CounterValue {
+			ch <- d.created.counter(desc, float64(val), d.server())
+		} else {
+			ch <- prometheus.MustNewConstMetric(desc, valueType, float64(val), d.server())
+		}
+	}
+
+	emit(d.queryName("cachesize"), d.descriptions.dnsCacheSize, prometheus.GaugeValue, res.CacheSize)
+	if d.MetricLayout == DnsMetricLayoutLabeled {
+		emitEvent := func(name string, event string, val uint64) {
+			if !res.dropped(name) {
+				ch <- d.created.counter(d.descriptions.dnsCacheEvents, float64(val), d.server(), event)
+			}
+		}
 
-	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsCacheSize, prometheus.GaugeValue, float64(res.CacheSize), d.address)
+		emitEvent(d.queryName("insertions"), "insertion", res.CacheInsertions)
+		emitEvent(d.queryName("evictions"), "eviction", res.CacheEvictions)
+		emitEvent(d.queryName("hits"), "hit", res.CacheHits)
+		emitEvent(d.queryName("misses"), "miss", res.CacheMisses)
+	} else {
+		emit(d.queryName("insertions"), d.descriptions.dnsCacheInsertions, prometheus.CounterValue, res.CacheInsertions)
+		emit(d.queryName("evictions"), d.descriptions.dnsCacheEvictions, prometheus.CounterValue, res.CacheEvictions)
+		emit(d.queryName("misses"), d.descriptions.dnsCacheMisses, prometheus.CounterValue, res.CacheMisses)
+		emit(d.queryName("hits"), d.descriptions.dnsCacheHits, prometheus.CounterValue, res.CacheHits)
+	}
+	emit(d.queryName("auth"), d.descriptions.dnsAuthoritative, prometheus.CounterValue, res.Authoritative)
 
-	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsCacheInsertions, prometheus.CounterValue, float64(res.CacheInsertions), d.address)
-	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsCacheEvictions, prometheus.CounterValue, float64(res.CacheEvictions), d.address)
-	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsCacheMisses, prometheus.CounterValue, float64(res.CacheMisses), d.address)
-	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsCacheHits, prometheus.CounterValue, float64(res.CacheHits), d.address)
-	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsAuthoritative, prometheus.CounterValue, float64(res.Authoritative), d.address)
+	// Emit extra values in the order they were configured so output is stable
+	for _, name := range d.ExtraQueries {
+		val, ok := res.Extra[name]
+		if !ok {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(d.extraDesc(name), prometheus.UntypedValue, float64(val), d.server())
+	}
+
+	var edns0 float64
+	if res.EDNS0 {
+		edns0 = 1
+	}
+	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsEDNS0Supported, prometheus.GaugeValue, edns0, d.server())
 
 	for _, s := range res.Servers {
-		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsUpstreamQueries, prometheus.CounterValue, float64(s.QueriesSent), d.address, s.Address)
-		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsUpstreamErrors, prometheus.CounterValue, float64(s.QueryErrors), d.address, s.Address)
+		if d.UpstreamFilter != nil && !d.UpstreamFilter(s.Address) {
+			continue
+		}
+
+		ch <- d.created.counter(d.descriptions.dnsUpstreamQueries, float64(s.QueriesSent), d.server(), s.Address, s.Family)
+		ch <- d.created.counter(d.descriptions.dnsUpstreamErrors, float64(s.QueryErrors), d.server(), s.Address, s.Family)
+	}
+
+	if version := d.cachedVersion(); version != "" {
+		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsServerInfo, prometheus.GaugeValue, 1, d.server(), version)
+	}
+
+	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsResponseRTT, prometheus.GaugeValue, res.RTT.Seconds(), d.server(), res.Transport)
+	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsResponseSize, prometheus.GaugeValue, float64(res.ResponseSize), d.server(), res.Transport)
+
+	if qps, ok := d.queriesPerSecond(res); ok {
+		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsQueriesPerSec, prometheus.GaugeValue, qps, d.server())
+	}
+
+	if ratio, ok := d.recentHitRatio(res); ok {
+		ch <- prometheus.MustNewConstMetric(d.descriptions.dnsRecentHitRatio, prometheus.GaugeValue, ratio, d.server())
 	}
+
+	for reason, count := range d.countDropped(res) {
+		ch <- d.created.counter(d.descriptions.dnsAnswersDropped, float64(count), d.server(), reason)
+	}
+
+	for section, count := range d.countUnexpected(res) {
+		ch <- d.created.counter(d.descriptions.dnsUnexpected, float64(count), d.server(), section)
+	}
+
+	if d.DetectAnomalies {
+		for counter, count := range d.checkCounters(res) {
+			ch <- d.created.counter(d.descriptions.dnsAnomalies, float64(count), d.server(), counter)
+		}
+	}
+
+	d.created.sweep()
+	return nil
 }
 
-func parseIntRecord(answer dns.RR) (uint64, error) {
-	txt := answer.(*dns.TXT)
-	parsed, err := strconv.ParseUint(txt.Txt[0], 10, 64)
+// collectScrapeCounts emits the number of scrape attempts and errors by reason,
+// counting the current scrape as an error if err is non-nil.
+func (d *DnsmasqReader) collectScrapeCounts(ch chan<- prometheus.Metric, err error) {
+	d.lock.Lock()
 	if err != nil {
+		d.scrapeErrors[scrapeErrorReason(err)]++
+	}
+
+	attempts := d.scrapeAttempts
+	failures := make(map[string]uint64, len(d.scrapeErrors))
+	for reason, count := range d.scrapeErrors {
+		failures[reason] = count
+	}
+	d.lock.Unlock()
+
+	ch <- d.created.counter(d.descriptions.dnsScrapeAttempts, float64(attempts), d.server())
+	for reason, count := range failures {
+		ch <- d.created.counter(d.descriptions.dnsScrapeErrors, float64(count), d.server(), reason)
+	}
+}
+
+// collectTruncated emits the number of truncated responses from the server, including
+// those the client retried with another transport. This is emitted even when the
+// current scrape fails since truncation is often the reason why.
+func (d *DnsmasqReader) collectTruncated(ch chan<- prometheus.Metric) {
+	d.lock.Lock()
+	truncated := d.truncated
+	d.lock.Unlock()
+
+	if c, ok := d.client.(retryingClient); ok {
+		truncated += c.TruncatedRetries(d.address)
+	}
+
+	ch <- d.created.counter(d.descriptions.dnsTruncated, float64(truncated), d.server())
+}
+
+// cachedVersion returns the version of the server, only querying it if it hasn't been
+// checked within InfoTTL. An empty string is returned (and cached) if the server
+// doesn't answer version.bind. queries, many servers are configured to refuse them.
+func (d *DnsmasqReader) cachedVersion() string {
+	d.lock.Lock()
+	if !d.versionChecked.IsZero() && d.now().Sub(d.versionChecked) < d.InfoTTL {
+		version := d.version
+		d.lock.Unlock()
+		return version
+	}
+	d.lock.Unlock()
+
+	version, err := d.ServerVersion()
+	if err != nil {
+		level.Debug(d.logger).Log("msg", "unable to get DNS server version", "addr", d.address, "err", err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.version = version
+	d.versionChecked = d.now()
+	return version
+}
+
+// collectResponseCounts emits the number of questions and answers in the most recent
+// response from the server. These are emitted even when the current scrape fails since
+// unexpected counts are often the reason why.
+func (d *DnsmasqReader) collectResponseCounts(ch chan<- prometheus.Metric) {
+	d.lock.Lock()
+	last := d.lastResponse
+	d.lock.Unlock()
+
+	if last == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsRespQuestions, prometheus.GaugeValue, float64(last.questions), d.server())
+	ch <- prometheus.MustNewConstMetric(d.descriptions.dnsRespAnswers, prometheus.GaugeValue, float64(last.answers), d.server())
+}
+
+// countDropped adds answers dropped from the result to the running total for each
+// reason and returns a copy of the totals.
+func (d *DnsmasqReader) countDropped(res *DnsmasqResult) map[string]uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, a := range res.Dropped {
+		d.dropped[a.Reason]++
+	}
+
+	out := make(map[string]uint64, len(d.dropped))
+	for reason, count := range d.dropped {
+		out[reason] = count
+	}
+
+	return out
+}
+
+// countUnexpected adds unexpected records from the result to the running total for
+// each section and returns a copy of the totals.
+func (d *DnsmasqReader) countUnexpected(res *DnsmasqResult) map[string]uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for section, count := range res.Unexpected {
+		d.unexpected[section] += count
+	}
+
+	out := make(map[string]uint64, len(d.unexpected))
+	for section, count := range d.unexpected {
+		out[section] = count
+	}
+
+	return out
+}
+
+// checkCounters compares the counters in the result to their values as of the previous
+// collection, adding each counter that decreased to the running total of anomalies, and
+// returns a copy of the totals. Counters decreasing is expected when the server restarts
+// so decreases are only anomalies if some other counter didn't decrease: a counter that
+// was non-zero and didn't decrease shows the server kept running.
+func (d *DnsmasqReader) checkCounters(res *DnsmasqResult) map[string]uint64 {
+	current := make(map[string]uint64)
+	for name, val := range map[string]uint64{
+		"insertions": res.CacheInsertions,
+		"evictions":  res.CacheEvictions,
+		"misses":     res.CacheMisses,
+		"hits":       res.CacheHits,
+		"auth":       res.Authoritative,
+	} {
+		if !res.dropped(d.queryName(name)) {
+			current[name] = val
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var decreased []string
+	restarted := true
+
+	for name, val := range current {
+		// Counters start at zero anomalies so that the first one can be alerted on
+		if _, ok := d.anomalies[name]; !ok {
+			d.anomalies[name] = 0
+		}
+
+		prev, ok := d.prevCounters[name]
+		if !ok {
+			continue
+		}
+
+		if val < prev {
+			decreased = append(decreased, name)
+		} else if prev > 0 {
+			restarted = false
+		}
+	}
+
+	if !restarted {
+		sort.Strings(decreased)
+		for _, name := range decreased {
+			level.Warn(d.logger).Log("msg", "counter from DNS server decreased without a restart", "addr", d.address, "counter", name, "previous", d.prevCounters[name], "current", current[name])
+			d.anomalies[name]++
+		}
+	}
+
+	d.prevCounters = current
+
+	out := make(map[string]uint64, len(d.anomalies))
+	for name, count := range d.anomalies {
+		out[name] = count
+	}
+
+	return out
+}
+
+// queriesPerSecond estimates the rate of queries answered by the server based on
+// the total from the previous collection. The second return value is false if
+// there is no previous collection, the server counters have been reset, or any
+// of the counters couldn't be parsed.
+func (d *DnsmasqReader) queriesPerSecond(res *DnsmasqResult) (float64, bool) {
+	if res.dropped(d.queryName("hits")) || res.dropped(d.queryName("misses")) || res.dropped(d.queryName("auth")) {
+		return 0, false
+	}
+
+	total := res.CacheHits + res.CacheMisses + res.Authoritative
+	now := d.now()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	prevQueries := d.prevQueries
+	prevCollected := d.prevCollected
+	d.prevQueries = total
+	d.prevCollected = now
+
+	if prevCollected.IsZero() || total < prevQueries || !now.After(prevCollected) {
+		return 0, false
+	}
+
+	return float64(total-prevQueries) / now.Sub(prevCollected).Seconds(), true
+}
+
+// recentHitRatio computes the cache hit ratio since the previous collection, showing
+// how effective the cache is now rather than over the lifetime of the server. The
+// second return value is false if there is no previous collection, the server counters
+// have been reset, there were no lookups since the previous collection, or either of
+// the counters couldn't be parsed.
+func (d *DnsmasqReader) recentHitRatio(res *DnsmasqResult) (float64, bool) {
+	if res.dropped(d.queryName("hits")) || res.dropped(d.queryName("misses")) {
+		return 0, false
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	prev := d.prevLookups
+	d.prevLookups = &cacheLookups{hits: res.CacheHits, misses: res.CacheMisses}
+
+	if prev == nil || res.CacheHits < prev.hits || res.CacheMisses < prev.misses {
+		return 0, false
+	}
+
+	hits := res.CacheHits - prev.hits
+	lookups := hits + res.CacheMisses - prev.misses
+	if lookups == 0 {
+		return 0, false
+	}
+
+	return float64(hits) / float64(lookups), true
+}
+
+// parseIntRecord parses the first value of a TXT record as an integer in the given base.
+func parseIntRecord(answer dns.RR, base int) (uint64, error) {
+	txt, ok := answer.(*dns.TXT)
+	if !ok {
+		return 0, errNotTXT
+	}
+
+	if len(txt.Txt) == 0 {
+		return 0, errEmptyTXT
+	}
+
+	return parseCounter(txt.Txt[0], base)
+}
+
+// parseCounter parses a counter from the server as an integer in the given base. Some
+// forks of dnsmasq report counters with a decimal point, e.g. "1000.0", so base 10
+// values with a fractional part are accepted and truncated. Other floating point
+// formats such as exponents are still invalid.
+func parseCounter(s string, base int) (uint64, error) {
+	parsed, err := strconv.ParseUint(s, base, 64)
+	if err == nil || base != 10 {
+		return parsed, err
+	}
+
+	whole, frac, ok := strings.Cut(s, ".")
+	if !ok || whole == "" || strings.Trim(frac, "0123456789") != "" {
 		return 0, err
 	}
 
-	return parsed, nil
+	return strconv.ParseUint(whole, 10, 64)
 }
 
-func parseServersRecord(answer dns.RR) ([]ServerStats, error) {
-	txt := answer.(*dns.TXT)
+// parseServersRecord parses each value of a TXT record as the address of an upstream
+// server followed by the number of queries sent to it and errors, in the given base.
+func parseServersRecord(answer dns.RR, base int, logger log.Logger) ([]ServerStats, error) {
+	txt, ok := answer.(*dns.TXT)
+	if !ok {
+		return nil, errNotTXT
+	}
+
 	out := make([]ServerStats, len(txt.Txt))
 
 	for i, val := range txt.Txt {
+		// Some dnsmasq forks append extra fields to each server, only the first
+		// three (address, queries sent, query errors) are used.
 		statParts := strings.Split(val, " ")
-		if len(statParts) != 3 {
-			return nil, fmt.Errorf("expected 3 server fields, got %d from %s", len(statParts), val)
+		if len(statParts) < 3 {
+			return nil, fmt.Errorf("expected at least 3 server fields, got %d from %s", len(statParts), val)
+		} else if len(statParts) > 3 {
+			level.Debug(logger).Log("msg", "ignoring extra server fields", "fields", len(statParts), "value", val)
 		}
 
-		queriesSent, err := strconv.ParseUint(statParts[1], 10, 64)
+		queriesSent, err := parseCounter(statParts[1], base)
 		if err != nil {
 			return nil, err
 		}
 
-		queryErrors, err := strconv.ParseUint(statParts[2], 10, 64)
+		queryErrors, err := parseCounter(statParts[2], base)
 		if err != nil {
 			return nil, err
 		}
@@ -279,12 +1302,156 @@ func parseServersRecord(answer dns.RR) ([]ServerStats, error) {
 			Address:     statParts[0],
 			QueriesSent: queriesSent,
 			QueryErrors: queryErrors,
+			Family:      addressFamily(statParts[0]),
 		}
 	}
 
 	return out, nil
 }
 
-func question(name string) dns.Question {
-	return dns.Question{Name: name, Qtype: dns.TypeTXT, Qclass: dns.ClassCHAOS}
+// addressFamily returns "ipv4" or "ipv6" based on the address of an upstream server
+// or "unknown" if it can't be parsed. dnsmasq formats addresses as "address#port" but
+// "address:port", "[address]:port", and bare addresses are accepted too.
+func addressFamily(address string) string {
+	host := address
+	if i := strings.LastIndex(address, "#"); i != -1 {
+		host = address[:i]
+	} else if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		return "unknown"
+	case ip.Is4() || ip.Is4In6():
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
+}
+
+// dropReason returns the reason an answer was dropped based on the error parsing it.
+func dropReason(err error) string {
+	switch {
+	case errors.Is(err, errNotTXT):
+		return dropReasonNotTXT
+	case errors.Is(err, errEmptyTXT):
+		return dropReasonEmpty
+	default:
+		return dropReasonInvalid
+	}
+}
+
+// newScrapeErrorCounts returns zeroed counts for every reason so that each series
+// exists before the first error, allowing alerts on increases from zero.
+func newScrapeErrorCounts() map[string]uint64 {
+	return map[string]uint64{
+		scrapeErrorTimeout:     0,
+		scrapeErrorConnRefused: 0,
+		scrapeErrorTruncated:   0,
+		scrapeErrorServFail:    0,
+		scrapeErrorRefused:     0,
+		scrapeErrorOther:       0,
+	}
+}
+
+// scrapeErrorReason returns why querying the server failed for use as a label.
+func scrapeErrorReason(err error) string {
+	var rcode rcodeError
+
+	switch {
+	case errors.As(err, &rcode):
+		switch int(rcode) {
+		case dns.RcodeServerFailure:
+			return scrapeErrorServFail
+		case dns.RcodeRefused:
+			return scrapeErrorRefused
+		default:
+			return scrapeErrorOther
+		}
+	case errors.Is(err, errTruncated):
+		return scrapeErrorTruncated
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return scrapeErrorConnRefused
+	case isTimeout(err):
+		return scrapeErrorTimeout
+	default:
+		return scrapeErrorOther
+	}
+}
+
+// isTimeout returns true if the error is from a query that timed out, as opposed to
+// the server answering with an error.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// dnsMetricNames are the names of roger_dns_* metrics exported by Roger, which the
+// metrics of extra queries can't use. New metrics must be added here,
+// TestDNSMetricNames checks the names of metrics emitted by the DNS readers.
+var dnsMetricNames = map[string]bool{
+	"roger_dns_answers_dropped_total":         true,
+	"roger_dns_authoritative_total":           true,
+	"roger_dns_cache_events_total":            true,
+	"roger_dns_cache_evictions_total":         true,
+	"roger_dns_cache_hit_ratio_recent":        true,
+	"roger_dns_cache_hits_total":              true,
+	"roger_dns_cache_insertions_total":        true,
+	"roger_dns_cache_misses_total":            true,
+	"roger_dns_cache_size":                    true,
+	"roger_dns_configured_server":             true,
+	"roger_dns_counter_anomaly_total":         true,
+	"roger_dns_edns0_supported":               true,
+	"roger_dns_process_cpu_seconds_total":     true,
+	"roger_dns_process_open_fds":              true,
+	"roger_dns_process_resident_memory_bytes": true,
+	"roger_dns_process_threads":               true,
+	"roger_dns_queries_per_second":            true,
+	"roger_dns_response_answers":              true,
+	"roger_dns_response_questions":            true,
+	"roger_dns_response_rtt_seconds":          true,
+	"roger_dns_response_size_bytes":           true,
+	"roger_dns_scrape_attempts_total":         true,
+	"roger_dns_scrape_errors_total":           true,
+	"roger_dns_scrape_rtt_seconds":            true,
+	"roger_dns_server_info":                   true,
+	"roger_dns_truncated_responses_total":     true,
+	"roger_dns_unexpected_records_total":      true,
+	"roger_dns_up":                            true,
+	"roger_dns_upstream_errors_total":         true,
+	"roger_dns_upstream_queries_total":        true,
+}
+
+// CheckExtraQuery returns an error if the metric for an extra query with the name,
+// without the ChaosSuffix, would have the same name as a metric Roger already exports,
+// including the series of histograms. See DnsmasqReader.ExtraQueries.
+func CheckExtraQuery(name string) error {
+	metric := extraMetricName(name)
+	for _, suffix := range []string{"", "_bucket", "_count", "_sum"} {
+		if dnsMetricNames[strings.TrimSuffix(metric, suffix)] {
+			return fmt.Errorf("extra query %q would be exported as %s, which conflicts with an existing metric", name, metric)
+		}
+	}
+
+	return nil
+}
+
+// extraMetricName returns the metric name for an extra query, replacing any characters
+// that aren't allowed in metric names with underscores.
+func extraMetricName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	return prometheus.BuildFQName("roger", "dns", sanitized)
+}
+
+// question returns a TXT question for the name using the QueryClass of the reader.
+func (d *DnsmasqReader) question(name string) dns.Question {
+	return dns.Question{Name: name, Qtype: dns.TypeTXT, Qclass: d.QueryClass}
 }
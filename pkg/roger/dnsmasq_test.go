@@ -1,22 +1,34 @@
 package roger
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 type mockDNSClient struct {
-	err error
-	msg *dns.Msg
+	err  error
+	msg  *dns.Msg
+	sent *dns.Msg
 }
 
 func (c *mockDNSClient) Exchange(q *dns.Msg, _ string) (r *dns.Msg, rtt time.Duration, err error) {
+	c.sent = q
 	if c.err != nil {
 		return nil, 0, c.err
 	}
@@ -24,13 +36,22 @@ func (c *mockDNSClient) Exchange(q *dns.Msg, _ string) (r *dns.Msg, rtt time.Dur
 	var msg dns.Msg
 	msg.Question = q.Question
 	msg.Answer = c.msg.Answer
+	msg.Extra = c.msg.Extra
+	msg.Ns = c.msg.Ns
+	msg.Truncated = c.msg.Truncated
+	msg.Rcode = c.msg.Rcode
 
 	return &msg, 1 * time.Second, nil
 }
 
+// question returns the question a reader with the default settings makes for name.
+func question(name string) dns.Question {
+	return dns.Question{Name: name, Qtype: dns.TypeTXT, Qclass: dns.ClassCHAOS}
+}
+
 func txt(name string, msgs ...string) dns.RR {
 	out := dns.TXT{}
-	out.Hdr = dns.RR_Header{Name: name}
+	out.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS}
 	out.Txt = msgs
 	return &out
 }
@@ -220,3 +241,1028 @@ func TestDnsmasqReader_ReadMetrics(t *testing.T) {
 		assert.Equal(t, uint64(501), res.Servers[1].QueryErrors)
 	})
 }
+
+func statsMsg(hits string, misses string, auth string) *dns.Msg {
+	return &dns.Msg{
+		Answer: []dns.RR{
+			txt("cachesize.bind.", "1000"),
+			txt("insertions.bind.", "1001"),
+			txt("evictions.bind.", "1002"),
+			txt("misses.bind.", misses),
+			txt("hits.bind.", hits),
+			txt("auth.bind.", auth),
+			txt("servers.bind.", "1.1.1.1:53 1000 500"),
+		},
+	}
+}
+
+func TestDnsmasqReader_QueriesPerSecond(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("first collection", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.now = func() time.Time { return start }
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_queries_per_second"))
+	})
+
+	t.Run("second collection", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.now = func() time.Time { return start }
+		testutil.CollectAndCount(reader)
+
+		mock.msg = statsMsg("150", "140", "130")
+		reader.now = func() time.Time { return start.Add(10 * time.Second) }
+
+		expected := `
+# HELP roger_dns_queries_per_second Queries (cache hits, misses, and authoritative) per second since the previous collection
+# TYPE roger_dns_queries_per_second gauge
+roger_dns_queries_per_second{server="127.0.0.1:53"} 12
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_queries_per_second"))
+	})
+
+	t.Run("counter reset", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.now = func() time.Time { return start }
+		testutil.CollectAndCount(reader)
+
+		mock.msg = statsMsg("10", "10", "10")
+		reader.now = func() time.Time { return start.Add(10 * time.Second) }
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_queries_per_second"))
+	})
+}
+
+func TestDnsmasqReader_RecentHitRatio(t *testing.T) {
+	t.Run("first collection", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_cache_hit_ratio_recent"))
+	})
+
+	t.Run("second collection", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		testutil.CollectAndCount(reader)
+
+		mock.msg = statsMsg("130", "110", "100")
+
+		expected := `
+# HELP roger_dns_cache_hit_ratio_recent Ratio of cache hits to cache hits and misses since the previous collection
+# TYPE roger_dns_cache_hit_ratio_recent gauge
+roger_dns_cache_hit_ratio_recent{server="127.0.0.1:53"} 0.75
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_cache_hit_ratio_recent"))
+	})
+
+	t.Run("no lookups", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		testutil.CollectAndCount(reader)
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_cache_hit_ratio_recent"))
+	})
+
+	t.Run("counter reset", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		testutil.CollectAndCount(reader)
+
+		mock.msg = statsMsg("10", "10", "10")
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_cache_hit_ratio_recent"))
+	})
+}
+
+func TestDnsmasqReader_CounterAnomalies(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_counter_anomaly_total"))
+	})
+
+	t.Run("decrease", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.DetectAnomalies = true
+		testutil.CollectAndCount(reader)
+
+		mock.msg = statsMsg("90", "110", "100")
+
+		expected := `
+# HELP roger_dns_counter_anomaly_total Number of times a counter from the DNS server decreased without the server restarting, by counter
+# TYPE roger_dns_counter_anomaly_total counter
+roger_dns_counter_anomaly_total{counter="auth",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="evictions",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="hits",server="127.0.0.1:53"} 1
+roger_dns_counter_anomaly_total{counter="insertions",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="misses",server="127.0.0.1:53"} 0
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_counter_anomaly_total"))
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.DetectAnomalies = true
+		testutil.CollectAndCount(reader)
+
+		mock.msg = &dns.Msg{
+			Answer: []dns.RR{
+				txt("cachesize.bind.", "1000"),
+				txt("insertions.bind.", "3"),
+				txt("evictions.bind.", "0"),
+				txt("misses.bind.", "3"),
+				txt("hits.bind.", "1"),
+				txt("auth.bind.", "0"),
+				txt("servers.bind.", "1.1.1.1:53 3 0"),
+			},
+		}
+
+		expected := `
+# HELP roger_dns_counter_anomaly_total Number of times a counter from the DNS server decreased without the server restarting, by counter
+# TYPE roger_dns_counter_anomaly_total counter
+roger_dns_counter_anomaly_total{counter="auth",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="evictions",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="hits",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="insertions",server="127.0.0.1:53"} 0
+roger_dns_counter_anomaly_total{counter="misses",server="127.0.0.1:53"} 0
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_counter_anomaly_total"))
+	})
+}
+
+func TestDnsmasqReader_EDNS0(t *testing.T) {
+	t.Run("not advertised", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Nil(t, mock.sent.IsEdns0())
+		assert.False(t, res.EDNS0)
+	})
+
+	t.Run("advertised not honored", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.EDNS0Size = 4096
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		require.NotNil(t, mock.sent.IsEdns0())
+		assert.Equal(t, uint16(4096), mock.sent.IsEdns0().UDPSize())
+		assert.False(t, res.EDNS0)
+	})
+
+	t.Run("advertised and honored", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		mock.msg.SetEdns0(1232, false)
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.EDNS0Size = 4096
+
+		expected := `
+# HELP roger_dns_edns0_supported If the DNS server returned an EDNS0 OPT record (1) or not (0)
+# TYPE roger_dns_edns0_supported gauge
+roger_dns_edns0_supported{server="127.0.0.1:53"} 1
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_edns0_supported"))
+	})
+}
+
+func TestDnsmasqReader_Identity(t *testing.T) {
+	t.Run("not set", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Nil(t, mock.sent.IsEdns0())
+	})
+
+	t.Run("without EDNS0 size", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.Identity = "roger"
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		opt := mock.sent.IsEdns0()
+		require.NotNil(t, opt)
+		assert.Equal(t, uint16(dns.MinMsgSize), opt.UDPSize())
+		require.Len(t, opt.Option, 1)
+		assert.Equal(t, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "726f676572"}, opt.Option[0])
+	})
+
+	t.Run("with EDNS0 size", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.EDNS0Size = 4096
+		reader.Identity = "roger"
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		opt := mock.sent.IsEdns0()
+		require.NotNil(t, opt)
+		assert.Equal(t, uint16(4096), opt.UDPSize())
+		assert.Len(t, opt.Option, 1)
+	})
+}
+
+func TestDnsmasqReader_RecursionDesired(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.True(t, mock.sent.RecursionDesired)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.RecursionDesired = false
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.False(t, mock.sent.RecursionDesired)
+	})
+}
+
+func TestDnsmasqReader_DroppedAnswers(t *testing.T) {
+	t.Run("partial result", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "fail", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		assert.ErrorIs(t, err, ErrParseAnswer)
+		require.NotNil(t, res)
+		assert.Equal(t, uint64(100), res.CacheHits)
+		assert.Equal(t, []DroppedAnswer{{Name: "misses.bind.", Reason: "invalid"}}, res.Dropped)
+	})
+
+	t.Run("not txt or empty", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		mock.msg.Answer[0] = &dns.A{Hdr: dns.RR_Header{Name: "cachesize.bind."}}
+		mock.msg.Answer[1] = txt("insertions.bind.")
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		assert.ErrorIs(t, err, ErrParseAnswer)
+		require.NotNil(t, res)
+		assert.Equal(t, []DroppedAnswer{
+			{Name: "cachesize.bind.", Reason: "not_txt"},
+			{Name: "insertions.bind.", Reason: "empty"},
+		}, res.Dropped)
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "fail", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		testutil.CollectAndCount(reader)
+
+		expected := `
+# HELP roger_dns_answers_dropped_total Number of answers from the DNS server that could not be parsed, by reason
+# TYPE roger_dns_answers_dropped_total counter
+roger_dns_answers_dropped_total{reason="invalid",server="127.0.0.1:53"} 2
+# HELP roger_dns_cache_hits_total Number of hits in the DNS cache
+# TYPE roger_dns_cache_hits_total counter
+roger_dns_cache_hits_total{server="127.0.0.1:53"} 100
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+			"roger_dns_answers_dropped_total", "roger_dns_cache_hits_total", "roger_dns_cache_misses_total", "roger_dns_queries_per_second"))
+	})
+}
+
+func TestDnsmasqReader_UnexpectedRecords(t *testing.T) {
+	newMock := func() *mockDNSClient {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		mock.msg.Answer = append(mock.msg.Answer, txt("other.bind.", "1"))
+		mock.msg.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "bind.", Rrtype: dns.TypeNS}, Ns: "ns.bind."}}
+		mock.msg.SetEdns0(1232, false)
+		mock.msg.Extra = append(mock.msg.Extra, &dns.A{Hdr: dns.RR_Header{Name: "ns.bind.", Rrtype: dns.TypeA}})
+		return &mock
+	}
+
+	t.Run("read metrics", func(t *testing.T) {
+		reader := NewDnsmasqReader(newMock(), "127.0.0.1:53", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), res.CacheHits)
+		assert.Empty(t, res.Dropped)
+		assert.Equal(t, map[string]uint64{"answer": 1, "authority": 1, "additional": 1}, res.Unexpected)
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		reader := NewDnsmasqReader(newMock(), "127.0.0.1:53", log.NewNopLogger())
+		testutil.CollectAndCount(reader)
+
+		expected := `
+# HELP roger_dns_unexpected_records_total Number of records from the DNS server that weren't asked for and were ignored, by section of the response
+# TYPE roger_dns_unexpected_records_total counter
+roger_dns_unexpected_records_total{section="additional",server="127.0.0.1:53"} 2
+roger_dns_unexpected_records_total{section="answer",server="127.0.0.1:53"} 2
+roger_dns_unexpected_records_total{section="authority",server="127.0.0.1:53"} 2
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_unexpected_records_total"))
+	})
+
+	t.Run("none", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Nil(t, res.Unexpected)
+	})
+}
+
+func TestDnsmasqReader_IDGenerator(t *testing.T) {
+	var next uint16
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.IDGenerator = func() uint16 {
+		next++
+		return next
+	}
+
+	_, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(1), mock.sent.Id)
+
+	_, err = reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(2), mock.sent.Id)
+}
+
+func TestDnsmasqReader_ScrapeCounts(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	testutil.CollectAndCount(reader)
+
+	mock.err = errors.New("dns client error")
+	testutil.CollectAndCount(reader)
+
+	expected := `
+# HELP roger_dns_scrape_attempts_total Number of attempts to read metrics from the DNS server
+# TYPE roger_dns_scrape_attempts_total counter
+roger_dns_scrape_attempts_total{server="127.0.0.1:53"} 3
+# HELP roger_dns_scrape_errors_total Number of failed attempts to read metrics from the DNS server, by reason
+# TYPE roger_dns_scrape_errors_total counter
+roger_dns_scrape_errors_total{reason="connection_refused",server="127.0.0.1:53"} 0
+roger_dns_scrape_errors_total{reason="other",server="127.0.0.1:53"} 2
+roger_dns_scrape_errors_total{reason="refused",server="127.0.0.1:53"} 0
+roger_dns_scrape_errors_total{reason="servfail",server="127.0.0.1:53"} 0
+roger_dns_scrape_errors_total{reason="timeout",server="127.0.0.1:53"} 0
+roger_dns_scrape_errors_total{reason="truncated",server="127.0.0.1:53"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_dns_scrape_attempts_total", "roger_dns_scrape_errors_total"))
+}
+
+// gatherMetrics collects from the collector and returns the metrics with the given name.
+func gatherMetrics(t *testing.T, c prometheus.Collector, name string) []*dto.Metric {
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()
+		}
+	}
+
+	return nil
+}
+
+// timeoutError is a net.Error for a timed out operation.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestDnsmasqReader_TruncatedResponses(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+	const expectedTpl = `
+# HELP roger_dns_truncated_responses_total Number of responses from the DNS server that were truncated
+# TYPE roger_dns_truncated_responses_total counter
+roger_dns_truncated_responses_total{server="127.0.0.1:53"} %d
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, 0)), "roger_dns_truncated_responses_total"))
+
+	// Truncated with some answers, the answers are still used
+	mock.msg.Truncated = true
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, 1)), "roger_dns_truncated_responses_total"))
+
+	// Truncated without answers, the scrape fails
+	mock.msg = &dns.Msg{MsgHdr: dns.MsgHdr{Truncated: true}}
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, 2)), "roger_dns_truncated_responses_total"))
+}
+
+func TestDnsmasqReader_ScrapeErrorReasons(t *testing.T) {
+	truncated := &dns.Msg{MsgHdr: dns.MsgHdr{Truncated: true}}
+
+	tests := []struct {
+		name   string
+		mock   mockDNSClient
+		reason string
+	}{
+		{name: "timeout", mock: mockDNSClient{err: &net.OpError{Op: "read", Net: "udp", Err: timeoutError{}}}, reason: "timeout"},
+		{name: "deadline exceeded", mock: mockDNSClient{err: fmt.Errorf("exchange: %w", context.DeadlineExceeded)}, reason: "timeout"},
+		{name: "connection refused", mock: mockDNSClient{err: &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("read", syscall.ECONNREFUSED)}}, reason: "connection_refused"},
+		{name: "servfail", mock: mockDNSClient{msg: &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeServerFailure}}}, reason: "servfail"},
+		{name: "refused", mock: mockDNSClient{msg: &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeRefused}}}, reason: "refused"},
+		{name: "other rcode", mock: mockDNSClient{msg: &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNotImplemented}}}, reason: "other"},
+		{name: "truncated", mock: mockDNSClient{msg: truncated}, reason: "truncated"},
+		{name: "other", mock: mockDNSClient{err: errors.New("dns client error")}, reason: "other"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := NewDnsmasqReader(&tc.mock, "127.0.0.1:53", log.NewNopLogger())
+
+			_, err := reader.ReadMetrics()
+			require.ErrorIs(t, err, ErrUpstream)
+			assert.Equal(t, tc.reason, scrapeErrorReason(err))
+
+			counts := make(map[string]float64)
+			for _, m := range gatherMetrics(t, reader, "roger_dns_scrape_errors_total") {
+				counts[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+			}
+
+			assert.Len(t, counts, 6)
+			for reason, count := range counts {
+				if reason == tc.reason {
+					assert.Equal(t, float64(1), count, reason)
+				} else {
+					assert.Equal(t, float64(0), count, reason)
+				}
+			}
+		})
+	}
+
+	t.Run("truncated with answers", func(t *testing.T) {
+		msg := statsMsg("100", "100", "100")
+		msg.Truncated = true
+		reader := NewDnsmasqReader(&mockDNSClient{msg: msg}, "127.0.0.1:53", log.NewNopLogger())
+
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), res.CacheHits)
+	})
+}
+
+func TestDnsmasqReader_ServersExtraFields(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	mock.msg.Answer[6] = txt("servers.bind.", "1.1.1.1:53 1000 500 us-east", "8.8.8.8:53 1001 501 us-west 1")
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+
+	require.NoError(t, err)
+	require.Len(t, res.Servers, 2)
+	assert.Equal(t, ServerStats{Address: "1.1.1.1:53", QueriesSent: 1000, QueryErrors: 500, Family: "ipv4"}, res.Servers[0])
+	assert.Equal(t, ServerStats{Address: "8.8.8.8:53", QueriesSent: 1001, QueryErrors: 501, Family: "ipv4"}, res.Servers[1])
+}
+
+func TestDnsmasqReader_LogRawAnswers(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewLogfmtLogger(&buf))
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewLogfmtLogger(&buf))
+		reader.LogRawAnswers = true
+		_, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), `name=hits.bind.`)
+		assert.Contains(t, buf.String(), `name=servers.bind.`)
+		assert.Equal(t, 7, strings.Count(buf.String(), "raw answer from DNS server"))
+	})
+}
+
+func TestDnsmasqReader_UpstreamFamily(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	mock.msg.Answer[6] = txt("servers.bind.", "1.1.1.1#53 1000 500", "2606:4700:4700::1111#53 1001 501", "upstream.example#53 1002 502")
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+	expected := `
+# HELP roger_dns_upstream_queries_total Number of queries sent to upstream servers
+# TYPE roger_dns_upstream_queries_total counter
+roger_dns_upstream_queries_total{family="ipv4",server="127.0.0.1:53",upstream="1.1.1.1#53"} 1000
+roger_dns_upstream_queries_total{family="ipv6",server="127.0.0.1:53",upstream="2606:4700:4700::1111#53"} 1001
+roger_dns_upstream_queries_total{family="unknown",server="127.0.0.1:53",upstream="upstream.example#53"} 1002
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_upstream_queries_total"))
+}
+
+func TestDnsmasqReader_UpstreamFilter(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	mock.msg.Answer[6] = txt("servers.bind.", "1.1.1.1#53 1000 500", "10.0.0.1#53 1001 501")
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+	filter, err := NewMetricFilter(nil, []string{`10\..*`})
+	require.NoError(t, err)
+	reader.UpstreamFilter = filter.Allowed
+
+	expected := `
+# HELP roger_dns_upstream_errors_total Number of errors from upstream servers
+# TYPE roger_dns_upstream_errors_total counter
+roger_dns_upstream_errors_total{family="ipv4",server="127.0.0.1:53",upstream="1.1.1.1#53"} 500
+# HELP roger_dns_upstream_queries_total Number of queries sent to upstream servers
+# TYPE roger_dns_upstream_queries_total counter
+roger_dns_upstream_queries_total{family="ipv4",server="127.0.0.1:53",upstream="1.1.1.1#53"} 1000
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_dns_upstream_queries_total", "roger_dns_upstream_errors_total"))
+}
+
+func TestAddressFamily(t *testing.T) {
+	assert.Equal(t, "ipv4", addressFamily("1.1.1.1#53"))
+	assert.Equal(t, "ipv4", addressFamily("1.1.1.1:53"))
+	assert.Equal(t, "ipv4", addressFamily("1.1.1.1"))
+	assert.Equal(t, "ipv6", addressFamily("2001:db8::1#53"))
+	assert.Equal(t, "ipv6", addressFamily("[2001:db8::1]:53"))
+	assert.Equal(t, "ipv6", addressFamily("2001:db8::1"))
+	assert.Equal(t, "unknown", addressFamily("upstream.example#53"))
+	assert.Equal(t, "unknown", addressFamily(""))
+}
+
+func TestDnsmasqReader_ResponseTransport(t *testing.T) {
+	truncated := statsMsg("100", "100", "100")
+	truncated.Truncated = true
+	client := &FallbackClient{transports: []transport{
+		{protocol: ProtocolUDP, client: &mockDNSClient{msg: truncated}},
+		{protocol: ProtocolTCP, client: &mockDNSClient{msg: statsMsg("100", "100", "100")}},
+	}}
+	reader := NewDnsmasqReader(client, "127.0.0.1:53", log.NewNopLogger())
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolTCP, res.Transport)
+	assert.Greater(t, res.ResponseSize, 0)
+
+	expected := `
+# HELP roger_dns_response_rtt_seconds Round trip time of the most recent stats query in seconds, by transport used
+# TYPE roger_dns_response_rtt_seconds gauge
+roger_dns_response_rtt_seconds{server="127.0.0.1:53",transport="tcp"} 1
+# HELP roger_dns_truncated_responses_total Number of responses from the DNS server that were truncated
+# TYPE roger_dns_truncated_responses_total counter
+roger_dns_truncated_responses_total{server="127.0.0.1:53"} 2
+`
+	// The truncated UDP responses of both queries are counted even though they were
+	// retried over TCP
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_response_rtt_seconds", "roger_dns_truncated_responses_total"))
+}
+
+func TestDnsmasqReader_ResponseCounts(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+	assert.Equal(t, 2, testutil.CollectAndCount(reader, "roger_dns_response_questions", "roger_dns_response_answers"))
+
+	// Counts from the last response are still emitted when a later scrape fails
+	mock.msg.Answer = mock.msg.Answer[:5]
+	mock.msg.Answer[4] = &dns.A{Hdr: dns.RR_Header{Name: "hits.bind.", Rrtype: dns.TypeA}}
+	testutil.CollectAndCount(reader)
+	mock.err = errors.New("dns client error")
+
+	expected := `
+# HELP roger_dns_response_answers Number of answers in the most recent response from the DNS server
+# TYPE roger_dns_response_answers gauge
+roger_dns_response_answers{server="127.0.0.1:53"} 5
+# HELP roger_dns_response_questions Number of questions in the most recent response from the DNS server
+# TYPE roger_dns_response_questions gauge
+roger_dns_response_questions{server="127.0.0.1:53"} 7
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_dns_response_questions", "roger_dns_response_answers"))
+}
+
+func TestDnsmasqReader_ServerVersion(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		mock := mockDNSClient{msg: &dns.Msg{Answer: []dns.RR{txt("version.bind.", "dnsmasq-2.85")}}}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		version, err := reader.ServerVersion()
+
+		require.NoError(t, err)
+		assert.Equal(t, "dnsmasq-2.85", version)
+		assert.Equal(t, []dns.Question{question("version.bind.")}, mock.sent.Question)
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		mock := mockDNSClient{msg: &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeRefused}}}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		_, err := reader.ServerVersion()
+
+		assert.ErrorIs(t, err, ErrUpstream)
+	})
+
+	t.Run("no answer", func(t *testing.T) {
+		mock := mockDNSClient{msg: &dns.Msg{}}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		_, err := reader.ServerVersion()
+
+		assert.ErrorIs(t, err, ErrNumAnswers)
+	})
+}
+
+func TestDnsmasqReader_ServerHostname(t *testing.T) {
+	mock := mockDNSClient{msg: &dns.Msg{Answer: []dns.RR{txt("hostname.bind.", "dns1.example.com")}}}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	hostname, err := reader.ServerHostname()
+
+	require.NoError(t, err)
+	assert.Equal(t, "dns1.example.com", hostname)
+	assert.Equal(t, []dns.Question{question("hostname.bind.")}, mock.sent.Question)
+}
+
+func TestLabelByHostname(t *testing.T) {
+	unique := NewDnsmasqReader(&mockDNSClient{msg: &dns.Msg{Answer: []dns.RR{txt("hostname.bind.", "dns1.example.com")}}}, "10.0.0.1:53", log.NewNopLogger())
+	shared := &mockDNSClient{msg: &dns.Msg{Answer: []dns.RR{txt("hostname.bind.", "dns.example.com")}}}
+	first := NewDnsmasqReader(shared, "10.0.0.2:53", log.NewNopLogger())
+	second := NewDnsmasqReader(shared, "10.0.0.3:53", log.NewNopLogger())
+	failing := NewDnsmasqReader(&mockDNSClient{err: errors.New("connection refused")}, "10.0.0.4:53", log.NewNopLogger())
+
+	LabelByHostname([]*DnsmasqReader{unique, first, second, failing}, log.NewNopLogger())
+
+	assert.Equal(t, "dns1.example.com", unique.ServerLabel)
+	assert.Equal(t, "", first.ServerLabel)
+	assert.Equal(t, "", second.ServerLabel)
+	assert.Equal(t, "", failing.ServerLabel)
+}
+
+func TestDnsmasqReader_QueryClass(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("1004", "1003", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.QueryClass = dns.ClassINET
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1004), res.CacheHits)
+
+	require.Len(t, mock.sent.Question, 7)
+	for _, q := range mock.sent.Question {
+		assert.Equal(t, uint16(dns.ClassINET), q.Qclass, "class of %s", q.Name)
+	}
+	assert.Equal(t, "cachesize.bind.", mock.sent.Question[0].Name)
+}
+
+func TestParseQueryClass(t *testing.T) {
+	class, err := ParseQueryClass(QueryClassINET)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(dns.ClassINET), class)
+
+	class, err = ParseQueryClass("CHAOS")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(dns.ClassCHAOS), class)
+
+	_, err = ParseQueryClass("hesiod")
+	assert.Error(t, err)
+}
+
+func TestDnsmasqReader_ServerLabel(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.ServerLabel = "dns1.example.com"
+
+	expected := `
+# HELP roger_dns_cache_hits_total Number of hits in the DNS cache
+# TYPE roger_dns_cache_hits_total counter
+roger_dns_cache_hits_total{server="dns1.example.com"} 100
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_cache_hits_total"))
+}
+
+func TestDnsmasqReader_MetricLayout(t *testing.T) {
+	cacheMetrics := []string{
+		"roger_dns_cache_insertions_total",
+		"roger_dns_cache_evictions_total",
+		"roger_dns_cache_hits_total",
+		"roger_dns_cache_misses_total",
+		"roger_dns_cache_events_total",
+	}
+
+	t.Run("separate", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("1004", "1003", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+		expected := `
+# HELP roger_dns_cache_evictions_total Number of evictions in the DNS cache
+# TYPE roger_dns_cache_evictions_total counter
+roger_dns_cache_evictions_total{server="127.0.0.1:53"} 1002
+# HELP roger_dns_cache_hits_total Number of hits in the DNS cache
+# TYPE roger_dns_cache_hits_total counter
+roger_dns_cache_hits_total{server="127.0.0.1:53"} 1004
+# HELP roger_dns_cache_insertions_total Number of inserts in the DNS cache
+# TYPE roger_dns_cache_insertions_total counter
+roger_dns_cache_insertions_total{server="127.0.0.1:53"} 1001
+# HELP roger_dns_cache_misses_total Number of misses in the DNS cache
+# TYPE roger_dns_cache_misses_total counter
+roger_dns_cache_misses_total{server="127.0.0.1:53"} 1003
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), cacheMetrics...))
+	})
+
+	t.Run("labeled", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("1004", "1003", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.MetricLayout = DnsMetricLayoutLabeled
+
+		expected := `
+# HELP roger_dns_cache_events_total Number of insertions, evictions, hits, and misses in the DNS cache, by event
+# TYPE roger_dns_cache_events_total counter
+roger_dns_cache_events_total{event="eviction",server="127.0.0.1:53"} 1002
+roger_dns_cache_events_total{event="hit",server="127.0.0.1:53"} 1004
+roger_dns_cache_events_total{event="insertion",server="127.0.0.1:53"} 1001
+roger_dns_cache_events_total{event="miss",server="127.0.0.1:53"} 1003
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), cacheMetrics...))
+	})
+}
+
+func TestDnsmasqReader_ServerInfo(t *testing.T) {
+	const expectedTpl = `
+# HELP roger_dns_server_info DNS server version from a version.bind. query, always 1
+# TYPE roger_dns_server_info gauge
+roger_dns_server_info{server="127.0.0.1:53",version="%s"} 1
+`
+
+	t.Run("version cached", func(t *testing.T) {
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		mock.msg.Answer = append(mock.msg.Answer, txt("version.bind.", "dnsmasq-2.85"))
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.now = func() time.Time { return now }
+
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, "dnsmasq-2.85")), "roger_dns_server_info"))
+
+		mock.msg.Answer[7] = txt("version.bind.", "dnsmasq-2.86")
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, "dnsmasq-2.85")), "roger_dns_server_info"))
+
+		now = now.Add(DefaultInfoTTL)
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, "dnsmasq-2.86")), "roger_dns_server_info"))
+	})
+
+	t.Run("info ttl", func(t *testing.T) {
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		mock.msg.Answer = append(mock.msg.Answer, txt("version.bind.", "dnsmasq-2.85"))
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.now = func() time.Time { return now }
+		reader.InfoTTL = 5 * time.Minute
+
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, "dnsmasq-2.85")), "roger_dns_server_info"))
+
+		mock.msg.Answer[7] = txt("version.bind.", "dnsmasq-2.86")
+		now = now.Add(4 * time.Minute)
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, "dnsmasq-2.85")), "roger_dns_server_info"))
+
+		now = now.Add(time.Minute)
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(fmt.Sprintf(expectedTpl, "dnsmasq-2.86")), "roger_dns_server_info"))
+	})
+
+	t.Run("version refused", func(t *testing.T) {
+		mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+		reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+
+		assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_dns_server_info"))
+		assert.Equal(t, 2, testutil.CollectAndCount(reader, "roger_dns_cache_hits_total", "roger_dns_cache_misses_total"))
+	})
+}
+
+func TestDnsmasqReader_ScrapeRTT(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.RTTBuckets = []float64{0.5, 2}
+
+	testutil.CollectAndCount(reader)
+	mock.err = errors.New("dns client error")
+
+	expected := `
+# HELP roger_dns_scrape_rtt_seconds Round trip time of stats queries to the DNS server in seconds
+# TYPE roger_dns_scrape_rtt_seconds histogram
+roger_dns_scrape_rtt_seconds_bucket{server="127.0.0.1:53",le="0.5"} 0
+roger_dns_scrape_rtt_seconds_bucket{server="127.0.0.1:53",le="2"} 1
+roger_dns_scrape_rtt_seconds_bucket{server="127.0.0.1:53",le="+Inf"} 1
+roger_dns_scrape_rtt_seconds_sum{server="127.0.0.1:53"} 1
+roger_dns_scrape_rtt_seconds_count{server="127.0.0.1:53"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_scrape_rtt_seconds"))
+}
+
+func TestDnsmasqReader_Dialer(t *testing.T) {
+	mock := mockDNSClient{err: errors.New("client should not be used")}
+	reader := NewDnsmasqReader(&mock, "10.0.0.1:53", log.NewNopLogger())
+	reader.Dialer = &pipeDialer{response: statsMsg("100", "100", "100")}
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), res.CacheHits)
+	assert.Equal(t, ProtocolTCP, res.Transport)
+	assert.Nil(t, mock.sent)
+}
+
+func TestParseIntRecord(t *testing.T) {
+	t.Run("base 10", func(t *testing.T) {
+		val, err := parseIntRecord(txt("hits.bind.", "1000"), 10)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1000), val)
+
+		_, err = parseIntRecord(txt("hits.bind.", "3e8"), 10)
+		assert.Error(t, err)
+	})
+
+	t.Run("base 10 with decimal point", func(t *testing.T) {
+		val, err := parseIntRecord(txt("hits.bind.", "1000.0"), 10)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1000), val)
+
+		val, err = parseIntRecord(txt("hits.bind.", "1000.75"), 10)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1000), val)
+
+		_, err = parseIntRecord(txt("hits.bind.", "1.5e3"), 10)
+		assert.Error(t, err)
+
+		_, err = parseIntRecord(txt("hits.bind.", ".5"), 10)
+		assert.Error(t, err)
+	})
+
+	t.Run("servers with decimal point", func(t *testing.T) {
+		servers, err := parseServersRecord(txt("servers.bind.", "1.1.1.1#53 1000.0 5.0"), 10, log.NewNopLogger())
+		require.NoError(t, err)
+		assert.Equal(t, []ServerStats{{Address: "1.1.1.1#53", QueriesSent: 1000, QueryErrors: 5, Family: "ipv4"}}, servers)
+	})
+
+	t.Run("base 16", func(t *testing.T) {
+		val, err := parseIntRecord(txt("hits.bind.", "3e8"), 16)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1000), val)
+	})
+}
+
+func TestDnsmasqReader_NumberBase(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("64", "c8", "12c")}
+	mock.msg.Answer[6] = txt("servers.bind.", "1.1.1.1#53 3e8 1f4")
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.NumberBase = 16
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), res.CacheHits)
+	assert.Equal(t, uint64(200), res.CacheMisses)
+	assert.Equal(t, uint64(300), res.Authoritative)
+	assert.Equal(t, uint64(1000), res.Servers[0].QueriesSent)
+	assert.Equal(t, uint64(500), res.Servers[0].QueryErrors)
+}
+
+func TestDnsmasqReader_ExtraQueries(t *testing.T) {
+	mock := mockDNSClient{msg: statsMsg("100", "100", "100")}
+	mock.msg.Answer = append(mock.msg.Answer, txt("tftp.bind.", "12"), txt("pxe-boots.bind.", "fail"))
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.ExtraQueries = []string{"tftp", "pxe-boots", "missing"}
+
+	res, err := reader.ReadMetrics()
+	assert.ErrorIs(t, err, ErrParseAnswer)
+	assert.Len(t, mock.sent.Question, 10)
+	assert.Equal(t, question("missing.bind."), mock.sent.Question[9])
+	assert.Equal(t, map[string]uint64{"tftp": 12}, res.Extra)
+	assert.Equal(t, []DroppedAnswer{{Name: "pxe-boots.bind.", Reason: "invalid"}}, res.Dropped)
+
+	expected := `
+# HELP roger_dns_tftp Value of tftp.bind. from the DNS server
+# TYPE roger_dns_tftp untyped
+roger_dns_tftp{server="127.0.0.1:53"} 12
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_dns_tftp", "roger_dns_pxe_boots"))
+}
+
+func TestExtraMetricName(t *testing.T) {
+	assert.Equal(t, "roger_dns_tftp", extraMetricName("tftp"))
+	assert.Equal(t, "roger_dns_pxe_boots", extraMetricName("pxe-boots"))
+}
+
+func TestCheckExtraQuery(t *testing.T) {
+	assert.NoError(t, CheckExtraQuery("tftp"))
+	assert.Error(t, CheckExtraQuery("up"))
+	assert.Error(t, CheckExtraQuery("cache-size"))
+	assert.Error(t, CheckExtraQuery("response_rtt_seconds_bucket"))
+}
+
+func TestDNSMetricNames(t *testing.T) {
+	base := t.TempDir()
+	writeProcessFixture(t, base, "1234")
+
+	separate := NewDnsmasqReader(&staticDNSClient{msg: statsMsg("1", "2", "3")}, "127.0.0.1:53", log.NewNopLogger())
+	labeled := NewDnsmasqReader(&staticDNSClient{msg: statsMsg("1", "2", "3")}, "127.0.0.2:53", log.NewNopLogger())
+	labeled.MetricLayout = DnsMetricLayoutLabeled
+
+	inventory := NewInventoryCollector()
+	inventory.AddServer("127.0.0.1:53")
+
+	for _, c := range []prometheus.Collector{separate, labeled, NewProcessReader(base, 1234, "", log.NewNopLogger()), inventory} {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(c)
+
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		for _, f := range families {
+			if strings.HasPrefix(f.GetName(), "roger_dns_") {
+				assert.True(t, dnsMetricNames[f.GetName()], "%s isn't in dnsMetricNames", f.GetName())
+			}
+		}
+	}
+}
+
+// flakyDNSClient fails a number of exchanges before delegating to another client.
+type flakyDNSClient struct {
+	failures int
+	calls    int
+	client   dnsClient
+}
+
+func (c *flakyDNSClient) Exchange(q *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	c.calls++
+	if c.calls <= c.failures {
+		return nil, 0, errors.New("i/o timeout")
+	}
+
+	return c.client.Exchange(q, address)
+}
+
+func TestDnsmasqReader_Retries(t *testing.T) {
+	t.Run("succeeds after retry", func(t *testing.T) {
+		mock := &flakyDNSClient{failures: 2, client: &mockDNSClient{msg: statsMsg("1", "2", "3")}}
+
+		reader := NewDnsmasqReader(mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.Retries = 2
+
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), res.CacheHits)
+		assert.Equal(t, 3, mock.calls)
+	})
+
+	t.Run("fails after retries", func(t *testing.T) {
+		mock := &flakyDNSClient{failures: 3, client: &mockDNSClient{msg: statsMsg("1", "2", "3")}}
+
+		reader := NewDnsmasqReader(mock, "127.0.0.1:53", log.NewNopLogger())
+		reader.Retries = 2
+
+		_, err := reader.ReadMetrics()
+		assert.ErrorIs(t, err, ErrUpstream)
+		assert.Equal(t, 3, mock.calls)
+	})
+
+	t.Run("no retries", func(t *testing.T) {
+		mock := &flakyDNSClient{failures: 1, client: &mockDNSClient{msg: statsMsg("1", "2", "3")}}
+
+		reader := NewDnsmasqReader(mock, "127.0.0.1:53", log.NewNopLogger())
+
+		_, err := reader.ReadMetrics()
+		assert.ErrorIs(t, err, ErrUpstream)
+		assert.Equal(t, 1, mock.calls)
+	})
+}
+
+func TestDnsmasqReader_ChaosSuffix(t *testing.T) {
+	var mock mockDNSClient
+	mock.msg = &dns.Msg{
+		Answer: []dns.RR{
+			txt("cachesize.server.", "100"),
+			txt("hits.server.", "10"),
+			txt("hits.bind.", "20"),
+		},
+	}
+
+	reader := NewDnsmasqReader(&mock, "127.0.0.1:53", log.NewNopLogger())
+	reader.ChaosSuffix = ".server."
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "cachesize.server.", mock.sent.Question[0].Name)
+	assert.Equal(t, uint64(100), res.CacheSize)
+	assert.Equal(t, uint64(10), res.CacheHits)
+}
@@ -0,0 +1,104 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// drop metrics from collectors by name
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricFilter decides which metrics are emitted based on their names. If there are
+// any Allow patterns, only names matching one of them are emitted. Names matching any
+// of the Deny patterns are then dropped, even if they were allowed.
+type MetricFilter struct {
+	Allow []*regexp.Regexp
+	Deny  []*regexp.Regexp
+}
+
+// NewMetricFilter creates a filter from allow and deny patterns. Patterns must match
+// the entire metric name.
+func NewMetricFilter(allow []string, deny []string) (*MetricFilter, error) {
+	allowRe, err := compileAnchored(allow)
+	if err != nil {
+		return nil, err
+	}
+
+	denyRe, err := compileAnchored(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricFilter{Allow: allowRe, Deny: denyRe}, nil
+}
+
+// Allowed returns true if metrics with the given name should be emitted.
+func (f *MetricFilter) Allowed(name string) bool {
+	if len(f.Allow) > 0 && !matchAny(f.Allow, name) {
+		return false
+	}
+
+	return !matchAny(f.Deny, name)
+}
+
+// FilteredGatherer wraps another gatherer, dropping Roger metrics (those named
+// roger_*) that aren't allowed by a MetricFilter. Metrics are filtered by the names
+// of the gathered families so that the names are those actually exported, other
+// metrics such as the Go runtime metrics are always kept.
+type FilteredGatherer struct {
+	gatherer prometheus.Gatherer
+	filter   *MetricFilter
+}
+
+func NewFilteredGatherer(gatherer prometheus.Gatherer, filter *MetricFilter) *FilteredGatherer {
+	return &FilteredGatherer{gatherer: gatherer, filter: filter}
+}
+
+func (f *FilteredGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := f.gatherer.Gather()
+
+	out := families[:0]
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "roger_") || f.filter.Allowed(mf.GetName()) {
+			out = append(out, mf)
+		}
+	}
+
+	return out, err
+}
+
+func compileAnchored(patterns []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, re)
+	}
+
+	return out, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package roger
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricFilter_Allowed(t *testing.T) {
+	t.Run("no patterns", func(t *testing.T) {
+		filter, err := NewMetricFilter(nil, nil)
+		require.NoError(t, err)
+		assert.True(t, filter.Allowed("roger_net_rx_bytes"))
+	})
+
+	t.Run("deny only", func(t *testing.T) {
+		filter, err := NewMetricFilter(nil, []string{"roger_net_(rx|tx)_compressed"})
+		require.NoError(t, err)
+		assert.True(t, filter.Allowed("roger_net_rx_bytes"))
+		assert.False(t, filter.Allowed("roger_net_rx_compressed"))
+		assert.False(t, filter.Allowed("roger_net_tx_compressed"))
+	})
+
+	t.Run("allow only", func(t *testing.T) {
+		filter, err := NewMetricFilter([]string{"roger_net_rx_.*"}, nil)
+		require.NoError(t, err)
+		assert.True(t, filter.Allowed("roger_net_rx_bytes"))
+		assert.False(t, filter.Allowed("roger_net_tx_bytes"))
+	})
+
+	t.Run("deny after allow", func(t *testing.T) {
+		filter, err := NewMetricFilter([]string{"roger_net_rx_.*"}, []string{".*_compressed"})
+		require.NoError(t, err)
+		assert.True(t, filter.Allowed("roger_net_rx_bytes"))
+		assert.False(t, filter.Allowed("roger_net_rx_compressed"))
+		assert.False(t, filter.Allowed("roger_net_tx_bytes"))
+	})
+
+	t.Run("patterns match entire name", func(t *testing.T) {
+		filter, err := NewMetricFilter(nil, []string{"roger_net_rx"})
+		require.NoError(t, err)
+		assert.True(t, filter.Allowed("roger_net_rx_bytes"))
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, err := NewMetricFilter([]string{"roger_("}, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestFilteredGatherer(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	filter, err := NewMetricFilter([]string{"roger_net_.*"}, []string{"roger_net_(rx|tx)_compressed"})
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewProcNetDevReader(base, log.NewNopLogger()))
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines", Help: "Goroutines"}))
+
+	families, err := NewFilteredGatherer(registry, filter).Gather()
+	require.NoError(t, err)
+
+	var names []string
+	for _, mf := range families {
+		names = append(names, mf.GetName())
+	}
+
+	assert.NotContains(t, names, "roger_net_rx_compressed")
+	assert.NotContains(t, names, "roger_net_tx_compressed")
+	assert.NotContains(t, names, "roger_proc_file_mtime_seconds")
+	assert.Contains(t, names, "roger_net_rx_bytes")
+	assert.Contains(t, names, "go_goroutines")
+}
@@ -0,0 +1,218 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// push gathered metrics to a Graphite carbon endpoint using the plaintext protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// GraphitePusher periodically gathers metrics and writes them to a carbon TCP endpoint
+// using the Graphite plaintext protocol, one "path value timestamp" line per sample.
+// Each underscore separated part of metric names and the name and value of each label
+// become components of the path, e.g.
+//
+//	roger_dns_cache_hits{server="127.0.0.1:53"} 1523
+//
+// becomes
+//
+//	roger.dns.cache.hits.server.127_0_0_1_53 1523 1600000000
+//
+// Histograms and summaries are written as a count, sum and a path for each bucket or
+// quantile. Samples that aren't finite numbers are skipped since Graphite can't store
+// them. Metrics that fail to gather are skipped and the rest still written.
+type GraphitePusher struct {
+	address  string
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	now      func() time.Time
+	logger   log.Logger
+}
+
+// NewGraphitePusher creates a pusher writing metrics from the gatherer to address, the
+// host and port of a carbon plaintext receiver, e.g. localhost:2003.
+func NewGraphitePusher(address string, gatherer prometheus.Gatherer, interval time.Duration, logger log.Logger) *GraphitePusher {
+	return &GraphitePusher{
+		address:  address,
+		gatherer: gatherer,
+		interval: interval,
+		now:      time.Now,
+		logger:   logger,
+	}
+}
+
+// Run pushes metrics on the interval until the context is canceled.
+func (g *GraphitePusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := g.Push(ctx); err != nil {
+				level.Error(g.logger).Log("msg", "failed to push metrics to Graphite", "address", g.address, "err", err)
+			}
+		}
+	}
+}
+
+// Push gathers metrics and writes them to the endpoint once, using a new connection
+// for each push so that restarts of the receiver don't need to be detected.
+func (g *GraphitePusher) Push(ctx context.Context) error {
+	families, err := g.gatherer.Gather()
+	if err != nil {
+		level.Warn(g.logger).Log("msg", "error gathering metrics to push to Graphite, pushing partial results", "err", err)
+	}
+
+	body := g.lines(families)
+
+	ctx, cancel := context.WithTimeout(ctx, g.interval)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", g.address)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+
+	if _, err := conn.Write(body); err != nil {
+		return fmt.Errorf("unable to write metrics to Graphite: %w", err)
+	}
+
+	return nil
+}
+
+// lines converts gathered metric families to Graphite plaintext lines.
+func (g *GraphitePusher) lines(families []*dto.MetricFamily) []byte {
+	var buf bytes.Buffer
+	now := g.now()
+
+	for _, f := range families {
+		base := graphiteName(f.GetName())
+
+		for _, m := range f.GetMetric() {
+			path := base + graphiteLabels(m)
+			ts := now
+			if m.TimestampMs != nil {
+				ts = time.UnixMilli(m.GetTimestampMs())
+			}
+
+			write := func(path string, val float64) {
+				if math.IsNaN(val) || math.IsInf(val, 0) {
+					return
+				}
+
+				fmt.Fprintf(&buf, "%s %s %d\n", path, strconv.FormatFloat(val, 'g', -1, 64), ts.Unix())
+			}
+
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				write(path, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				write(path, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				write(path, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				write(path+".count", float64(h.GetSampleCount()))
+				write(path+".sum", h.GetSampleSum())
+				// The +Inf bucket is implied by the count unless it's included explicitly
+				for _, b := range h.GetBucket() {
+					if !math.IsInf(b.GetUpperBound(), +1) {
+						write(path+".bucket.le."+graphiteBound(b.GetUpperBound()), float64(b.GetCumulativeCount()))
+					}
+				}
+				write(path+".bucket.le.inf", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				write(path+".count", float64(s.GetSampleCount()))
+				write(path+".sum", s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					write(path+".quantile."+graphiteBound(q.GetQuantile()), q.GetValue())
+				}
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// graphiteName converts a metric name to a Graphite path, with each underscore
+// separated word of the name as a component of the path.
+func graphiteName(name string) string {
+	parts := strings.Split(name, "_")
+	for i, p := range parts {
+		parts[i] = graphiteComponent(p)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// graphiteLabels returns the name and value of each label of the metric as components
+// of a Graphite path, in the order of the label names.
+func graphiteLabels(m *dto.Metric) string {
+	var sb strings.Builder
+	for _, l := range m.GetLabel() {
+		sb.WriteByte('.')
+		sb.WriteString(graphiteComponent(l.GetName()))
+		sb.WriteByte('.')
+		sb.WriteString(graphiteComponent(l.GetValue()))
+	}
+
+	return sb.String()
+}
+
+// graphiteBound returns the upper bound of a bucket or a quantile as a path component.
+func graphiteBound(v float64) string {
+	if math.IsInf(v, +1) {
+		return "inf"
+	}
+
+	return graphiteComponent(strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// graphiteComponent replaces characters that aren't allowed in a single component of
+// a Graphite path (dots separate components, spaces separate fields of a line) with
+// underscores. Empty components are replaced with an underscore as well.
+func graphiteComponent(s string) string {
+	if s == "" {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+
+		return '_'
+	}, s)
+}
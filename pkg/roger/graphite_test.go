@@ -0,0 +1,82 @@
+package roger
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphitePusher_Push(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "roger_dns_cache_hits", Help: "Hits"}, []string{"server"})
+	counter.WithLabelValues("127.0.0.1:53").Add(1523)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "roger_nf_conntrack_entries", Help: "Entries"})
+	gauge.Set(162)
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "roger_dns_rtt_seconds", Help: "RTT", Buckets: []float64{0.1, 1}})
+	hist.Observe(0.25)
+	hist.Observe(0.5)
+	registry.MustRegister(counter, gauge, hist)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+
+		defer func() { _ = conn.Close() }()
+		body, _ := io.ReadAll(conn)
+		received <- string(body)
+	}()
+
+	pusher := NewGraphitePusher(listener.Addr().String(), registry, time.Second, log.NewNopLogger())
+	pusher.now = func() time.Time { return time.Unix(1600000000, 0) }
+	require.NoError(t, pusher.Push(context.Background()))
+
+	expected := []string{
+		"roger.dns.cache.hits.server.127_0_0_1_53 1523 1600000000",
+		"roger.dns.rtt.seconds.count 2 1600000000",
+		"roger.dns.rtt.seconds.sum 0.75 1600000000",
+		"roger.dns.rtt.seconds.bucket.le.0_1 0 1600000000",
+		"roger.dns.rtt.seconds.bucket.le.1 2 1600000000",
+		"roger.dns.rtt.seconds.bucket.le.inf 2 1600000000",
+		"roger.nf.conntrack.entries 162 1600000000",
+	}
+
+	select {
+	case body := <-received:
+		assert.Equal(t, expected, strings.Split(strings.TrimSpace(body), "\n"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for metrics")
+	}
+}
+
+func TestGraphitePusher_PushError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	pusher := NewGraphitePusher(addr, prometheus.NewRegistry(), time.Second, log.NewNopLogger())
+	assert.Error(t, pusher.Push(context.Background()))
+}
+
+func TestGraphiteComponent(t *testing.T) {
+	assert.Equal(t, "127_0_0_1_53", graphiteComponent("127.0.0.1:53"))
+	assert.Equal(t, "eth0", graphiteComponent("eth0"))
+	assert.Equal(t, "some_value", graphiteComponent("some value"))
+	assert.Equal(t, "_", graphiteComponent(""))
+}
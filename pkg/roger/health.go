@@ -0,0 +1,69 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// liveness check that collectors aren't wedged
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is how long a collection can run before the collector is
+// considered wedged by health checks.
+const DefaultHealthCheckTimeout = 1 * time.Minute
+
+// HealthCheck reports whether any collector has been collecting for longer than it
+// should, so that a collector that never returns makes liveness checks fail instead
+// of only every scrape. Collections are tracked by a CollectorStatus instead of being
+// run by the check, so checks are cheap and don't send queries to DNS servers or
+// disturb metrics computed since the previous collection (such as
+// roger_dns_queries_per_second).
+type HealthCheck struct {
+	// Timeout is how long a collection can run before the collector is considered
+	// wedged. Defaults to DefaultHealthCheckTimeout.
+	Timeout time.Duration
+
+	status *CollectorStatus
+}
+
+func NewHealthCheck(status *CollectorStatus) *HealthCheck {
+	return &HealthCheck{
+		Timeout: DefaultHealthCheckTimeout,
+		status:  status,
+	}
+}
+
+// Check returns an error if any collector has been collecting for longer than Timeout.
+// Collectors that fail are expected (scrapes continue on error) and aren't considered
+// unhealthy.
+func (h *HealthCheck) Check() error {
+	if wedged := h.status.Running(h.Timeout); len(wedged) > 0 {
+		return fmt.Errorf("collectors running for more than %s: %s", h.Timeout, strings.Join(wedged, ", "))
+	}
+
+	return nil
+}
+
+// Handler returns a handler that responds with 200 if no collectors are wedged and
+// 503 otherwise.
+func (h *HealthCheck) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
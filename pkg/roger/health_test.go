@@ -0,0 +1,108 @@
+package roger
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCollector doesn't finish collecting until released.
+type blockingCollector struct {
+	mockCollector
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingCollector() *blockingCollector {
+	return &blockingCollector{
+		mockCollector: *newMockCollector(),
+		started:       make(chan struct{}),
+		release:       make(chan struct{}),
+	}
+}
+
+func (b *blockingCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	close(b.started)
+	<-b.release
+	return b.mockCollector.CollectWithError(ch)
+}
+
+// newHealthCheckStatus returns a status with a clock that can be moved forward.
+func newHealthCheckStatus() (*CollectorStatus, *atomic.Int64) {
+	var offset atomic.Int64
+	start := time.Now()
+
+	status := NewCollectorStatus()
+	status.now = func() time.Time { return start.Add(time.Duration(offset.Load())) }
+	return status, &offset
+}
+
+func TestHealthCheck_Check(t *testing.T) {
+	t.Run("idle", func(t *testing.T) {
+		status, _ := newHealthCheckStatus()
+		_ = status.Collector("working", newMockCollector(), log.NewNopLogger())
+
+		assert.NoError(t, NewHealthCheck(status).Check())
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		status, _ := newHealthCheckStatus()
+		failing := &mockCollector{desc: prometheus.NewDesc("roger_test_failing", "Test failing", nil, nil), err: errors.New("read failed")}
+		c := status.Collector("failing", failing, log.NewNopLogger())
+		_ = c.CollectWithError(make(chan prometheus.Metric, 1))
+
+		assert.NoError(t, NewHealthCheck(status).Check())
+	})
+
+	t.Run("wedged", func(t *testing.T) {
+		status, offset := newHealthCheckStatus()
+		blocking := newBlockingCollector()
+		c := status.Collector("blocking", blocking, log.NewNopLogger())
+
+		done := make(chan struct{})
+		go func() {
+			_ = c.CollectWithError(make(chan prometheus.Metric, 1))
+			close(done)
+		}()
+		<-blocking.started
+
+		check := NewHealthCheck(status)
+		assert.NoError(t, check.Check())
+
+		offset.Store(int64(DefaultHealthCheckTimeout + time.Second))
+		assert.ErrorContains(t, check.Check(), "blocking")
+
+		close(blocking.release)
+		<-done
+		assert.NoError(t, check.Check())
+	})
+}
+
+func TestHealthCheck_Handler(t *testing.T) {
+	status, offset := newHealthCheckStatus()
+	blocking := newBlockingCollector()
+	c := status.Collector("blocking", blocking, log.NewNopLogger())
+
+	go func() { _ = c.CollectWithError(make(chan prometheus.Metric, 1)) }()
+	defer close(blocking.release)
+	<-blocking.started
+
+	handler := NewHealthCheck(status).Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	offset.Store(int64(DefaultHealthCheckTimeout + time.Second))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -0,0 +1,252 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// read basic host vitals from /proc/loadavg and /proc/meminfo
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type hostDescriptions struct {
+	load1                *prometheus.Desc
+	load5                *prometheus.Desc
+	load15               *prometheus.Desc
+	memoryTotalBytes     *prometheus.Desc
+	memoryAvailableBytes *prometheus.Desc
+}
+
+func newHostDescriptions() *hostDescriptions {
+	return &hostDescriptions{
+		load1: prometheus.NewDesc(
+			"roger_host_load1",
+			"1 minute load average of the host",
+			nil,
+			nil,
+		),
+		load5: prometheus.NewDesc(
+			"roger_host_load5",
+			"5 minute load average of the host",
+			nil,
+			nil,
+		),
+		load15: prometheus.NewDesc(
+			"roger_host_load15",
+			"15 minute load average of the host",
+			nil,
+			nil,
+		),
+		memoryTotalBytes: prometheus.NewDesc(
+			"roger_host_memory_total_bytes",
+			"Total usable memory of the host in bytes",
+			nil,
+			nil,
+		),
+		memoryAvailableBytes: prometheus.NewDesc(
+			"roger_host_memory_available_bytes",
+			"Estimate of memory available to start new applications on the host in bytes",
+			nil,
+			nil,
+		),
+	}
+}
+
+type HostResult struct {
+	Load1                float64
+	Load5                float64
+	Load15               float64
+	MemoryTotalBytes     uint64
+	MemoryAvailableBytes uint64
+}
+
+// HostReader reads basic vitals of the host Roger is running on for installs
+// where running a separate node_exporter isn't practical.
+type HostReader struct {
+	// Timeout, if positive, is the most time spent reading loadavg and meminfo on
+	// each collection. Collection fails if they can't be read in time.
+	Timeout time.Duration
+
+	loadAvgPath  string
+	memInfoPath  string
+	descriptions *hostDescriptions
+	logger       log.Logger
+	errLog       *errorLogLimiter
+}
+
+func NewHostReader(base string, logger log.Logger) *HostReader {
+	return &HostReader{
+		loadAvgPath:  filepath.Join(base, "loadavg"),
+		memInfoPath:  filepath.Join(base, "meminfo"),
+		descriptions: newHostDescriptions(),
+		logger:       logger,
+		errLog:       newErrorLogLimiter(procErrorLogInterval),
+	}
+}
+
+func (h *HostReader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.descriptions.load1
+	ch <- h.descriptions.load5
+	ch <- h.descriptions.load15
+	ch <- h.descriptions.memoryTotalBytes
+	ch <- h.descriptions.memoryAvailableBytes
+}
+
+func (h *HostReader) Collect(ch chan<- prometheus.Metric) {
+	err := h.CollectWithError(ch)
+	if err == nil {
+		if h.errLog.recovered() {
+			level.Info(h.logger).Log("msg", "host metrics collected successfully after failures")
+		}
+
+		return
+	}
+
+	if ok, suppressed := h.errLog.failed(); ok {
+		level.Error(h.logger).Log("msg", "failed to read host metrics during collection", "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits host metrics, returning an error if loadavg or meminfo
+// could not be read.
+func (h *HostReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	ctx, cancel := collectContext(h.Timeout)
+	defer cancel()
+
+	res, err := h.ReadMetricsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	ch <- prometheus.MustNewConstMetric(h.descriptions.load1, prometheus.GaugeValue, res.Load1)
+	ch <- prometheus.MustNewConstMetric(h.descriptions.load5, prometheus.GaugeValue, res.Load5)
+	ch <- prometheus.MustNewConstMetric(h.descriptions.load15, prometheus.GaugeValue, res.Load15)
+	ch <- prometheus.MustNewConstMetric(h.descriptions.memoryTotalBytes, prometheus.GaugeValue, float64(res.MemoryTotalBytes))
+	ch <- prometheus.MustNewConstMetric(h.descriptions.memoryAvailableBytes, prometheus.GaugeValue, float64(res.MemoryAvailableBytes))
+
+	return nil
+}
+
+func (h *HostReader) Exists() bool {
+	if _, err := os.Stat(h.loadAvgPath); os.IsNotExist(err) {
+		return false
+	}
+
+	if _, err := os.Stat(h.memInfoPath); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
+
+func (h *HostReader) ReadMetrics() (*HostResult, error) {
+	return h.ReadMetricsContext(context.Background())
+}
+
+// ReadMetricsContext returns the host vitals, returning an error wrapping the error of
+// the context if it's canceled or its deadline is exceeded before reading all of them.
+func (h *HostReader) ReadMetricsContext(ctx context.Context) (*HostResult, error) {
+	load1, load5, load15, err := readLoadAvg(h.loadAvgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	total, available, err := readMemInfo(ctx, h.memInfoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HostResult{
+		Load1:                load1,
+		Load5:                load5,
+		Load15:               load15,
+		MemoryTotalBytes:     total,
+		MemoryAvailableBytes: available,
+	}, nil
+}
+
+func readLoadAvg(path string) (float64, float64, float64, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	// The first three fields are the load averages, followed by the number of
+	// runnable and total scheduling entities and the most recently created pid.
+	fields := strings.Fields(string(contents))
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("expected at least 3 loadavg fields, got %d from %s", len(fields), path)
+	}
+
+	var loads [3]float64
+	for i := range loads {
+		loads[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return loads[0], loads[1], loads[2], nil
+}
+
+func readMemInfo(ctx context.Context, path string) (uint64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var (
+		total     uint64
+		available uint64
+	)
+
+	scanner := bufio.NewScanner(f)
+	for read := 0; scanner.Scan(); read++ {
+		if err := procScanErr(ctx, read); err != nil {
+			return 0, 0, fmt.Errorf("stopped reading %s after %d lines: %w", path, read, err)
+		}
+
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "MemTotal:":
+			// Reported in kB but actually KiB, the same as /proc/<pid>/status
+			total, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			total *= 1024
+		case "MemAvailable:":
+			available, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			available *= 1024
+		}
+	}
+
+	return total, available, scanner.Err()
+}
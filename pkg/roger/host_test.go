@@ -0,0 +1,109 @@
+package roger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const loadAvgFixture = "0.52 0.58 0.59 1/389 123456\n"
+
+const memInfoFixture = `MemTotal:        8048576 kB
+MemFree:         1048576 kB
+MemAvailable:    4194304 kB
+Buffers:          262144 kB
+`
+
+func TestHostReader_Exists(t *testing.T) {
+	base := t.TempDir()
+	reader := NewHostReader(base, log.NewNopLogger())
+	assert.False(t, reader.Exists())
+
+	writeProcFixture(t, base, "loadavg", loadAvgFixture)
+	writeProcFixture(t, base, "meminfo", memInfoFixture)
+	assert.True(t, reader.Exists())
+}
+
+func TestHostReader_ReadMetrics(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "loadavg", loadAvgFixture)
+		writeProcFixture(t, base, "meminfo", memInfoFixture)
+
+		res, err := NewHostReader(base, log.NewNopLogger()).ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Equal(t, 0.52, res.Load1)
+		assert.Equal(t, 0.58, res.Load5)
+		assert.Equal(t, 0.59, res.Load15)
+		assert.Equal(t, uint64(8048576*1024), res.MemoryTotalBytes)
+		assert.Equal(t, uint64(4194304*1024), res.MemoryAvailableBytes)
+	})
+
+	t.Run("bad loadavg", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "loadavg", "0.52\n")
+		writeProcFixture(t, base, "meminfo", memInfoFixture)
+
+		_, err := NewHostReader(base, log.NewNopLogger()).ReadMetrics()
+
+		assert.Error(t, err)
+	})
+}
+
+func TestHostReader_ReadMetricsContextCanceled(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "loadavg", loadAvgFixture)
+	writeProcFixture(t, base, "meminfo", memInfoFixture)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader := NewHostReader(base, log.NewNopLogger())
+	_, err := reader.ReadMetricsContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHostReader_Collect(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "loadavg", loadAvgFixture)
+	writeProcFixture(t, base, "meminfo", memInfoFixture)
+
+	expected := `
+# HELP roger_host_load1 1 minute load average of the host
+# TYPE roger_host_load1 gauge
+roger_host_load1 0.52
+# HELP roger_host_memory_available_bytes Estimate of memory available to start new applications on the host in bytes
+# TYPE roger_host_memory_available_bytes gauge
+roger_host_memory_available_bytes 4.294967296e+09
+`
+	reader := NewHostReader(base, log.NewNopLogger())
+	assert.Equal(t, 5, testutil.CollectAndCount(reader))
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_host_load1", "roger_host_memory_available_bytes"))
+}
+
+func TestHostReader_CollectLogRateLimited(t *testing.T) {
+	base := t.TempDir()
+
+	var buf bytes.Buffer
+	reader := NewHostReader(base, log.NewLogfmtLogger(&buf))
+
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	assert.Equal(t, 1, strings.Count(buf.String(), "failed to read host metrics"))
+
+	writeProcFixture(t, base, "loadavg", loadAvgFixture)
+	writeProcFixture(t, base, "meminfo", memInfoFixture)
+
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	assert.Equal(t, 1, strings.Count(buf.String(), "collected successfully after failures"))
+}
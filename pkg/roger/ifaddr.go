@@ -0,0 +1,286 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// read addresses assigned to network interfaces from /proc/net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/bits"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// netAddrsCacheTTL is how long addresses read for a CIDRFilter are reused. It's much
+// shorter than any scrape interval so that the files are read once per collection
+// instead of once for every interface checked by the filter.
+const netAddrsCacheTTL = time.Second
+
+// ProcNetAddrs reads the addresses of network interfaces. IPv6 addresses are read
+// from /proc/net/if_inet6. IPv4 addresses aren't listed per interface anywhere in
+// /proc so local addresses are read from /proc/net/fib_trie and each is assigned to
+// the interface of the most specific directly connected route in /proc/net/route
+// that contains it. Local addresses outside of every connected route, such as those
+// of the loopback interface, can't be assigned to an interface and are skipped.
+type ProcNetAddrs struct {
+	fibTriePath string
+	routePath   string
+	inet6Path   string
+	now         func() time.Time
+
+	lock     sync.Mutex
+	cached   map[string][]netip.Addr
+	cachedAt time.Time
+}
+
+func NewProcNetAddrs(base string) *ProcNetAddrs {
+	return &ProcNetAddrs{
+		fibTriePath: filepath.Join(base, "net", "fib_trie"),
+		routePath:   filepath.Join(base, "net", "route"),
+		inet6Path:   filepath.Join(base, "net", "if_inet6"),
+		now:         time.Now,
+	}
+}
+
+// Exists returns true if IPv4 or IPv6 addresses are available.
+func (p *ProcNetAddrs) Exists() bool {
+	_, errFibTrie := os.Stat(p.fibTriePath)
+	_, errRoute := os.Stat(p.routePath)
+	_, errInet6 := os.Stat(p.inet6Path)
+	return (errFibTrie == nil && errRoute == nil) || errInet6 == nil
+}
+
+// Addrs returns the addresses assigned to each interface, keyed by interface name.
+// Missing files are skipped, e.g. when IPv6 is disabled.
+func (p *ProcNetAddrs) Addrs() (map[string][]netip.Addr, error) {
+	out := make(map[string][]netip.Addr)
+
+	if err := p.readInetAddrs(out); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := readInet6Addrs(p.inet6Path, out); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// cachedAddrs returns the addresses assigned to each interface, only reading them
+// again once they're older than netAddrsCacheTTL. Errors aren't cached.
+func (p *ProcNetAddrs) cachedAddrs() (map[string][]netip.Addr, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.now()
+	if p.cached != nil && now.Sub(p.cachedAt) < netAddrsCacheTTL {
+		return p.cached, nil
+	}
+
+	addrs, err := p.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	p.cached = addrs
+	p.cachedAt = now
+	return addrs, nil
+}
+
+// CIDRFilter returns an InterfaceFilter that only includes interfaces with an address
+// in one of the given ranges. All interfaces are included if addresses can't be read.
+// Addresses are read once for all interfaces checked during a collection.
+func (p *ProcNetAddrs) CIDRFilter(include []netip.Prefix) InterfaceFilter {
+	return func(iface string) bool {
+		addrs, err := p.cachedAddrs()
+		if err != nil {
+			return true
+		}
+
+		for _, addr := range addrs[iface] {
+			for _, cidr := range include {
+				if cidr.Contains(addr) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// connectedRoute is a directly connected IPv4 route and the interface it's through.
+type connectedRoute struct {
+	iface  string
+	prefix netip.Prefix
+}
+
+// readInetAddrs assigns each local IPv4 address to the interface of the most specific
+// connected route that contains it.
+func (p *ProcNetAddrs) readInetAddrs(out map[string][]netip.Addr) error {
+	local, err := readFibTrieLocalAddrs(p.fibTriePath)
+	if err != nil {
+		return err
+	}
+
+	routes, err := readConnectedRoutes(p.routePath)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range local {
+		best := -1
+		for i, r := range routes {
+			if r.prefix.Contains(addr) && (best == -1 || r.prefix.Bits() > routes[best].prefix.Bits()) {
+				best = i
+			}
+		}
+
+		if best != -1 {
+			out[routes[best].iface] = append(out[routes[best].iface], addr)
+		}
+	}
+
+	return nil
+}
+
+// readFibTrieLocalAddrs returns the local IPv4 addresses of the host, the "/32 host LOCAL"
+// entries of /proc/net/fib_trie. Addresses are listed once per routing table they're in
+// but are only returned once, in the order they're first seen.
+func readFibTrieLocalAddrs(path string) ([]netip.Addr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var (
+		out  []netip.Addr
+		leaf netip.Addr
+		seen = make(map[netip.Addr]bool)
+	)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Leaves are "|-- 10.0.0.5" followed by a line for each route such as "/32 host LOCAL"
+		line := strings.TrimSpace(scanner.Text())
+		if addr, ok := strings.CutPrefix(line, "|-- "); ok {
+			leaf, err = netip.ParseAddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected fib_trie address %q from %s: %w", addr, path, err)
+			}
+			continue
+		}
+
+		if leaf.IsValid() && line == "/32 host LOCAL" && !seen[leaf] {
+			seen[leaf] = true
+			out = append(out, leaf)
+		}
+	}
+
+	return out, scanner.Err()
+}
+
+func readConnectedRoutes(path string) ([]connectedRoute, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var out []connectedRoute
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip header line
+
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 8 {
+			return nil, fmt.Errorf("expected at least 8 route fields, got %d from %s", len(parts), path)
+		}
+
+		// Only directly connected routes (no gateway) are for networks reachable
+		// through the interface itself. The default route is skipped as well.
+		if parts[2] != "00000000" || parts[7] == "00000000" {
+			continue
+		}
+
+		dest, err := parseRouteAddr(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		mask, err := strconv.ParseUint(parts[7], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, connectedRoute{iface: parts[0], prefix: netip.PrefixFrom(dest, bits.OnesCount32(uint32(mask)))})
+	}
+
+	return out, scanner.Err()
+}
+
+// parseRouteAddr parses an IPv4 address from /proc/net/route. Addresses are printed
+// as hex in host byte order, little endian on all architectures Roger runs on.
+func parseRouteAddr(s string) (netip.Addr, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	return netip.AddrFrom4(b), nil
+}
+
+func readInet6Addrs(path string, out map[string][]netip.Addr) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address ifindex prefix-length scope flags name
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 6 {
+			return fmt.Errorf("expected at least 6 if_inet6 fields, got %d from %s", len(parts), path)
+		}
+
+		raw, err := hex.DecodeString(parts[0])
+		if err != nil {
+			return err
+		}
+
+		if len(raw) != 16 {
+			return fmt.Errorf("unexpected IPv6 address %s from %s", parts[0], path)
+		}
+
+		out[parts[5]] = append(out[parts[5]], netip.AddrFrom16(*(*[16]byte)(raw)))
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,174 @@
+package roger
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const routeFixture = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0100000A	0003	0	0	100	00000000	0	0	0
+eth0	0000000A	00000000	0001	0	0	100	00FFFFFF	0	0	0
+eth1	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+wg0	0000080A	00000000	0001	0	0	0	00FFFFFF	0	0	0
+`
+
+// fibTrieFixture has eth0 with 10.0.0.5/24, eth1 with 192.168.1.10/24, wg0 with
+// 10.8.0.2/32 and a route to 10.8.0.0/24, and lo with 127.0.0.1/8.
+const fibTrieFixture = `Main:
+  +-- 0.0.0.0/0 3 0 5
+     |-- 0.0.0.0
+        /0 universe UNICAST
+     +-- 10.0.0.0/12 2 0 2
+        +-- 10.0.0.0/24 2 0 2
+           |-- 10.0.0.0
+              /24 link UNICAST
+           |-- 10.0.0.5
+              /32 host LOCAL
+        |-- 10.8.0.0
+           /24 link UNICAST
+        |-- 10.8.0.2
+           /32 host LOCAL
+     +-- 127.0.0.0/8 2 0 2
+        |-- 127.0.0.0
+           /8 host LOCAL
+        |-- 127.0.0.1
+           /32 host LOCAL
+     +-- 192.168.1.0/24 2 0 2
+        |-- 192.168.1.0
+           /24 link UNICAST
+        |-- 192.168.1.10
+           /32 host LOCAL
+        |-- 192.168.1.255
+           /32 link BROADCAST
+Local:
+  +-- 0.0.0.0/0 3 0 5
+     +-- 10.0.0.0/12 2 0 2
+        |-- 10.0.0.5
+           /32 host LOCAL
+        |-- 10.8.0.2
+           /32 host LOCAL
+     +-- 127.0.0.0/8 2 0 2
+        |-- 127.0.0.1
+           /32 host LOCAL
+     +-- 192.168.1.0/24 2 0 2
+        |-- 192.168.1.10
+           /32 host LOCAL
+`
+
+const inet6Fixture = `00000000000000000000000000000001 01 80 10 80       lo
+20010db8000000000000000000000001 03 40 00 80     eth1
+fe800000000000000a0027fffe8a3c91 02 40 20 80     eth0
+`
+
+func TestProcNetAddrs_Addrs(t *testing.T) {
+	t.Run("ipv4 and ipv6", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", routeFixture)
+		writeProcFixture(t, base, "net/if_inet6", inet6Fixture)
+
+		addrs := NewProcNetAddrs(base)
+		require.True(t, addrs.Exists())
+
+		res, err := addrs.Addrs()
+		require.NoError(t, err)
+		assert.Equal(t, []netip.Addr{
+			netip.MustParseAddr("10.0.0.5"),
+			netip.MustParseAddr("fe80::a00:27ff:fe8a:3c91"),
+		}, res["eth0"])
+		assert.Equal(t, []netip.Addr{
+			netip.MustParseAddr("192.168.1.10"),
+			netip.MustParseAddr("2001:db8::1"),
+		}, res["eth1"])
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.8.0.2")}, res["wg0"])
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("::1")}, res["lo"])
+	})
+
+	t.Run("ipv6 disabled", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", routeFixture)
+
+		res, err := NewProcNetAddrs(base).Addrs()
+		require.NoError(t, err)
+		assert.Len(t, res, 3)
+	})
+
+	t.Run("most specific route", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", routeFixture+"eth2	0500000A	00000000	0005	0	0	0	FFFFFFFF	0	0	0\n")
+
+		res, err := NewProcNetAddrs(base).Addrs()
+		require.NoError(t, err)
+		assert.Empty(t, res["eth0"])
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.5")}, res["eth2"])
+	})
+}
+
+func TestProcNetAddrs_CIDRFilter(t *testing.T) {
+	t.Run("addresses available", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", routeFixture)
+		writeProcFixture(t, base, "net/if_inet6", inet6Fixture)
+
+		filter := NewProcNetAddrs(base).CIDRFilter([]netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("::1/128"),
+		})
+
+		assert.True(t, filter("eth0"))
+		assert.False(t, filter("eth1"))
+		assert.True(t, filter("wg0"))
+		assert.True(t, filter("lo"))
+		assert.False(t, filter("eth2"))
+	})
+
+	t.Run("narrower than network", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", routeFixture)
+
+		filter := NewProcNetAddrs(base).CIDRFilter([]netip.Prefix{
+			netip.MustParsePrefix("10.0.0.5/32"),
+			netip.MustParsePrefix("10.8.0.0/30"),
+		})
+		assert.True(t, filter("eth0"))
+		assert.True(t, filter("wg0"))
+		assert.False(t, filter("eth1"))
+	})
+
+	t.Run("addresses unavailable", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", "Iface\nbad\n")
+
+		filter := NewProcNetAddrs(base).CIDRFilter([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+		assert.True(t, filter("eth0"))
+	})
+
+	t.Run("addresses cached", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/fib_trie", fibTrieFixture)
+		writeProcFixture(t, base, "net/route", routeFixture)
+
+		now := time.Unix(1600000000, 0)
+		addrs := NewProcNetAddrs(base)
+		addrs.now = func() time.Time { return now }
+		filter := addrs.CIDRFilter([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+		assert.True(t, filter("eth0"))
+
+		require.NoError(t, os.Remove(filepath.Join(base, "net", "fib_trie")))
+		assert.True(t, filter("eth0"))
+
+		now = now.Add(netAddrsCacheTTL)
+		assert.False(t, filter("eth0"))
+	})
+}
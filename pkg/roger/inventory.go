@@ -0,0 +1,150 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// static info metrics for what Roger is configured to collect
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InventoryCollector emits a metric for each configured DNS server, each enabled
+// collector, and each proc file that was checked for, regardless of whether collecting
+// from them succeeds. This allows checking that configuration has been rolled out
+// across many hosts and which collectors are active on a given host.
+type InventoryCollector struct {
+	servers    *prometheus.Desc
+	collectors *prometheus.Desc
+	procFiles  *prometheus.Desc
+
+	lock          sync.Mutex
+	serverNames   []string
+	collectorSeen map[string]bool
+	collectorList []string
+	procFileState map[string]bool
+	procFileList  []string
+}
+
+func NewInventoryCollector() *InventoryCollector {
+	return &InventoryCollector{
+		servers: prometheus.NewDesc(
+			"roger_dns_configured_server",
+			"DNS server Roger is configured to export metrics for, always 1",
+			[]string{"server"},
+			nil,
+		),
+		collectors: prometheus.NewDesc(
+			"roger_collector_enabled",
+			"Collector enabled when Roger was started, always 1",
+			[]string{"collector"},
+			nil,
+		),
+		procFiles: prometheus.NewDesc(
+			"roger_proc_file_available",
+			"Whether a file under /proc that Roger can read exists, 1 if so, 0 otherwise",
+			[]string{"file"},
+			nil,
+		),
+		collectorSeen: make(map[string]bool),
+		procFileState: make(map[string]bool),
+	}
+}
+
+// AddServer adds a configured DNS server.
+func (i *InventoryCollector) AddServer(server string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.serverNames = append(i.serverNames, server)
+}
+
+// AddCollector adds an enabled collector. Collectors registered more than once, such
+// as one per group of DNS servers, are only emitted once.
+func (i *InventoryCollector) AddCollector(name string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if !i.collectorSeen[name] {
+		i.collectorSeen[name] = true
+		i.collectorList = append(i.collectorList, name)
+	}
+}
+
+// SetProcFile sets whether a file under /proc, relative to the root of the proc file
+// system (e.g. "net/dev"), exists. Files may be set again if they appear or disappear.
+func (i *InventoryCollector) SetProcFile(file string, available bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, ok := i.procFileState[file]; !ok {
+		i.procFileList = append(i.procFileList, file)
+	}
+
+	i.procFileState[file] = available
+}
+
+// CheckProcFile sets whether a file under the proc file system at base exists, given
+// by its path relative to base (e.g. "net/dev"), and returns whether it does.
+func (i *InventoryCollector) CheckProcFile(base string, file string) bool {
+	_, err := os.Stat(filepath.Join(base, filepath.FromSlash(file)))
+	available := !os.IsNotExist(err)
+	i.SetProcFile(file, available)
+	return available
+}
+
+// ProcFiles returns the files under /proc that exist and those that don't, in the
+// order they were first set.
+func (i *InventoryCollector) ProcFiles() (available []string, missing []string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, file := range i.procFileList {
+		if i.procFileState[file] {
+			available = append(available, file)
+		} else {
+			missing = append(missing, file)
+		}
+	}
+
+	return available, missing
+}
+
+func (i *InventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- i.servers
+	ch <- i.collectors
+	ch <- i.procFiles
+}
+
+func (i *InventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, server := range i.serverNames {
+		ch <- prometheus.MustNewConstMetric(i.servers, prometheus.GaugeValue, 1, server)
+	}
+
+	for _, name := range i.collectorList {
+		ch <- prometheus.MustNewConstMetric(i.collectors, prometheus.GaugeValue, 1, name)
+	}
+
+	for _, file := range i.procFileList {
+		val := 0.0
+		if i.procFileState[file] {
+			val = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(i.procFiles, prometheus.GaugeValue, val, file)
+	}
+}
@@ -0,0 +1,64 @@
+package roger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInventoryCollector_Collect(t *testing.T) {
+	inventory := NewInventoryCollector()
+	inventory.AddServer("127.0.0.1:53")
+	inventory.AddServer("[::1]:53")
+	inventory.AddCollector("dnsmasq")
+	inventory.AddCollector("netdev")
+	inventory.AddCollector("dnsmasq")
+
+	expected := `
+# HELP roger_collector_enabled Collector enabled when Roger was started, always 1
+# TYPE roger_collector_enabled gauge
+roger_collector_enabled{collector="dnsmasq"} 1
+roger_collector_enabled{collector="netdev"} 1
+# HELP roger_dns_configured_server DNS server Roger is configured to export metrics for, always 1
+# TYPE roger_dns_configured_server gauge
+roger_dns_configured_server{server="127.0.0.1:53"} 1
+roger_dns_configured_server{server="[::1]:53"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(inventory, strings.NewReader(expected)))
+}
+
+func TestInventoryCollector_ProcFiles(t *testing.T) {
+	inventory := NewInventoryCollector()
+	inventory.SetProcFile("net/dev", true)
+	inventory.SetProcFile("net/stat/rt_cache", false)
+	inventory.SetProcFile("net/stat/nf_conntrack", false)
+	inventory.SetProcFile("net/stat/nf_conntrack", true)
+
+	available, missing := inventory.ProcFiles()
+	assert.Equal(t, []string{"net/dev", "net/stat/nf_conntrack"}, available)
+	assert.Equal(t, []string{"net/stat/rt_cache"}, missing)
+
+	expected := `
+# HELP roger_proc_file_available Whether a file under /proc that Roger can read exists, 1 if so, 0 otherwise
+# TYPE roger_proc_file_available gauge
+roger_proc_file_available{file="net/dev"} 1
+roger_proc_file_available{file="net/stat/nf_conntrack"} 1
+roger_proc_file_available{file="net/stat/rt_cache"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(inventory, strings.NewReader(expected), "roger_proc_file_available"))
+}
+
+func TestInventoryCollector_CheckProcFile(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "loadavg", "0.10 0.20 0.30 1/100 1000\n")
+
+	inventory := NewInventoryCollector()
+	assert.True(t, inventory.CheckProcFile(base, "loadavg"))
+	assert.False(t, inventory.CheckProcFile(base, "meminfo"))
+
+	available, missing := inventory.ProcFiles()
+	assert.Equal(t, []string{"loadavg"}, available)
+	assert.Equal(t, []string{"meminfo"}, missing)
+}
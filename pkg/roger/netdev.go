@@ -14,36 +14,254 @@ package roger
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Columns of /proc/net/dev used when the header line can't be parsed.
+var (
+	netDevRxHeaders = []string{"bytes", "packets", "errs", "drop", "fifo", "frame", "compressed", "multicast"}
+	netDevTxHeaders = []string{"bytes", "packets", "errs", "drop", "fifo", "colls", "carrier", "compressed"}
+)
+
+// InterfaceFilter returns true if metrics for the named interface should be emitted.
+type InterfaceFilter func(iface string) bool
+
+// AllFilters returns an InterfaceFilter that only includes interfaces included by
+// every one of the given filters.
+func AllFilters(filters ...InterfaceFilter) InterfaceFilter {
+	return func(iface string) bool {
+		for _, f := range filters {
+			if !f(iface) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// NormalizeInterfaceName strips everything from "@" onward in an interface name,
+// e.g. "veth1a2b@if12" becomes "veth1a2b", so that names from different sources
+// refer to the same interface.
+func NormalizeInterfaceName(iface string) string {
+	if i := strings.IndexByte(iface, '@'); i > 0 {
+		return iface[:i]
+	}
+
+	return iface
+}
+
+// BondLister returns the member interfaces of each bond interface, keyed by bond name.
+type BondLister func() (map[string][]string, error)
+
+// Styles of subsystem names for net/dev metrics.
+const (
+	// NetDevStyleRxTx names metrics like roger_net_rx_bytes and roger_net_tx_bytes.
+	NetDevStyleRxTx = "rxtx"
+
+	// NetDevStyleReceiveTransmit names metrics like roger_net_receive_bytes and
+	// roger_net_transmit_bytes, the wording used by node_exporter.
+	NetDevStyleReceiveTransmit = "receive-transmit"
+)
+
+// netDevSubsystems are the subsystems of received and transmitted metrics.
+type netDevSubsystems struct {
+	rx string
+	tx string
+}
+
+var netDevStyles = map[string]netDevSubsystems{
+	NetDevStyleRxTx:            {rx: "net_rx", tx: "net_tx"},
+	NetDevStyleReceiveTransmit: {rx: "net_receive", tx: "net_transmit"},
+}
+
+// netDevRxHelp and netDevTxHelp are the help text of net/dev columns whose meaning
+// isn't obvious from their name. Other columns use generic help text.
+var (
+	netDevRxHelp = map[string]string{
+		"frame": "Number of packets received with framing errors, such as misaligned or truncated frames",
+	}
+	netDevTxHelp = map[string]string{
+		"colls":   "Number of collisions detected while transmitting, only counted by half-duplex links",
+		"carrier": "Number of packets not transmitted due to carrier errors, such as link loss or a duplex mismatch",
+	}
+)
+
+// netDevLinkFaults are the net/dev columns emitted as kinds of link faults, see
+// ProcNetDevReader.LinkFaults.
+var netDevLinkFaults = []struct {
+	kind   string
+	tx     bool
+	column string
+}{
+	{kind: "carrier", tx: true, column: "carrier"},
+	{kind: "collisions", tx: true, column: "colls"},
+	{kind: "frame", tx: false, column: "frame"},
+}
+
+// netDevWrapThreshold is the largest increase of a 32 bit counter between collections
+// that is assumed to be a wrap rather than a reset when the counter decreases. A counter
+// that decreased by more than this, e.g. because the interface was recreated, was most
+// likely reset instead.
+const netDevWrapThreshold = 1 << 31
+
+// bondAggregateSuffix is appended to the name of a bond for the synthetic interface
+// that sums the counters of its members. The bond itself may also appear in net/dev
+// so its name can't be used as-is without creating duplicate series.
+const bondAggregateSuffix = ":aggregate"
+
 type ProcNetDevReader struct {
+	// Filter, if set, selects which interfaces metrics are emitted for. Metrics
+	// for all interfaces are emitted when nil.
+	Filter InterfaceFilter
+
+	// Bonds, if set, is used to find the members of bond interfaces on each
+	// collection. Metrics summing the counters of the members of each bond are
+	// emitted for a synthetic interface named "<bond>:aggregate" in addition to
+	// the metrics for each member.
+	Bonds BondLister
+
+	// NormalizeNames strips "@" suffixes from interface names, see NormalizeInterfaceName.
+	NormalizeNames bool
+
+	// SubsystemStyle selects how metrics for received and transmitted traffic are
+	// named, either NetDevStyleRxTx (the default when empty) or NetDevStyleReceiveTransmit.
+	SubsystemStyle string
+
+	// TotalsExclude, if set, excludes matching interfaces from the totals across all
+	// interfaces. This can be used to avoid counting traffic twice for interfaces
+	// such as bonds or bridges that carry the traffic of other interfaces.
+	TotalsExclude *regexp.Regexp
+
+	// LinkFaults emits carrier errors, collisions, and framing errors together as
+	// roger_net_link_faults_total{interface,kind} in addition to their own metrics,
+	// so that any kind of fault on a link can be alerted on with a single expression.
+	LinkFaults bool
+
+	// Aliases, if set, adds an "alias" label alongside the "interface" label of
+	// per-interface metrics. Interfaces without an alias have an empty alias label.
+	// Must be set before the reader is registered.
+	Aliases *InterfaceAliases
+
+	// Detect32BitWrap corrects counters of NICs that only count to 2^32 before wrapping
+	// back to zero so that they keep increasing. A counter that decreases while below 2^32
+	// is assumed to have wrapped if it would have increased by less than 2^31 since the
+	// previous collection, in which case 2^32 is added to it from then on.
+	Detect32BitWrap bool
+
+	// Timeout, if positive, is the most time spent reading net/dev on each collection.
+	// Collection fails instead of emitting some of the interfaces if the file can't be
+	// read in time.
+	Timeout time.Duration
+
 	path         string
-	lock         sync.Mutex
-	descriptions map[string]*prometheus.Desc
+	descriptions *descriptionCache
+	plain        *netDevInterfaceDescs
+	aliased      *netDevInterfaceDescs
+	totals       map[string]*prometheus.Desc
+	cached       *prometheus.Desc
+	mtime        *prometheus.Desc
+	created      *createdTimes
 	logger       log.Logger
+	errLog       *errorLogLimiter
+
+	// Value of each counter of each interface as of the previous collection and the
+	// amount added to it to correct for wraps, guarded by lock.
+	lock  sync.Mutex
+	wraps map[string]map[string]netDevWrap
+}
+
+// netDevWrap is the state of a single counter of an interface, see Detect32BitWrap.
+type netDevWrap struct {
+	last   uint64
+	offset uint64
+}
+
+// netDevInterfaceDescs are the descriptions of per-interface metrics that aren't
+// columns of net/dev, with or without the alias label.
+type netDevInterfaceDescs struct {
+	labels        []string
+	avgPacketSize map[string]*prometheus.Desc
+	linkFaults    *prometheus.Desc
+}
+
+func newNetDevInterfaceDescs(labels []string) *netDevInterfaceDescs {
+	avgPacketSize := make(map[string]*prometheus.Desc)
+
+	// Descriptions are created for every style so that the style can be changed
+	// after the reader is created.
+	for _, sub := range netDevStyles {
+		for _, dir := range []struct{ subsystem, verb string }{{sub.rx, "received"}, {sub.tx, "transmitted"}} {
+			avgPacketSize[dir.subsystem] = prometheus.NewDesc(
+				prometheus.BuildFQName("roger", dir.subsystem, "avg_packet_size_bytes"),
+				fmt.Sprintf("Average size of %s packets in bytes", dir.verb),
+				labels,
+				nil,
+			)
+		}
+	}
+
+	return &netDevInterfaceDescs{
+		labels:        labels,
+		avgPacketSize: avgPacketSize,
+		linkFaults: prometheus.NewDesc(
+			"roger_net_link_faults_total",
+			"Number of link faults (carrier errors, collisions, and framing errors) by kind",
+			append(append([]string{}, labels...), "kind"),
+			nil,
+		),
+	}
 }
 
 type NetInterfaceResults struct {
-	InterfaceName string
-	MetricValues  map[string]uint64
+	InterfaceName string            `json:"interface"`
+	MetricValues  map[string]uint64 `json:"values"`
 }
 
 func NewProcNetDevReader(base string, logger log.Logger) *ProcNetDevReader {
+	totals := make(map[string]*prometheus.Desc)
+
+	// Descriptions are created for every style so that the style can be changed
+	// after the reader is created.
+	for _, sub := range netDevStyles {
+		for _, dir := range []struct{ subsystem, verb string }{{sub.rx, "received"}, {sub.tx, "transmitted"}} {
+			for _, unit := range []struct{ name, noun string }{{"bytes", "Bytes"}, {"packets", "Packets"}} {
+				totals[prometheus.BuildFQName("roger", dir.subsystem, unit.name)] = prometheus.NewDesc(
+					prometheus.BuildFQName("roger", dir.subsystem, unit.name+"_all"),
+					fmt.Sprintf("%s %s by all interfaces", unit.noun, dir.verb),
+					nil,
+					nil,
+				)
+			}
+		}
+	}
+
 	return &ProcNetDevReader{
 		path:         filepath.Join(base, "net", "dev"),
-		lock:         sync.Mutex{},
-		descriptions: make(map[string]*prometheus.Desc),
+		descriptions: newDescriptionCache(),
+		plain:        newNetDevInterfaceDescs([]string{"interface"}),
+		aliased:      newNetDevInterfaceDescs([]string{"interface", "alias"}),
+		totals:       totals,
+		cached:       newDescriptionCountDesc(),
+		mtime:        newProcFileMtimeDesc(),
+		created:      newCreatedTimes(),
 		logger:       logger,
+		errLog:       newErrorLogLimiter(procErrorLogInterval),
+		wraps:        make(map[string]map[string]netDevWrap),
 	}
 }
 
@@ -54,30 +272,292 @@ func (p *ProcNetDevReader) Describe(_ chan<- *prometheus.Desc) {
 }
 
 func (p *ProcNetDevReader) Collect(ch chan<- prometheus.Metric) {
-	res, err := p.ReadMetrics()
-	if err != nil {
-		level.Error(p.logger).Log("msg", "failed to read net/dev metrics during collection", "path", p.path, "err", err)
+	err := p.CollectWithError(ch)
+	if err == nil {
+		if p.errLog.recovered() {
+			level.Info(p.logger).Log("msg", "net/dev metrics collected successfully after failures", "path", p.path)
+		}
+
 		return
 	}
 
-	// Locking since we're modifying our cache of metric descriptions as we emit
-	// values for them (and collectors must be safe to be called concurrently)
+	if ok, suppressed := p.errLog.failed(); ok {
+		level.Error(p.logger).Log("msg", "failed to read net/dev metrics during collection", "path", p.path, "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits metrics for each interface, returning an error if
+// the net/dev file could not be read.
+func (p *ProcNetDevReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	ctx, cancel := collectContext(p.Timeout)
+	defer cancel()
+
+	res, modified, err := p.readMetrics(ctx)
+	if isProcGone(err) {
+		level.Debug(p.logger).Log("msg", "net/dev file went away during collection", "path", p.path, "err", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if p.Detect32BitWrap {
+		p.correctWraps(res)
+	}
+
+	for _, metrics := range res {
+		if p.Filter != nil && !p.Filter(metrics.InterfaceName) {
+			continue
+		}
+
+		p.collectInterface(ch, metrics)
+	}
+
+	if p.Bonds != nil {
+		p.collectBonds(ch, res)
+	}
+
+	p.collectTotals(ch, res)
+
+	ch <- prometheus.MustNewConstMetric(p.cached, prometheus.GaugeValue, float64(p.descriptions.len()), "netdev")
+	ch <- prometheus.MustNewConstMetric(p.mtime, prometheus.GaugeValue, float64(modified.UnixNano())/1e9, "net/dev")
+
+	p.created.sweep()
+	return nil
+}
+
+func (p *ProcNetDevReader) collectInterface(ch chan<- prometheus.Metric, metrics NetInterfaceResults) {
+	// Emit metrics sorted by name so that output is stable between collections
+	names := make([]string, 0, len(metrics.MetricValues))
+	for k := range metrics.MetricValues {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	sub := p.subsystems()
+	help := p.help(sub)
+	descs, labels := p.interfaceLabels(metrics.InterfaceName)
+
+	for _, k := range names {
+		text, ok := help[k]
+		if !ok {
+			text = fmt.Sprintf("generated from %s", p.path)
+		}
+
+		desc := p.descriptions.get(k, text, descs.labels)
+		ch <- p.created.counter(desc, float64(metrics.MetricValues[k]), labels...)
+	}
+
+	p.collectAvgPacketSize(ch, metrics, descs.avgPacketSize[sub.rx], sub.rx, labels)
+	p.collectAvgPacketSize(ch, metrics, descs.avgPacketSize[sub.tx], sub.tx, labels)
+
+	if p.LinkFaults {
+		for _, f := range linkFaults(metrics, sub) {
+			ch <- p.created.counter(descs.linkFaults, float64(f.value), append(labels, f.kind)...)
+		}
+	}
+}
+
+// correctWraps adds 2^32 for each time a counter is assumed to have wrapped, see
+// Detect32BitWrap, to the values of each interface in place. The offset is dropped when a
+// counter is reset instead. Interfaces that are no longer present are forgotten so that
+// short-lived interfaces (such as container veth pairs) don't accumulate.
+func (p *ProcNetDevReader) correctWraps(res []NetInterfaceResults) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	seen := make(map[string]struct{}, len(res))
 	for _, metrics := range res {
-		for k, v := range metrics.MetricValues {
-			desc, ok := p.descriptions[k]
-			if !ok {
-				desc = prometheus.NewDesc(k, fmt.Sprintf("generated from %s", p.path), []string{"interface"}, nil)
-				p.descriptions[k] = desc
+		iface := metrics.InterfaceName
+		seen[iface] = struct{}{}
+
+		wraps, ok := p.wraps[iface]
+		if !ok {
+			wraps = make(map[string]netDevWrap, len(metrics.MetricValues))
+			p.wraps[iface] = wraps
+		}
+
+		for name, val := range metrics.MetricValues {
+			w, ok := wraps[name]
+			if ok && val < w.last {
+				if w.last <= math.MaxUint32 && val+(math.MaxUint32+1)-w.last <= netDevWrapThreshold {
+					w.offset += math.MaxUint32 + 1
+					level.Debug(p.logger).Log("msg", "net/dev counter wrapped", "interface", iface, "name", name, "previous", w.last, "value", val)
+				} else {
+					w.offset = 0
+				}
 			}
 
-			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), metrics.InterfaceName)
+			w.last = val
+			wraps[name] = w
+			metrics.MetricValues[name] = val + w.offset
+		}
+	}
+
+	for iface := range p.wraps {
+		if _, ok := seen[iface]; !ok {
+			delete(p.wraps, iface)
 		}
 	}
 }
 
+// interfaceLabels returns the descriptions of per-interface metrics and the values of
+// their labels for the interface, including its alias if Aliases is set.
+func (p *ProcNetDevReader) interfaceLabels(iface string) (*netDevInterfaceDescs, []string) {
+	if p.Aliases == nil {
+		return p.plain, []string{iface}
+	}
+
+	return p.aliased, []string{iface, p.Aliases.Alias(iface)}
+}
+
+// help returns the help text of metrics for columns with specific help text, keyed
+// by metric name.
+func (p *ProcNetDevReader) help(sub netDevSubsystems) map[string]string {
+	out := make(map[string]string, len(netDevRxHelp)+len(netDevTxHelp))
+	for column, text := range netDevRxHelp {
+		out[prometheus.BuildFQName("roger", sub.rx, column)] = text
+	}
+	for column, text := range netDevTxHelp {
+		out[prometheus.BuildFQName("roger", sub.tx, column)] = text
+	}
+
+	return out
+}
+
+type linkFault struct {
+	kind  string
+	value uint64
+}
+
+// linkFaults returns the value of each kind of link fault that the interface has a
+// column for in net/dev, in the order of netDevLinkFaults.
+func linkFaults(metrics NetInterfaceResults, sub netDevSubsystems) []linkFault {
+	var out []linkFault
+	for _, f := range netDevLinkFaults {
+		subsystem := sub.rx
+		if f.tx {
+			subsystem = sub.tx
+		}
+
+		if val, ok := metrics.MetricValues[prometheus.BuildFQName("roger", subsystem, f.column)]; ok {
+			out = append(out, linkFault{kind: f.kind, value: val})
+		}
+	}
+
+	return out
+}
+
+// collectTotals emits the sum of bytes and packets of each interface that metrics are
+// emitted for, except those matching TotalsExclude. Synthetic bond aggregates are never
+// included since their members are already counted.
+func (p *ProcNetDevReader) collectTotals(ch chan<- prometheus.Metric, res []NetInterfaceResults) {
+	sub := p.subsystems()
+	names := []string{
+		prometheus.BuildFQName("roger", sub.rx, "bytes"),
+		prometheus.BuildFQName("roger", sub.rx, "packets"),
+		prometheus.BuildFQName("roger", sub.tx, "bytes"),
+		prometheus.BuildFQName("roger", sub.tx, "packets"),
+	}
+
+	sums := make(map[string]uint64, len(names))
+	for _, metrics := range res {
+		if p.Filter != nil && !p.Filter(metrics.InterfaceName) {
+			continue
+		}
+
+		if p.TotalsExclude != nil && p.TotalsExclude.MatchString(metrics.InterfaceName) {
+			continue
+		}
+
+		for _, name := range names {
+			sums[name] += metrics.MetricValues[name]
+		}
+	}
+
+	for _, name := range names {
+		ch <- p.created.counter(p.totals[name], float64(sums[name]))
+	}
+}
+
+// collectBonds emits metrics for a synthetic interface per bond that sums the counters
+// of its members. Failing to list bonds only skips the aggregates, not the rest of the
+// net/dev metrics.
+func (p *ProcNetDevReader) collectBonds(ch chan<- prometheus.Metric, res []NetInterfaceResults) {
+	bonds, err := p.Bonds()
+	if err != nil {
+		level.Warn(p.logger).Log("msg", "failed to read bond members", "err", err)
+		return
+	}
+
+	byName := make(map[string]NetInterfaceResults, len(res))
+	for _, metrics := range res {
+		byName[metrics.InterfaceName] = metrics
+	}
+
+	names := make([]string, 0, len(bonds))
+	for bond := range bonds {
+		names = append(names, bond)
+	}
+	sort.Strings(names)
+
+	for _, bond := range names {
+		if p.Filter != nil && !p.Filter(bond) {
+			continue
+		}
+
+		aggregate, found := aggregateInterfaces(bond+bondAggregateSuffix, bonds[bond], byName)
+		if !found {
+			level.Debug(p.logger).Log("msg", "no members of bond found in net/dev", "bond", bond)
+			continue
+		}
+
+		p.collectInterface(ch, aggregate)
+	}
+}
+
+// aggregateInterfaces sums the counters of each of the members that exist in byName,
+// returning false if none of them do.
+func aggregateInterfaces(name string, members []string, byName map[string]NetInterfaceResults) (NetInterfaceResults, bool) {
+	out := NetInterfaceResults{InterfaceName: name, MetricValues: make(map[string]uint64)}
+	found := false
+
+	for _, member := range members {
+		metrics, ok := byName[member]
+		if !ok {
+			continue
+		}
+
+		found = true
+		for k, v := range metrics.MetricValues {
+			out.MetricValues[k] += v
+		}
+	}
+
+	return out, found
+}
+
+// collectAvgPacketSize emits the average packet size derived from the byte and packet
+// counters of a subsystem (rx or tx). Nothing is emitted for interfaces without packets.
+func (p *ProcNetDevReader) collectAvgPacketSize(ch chan<- prometheus.Metric, metrics NetInterfaceResults, desc *prometheus.Desc, subsystem string, labels []string) {
+	bytes, okBytes := metrics.MetricValues[prometheus.BuildFQName("roger", subsystem, "bytes")]
+	packets, okPackets := metrics.MetricValues[prometheus.BuildFQName("roger", subsystem, "packets")]
+	if !okBytes || !okPackets || packets == 0 {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(bytes)/float64(packets), labels...)
+}
+
+// subsystems returns the subsystems of received and transmitted metrics for the style
+// in use, defaulting to NetDevStyleRxTx.
+func (p *ProcNetDevReader) subsystems() netDevSubsystems {
+	if sub, ok := netDevStyles[p.SubsystemStyle]; ok {
+		return sub
+	}
+
+	return netDevStyles[NetDevStyleRxTx]
+}
+
 func (p *ProcNetDevReader) Exists() bool {
 	if _, err := os.Stat(p.path); os.IsNotExist(err) {
 		return false
@@ -87,13 +567,31 @@ func (p *ProcNetDevReader) Exists() bool {
 }
 
 func (p *ProcNetDevReader) ReadMetrics() ([]NetInterfaceResults, error) {
+	return p.ReadMetricsContext(context.Background())
+}
+
+// ReadMetricsContext returns the values of each interface, returning an error wrapping
+// the error of the context if it's canceled or its deadline is exceeded before reading
+// all interfaces.
+func (p *ProcNetDevReader) ReadMetricsContext(ctx context.Context) ([]NetInterfaceResults, error) {
+	res, _, err := p.readMetrics(ctx)
+	return res, err
+}
+
+// readMetrics returns the values of each interface and the modification time of the file.
+func (p *ProcNetDevReader) readMetrics(ctx context.Context) ([]NetInterfaceResults, time.Time, error) {
 	f, err := os.Open(p.path)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	defer func() { _ = f.Close() }()
 
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
 	scanner := bufio.NewScanner(f)
 	scanner.Scan()
 	scanner.Scan() // skip header line
@@ -101,28 +599,44 @@ func (p *ProcNetDevReader) ReadMetrics() ([]NetInterfaceResults, error) {
 	headerLine := scanner.Text()
 	headerParts := strings.Split(headerLine, "|")
 
-	if len(headerParts) != 3 {
-		return nil, fmt.Errorf("unexpected header line format %s", headerLine)
+	var rxHeaders, txHeaders []string
+	if len(headerParts) == 3 {
+		rxHeaders = strings.Fields(headerParts[1])
+		txHeaders = strings.Fields(headerParts[2])
+	} else {
+		// Rather than failing to emit anything, assume the columns are the same as
+		// they have been for every kernel version so far if the header can't be used.
+		level.Warn(p.logger).Log("msg", "unexpected net/dev header line format, assuming standard columns", "path", p.path, "header", headerLine)
+		rxHeaders = netDevRxHeaders
+		txHeaders = netDevTxHeaders
 	}
 
-	rxHeaders := strings.Fields(headerParts[1])
-	txHeaders := strings.Fields(headerParts[2])
 	var res []NetInterfaceResults
+	sub := p.subsystems()
 
-	for {
-		if !scanner.Scan() {
-			break
+	for read := 0; scanner.Scan(); read++ {
+		if err := procScanErr(ctx, read); err != nil {
+			return nil, time.Time{}, fmt.Errorf("stopped reading %s after %d interfaces: %w", p.path, read, err)
 		}
 
 		line := scanner.Text()
 		parts := strings.Fields(line)
+		if len(parts) < len(rxHeaders)+len(txHeaders)+1 {
+			level.Warn(p.logger).Log("msg", "skipping net/dev line with too few fields", "path", p.path, "line", line)
+			continue
+		}
+
 		iface := strings.TrimRight(parts[0], ":")
+		if p.NormalizeNames {
+			iface = NormalizeInterfaceName(iface)
+		}
+
 		rxVals := parts[1 : len(rxHeaders)+1]
 		txVals := parts[len(rxHeaders)+1:]
 		metrics := make(map[string]uint64)
 
-		p.appendNetDevValues(metrics, rxHeaders, rxVals, "net_rx")
-		p.appendNetDevValues(metrics, txHeaders, txVals, "net_tx")
+		p.appendNetDevValues(metrics, rxHeaders, rxVals, sub.rx)
+		p.appendNetDevValues(metrics, txHeaders, txVals, sub.tx)
 
 		res = append(res, NetInterfaceResults{
 			InterfaceName: iface,
@@ -130,7 +644,7 @@ func (p *ProcNetDevReader) ReadMetrics() ([]NetInterfaceResults, error) {
 		})
 	}
 
-	return res, nil
+	return res, info.ModTime(), scanner.Err()
 }
 
 func (p *ProcNetDevReader) appendNetDevValues(metrics map[string]uint64, headers []string, values []string, subsystem string) {
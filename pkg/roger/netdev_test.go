@@ -1 +1,601 @@
 package roger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const netDevFixture = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 2776770   25023    0    0    0     0          0         0  2776770   25023    0    0    0     0       0          0
+  eth0: 1215645474 1060434    0    0    0     0          0      1412 96209658  512403    0    0    0     0       0          0
+`
+
+var fqNamePattern = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// metricNames returns the name of each metric emitted by the collector in the
+// order they were emitted.
+func metricNames(t *testing.T, c prometheus.Collector) []string {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var names []string
+	for m := range ch {
+		match := fqNamePattern.FindStringSubmatch(m.Desc().String())
+		require.Len(t, match, 2)
+		names = append(names, match[1])
+	}
+
+	return names
+}
+
+func writeProcFixture(t testing.TB, base string, path string, contents string) {
+	full := filepath.Join(base, path)
+	require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+	require.NoError(t, os.WriteFile(full, []byte(contents), 0o644))
+}
+
+func TestProcNetDevReader_ReadMetrics(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	assert.Equal(t, "lo", res[0].InterfaceName)
+	assert.Equal(t, uint64(2776770), res[0].MetricValues["roger_net_rx_bytes"])
+	assert.Equal(t, "eth0", res[1].InterfaceName)
+	assert.Equal(t, uint64(1215645474), res[1].MetricValues["roger_net_rx_bytes"])
+	assert.Equal(t, uint64(1412), res[1].MetricValues["roger_net_rx_multicast"])
+	assert.Equal(t, uint64(512403), res[1].MetricValues["roger_net_tx_packets"])
+}
+
+func TestProcNetDevReader_ReadMetricsContextCanceled(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	_, err := reader.ReadMetricsContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestProcNetDevReader_ReadMetricsMalformedHeader(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-  Receive                                                   Transmit
+ face  bytes    packets errs drop fifo frame compressed multicast bytes    packets errs drop fifo colls carrier compressed
+  eth0: 1215645474 1060434    0    0    0     0          0      1412 96209658  512403    0    0    0     0       0          0
+  eth1: 1 2
+`)
+
+	var buf bytes.Buffer
+	reader := NewProcNetDevReader(base, log.NewLogfmtLogger(&buf))
+	res, err := reader.ReadMetrics()
+
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "eth0", res[0].InterfaceName)
+	assert.Equal(t, uint64(1215645474), res[0].MetricValues["roger_net_rx_bytes"])
+	assert.Equal(t, uint64(1412), res[0].MetricValues["roger_net_rx_multicast"])
+	assert.Equal(t, uint64(96209658), res[0].MetricValues["roger_net_tx_bytes"])
+	assert.Len(t, res[0].MetricValues, 16)
+	assert.Contains(t, buf.String(), "unexpected net/dev header line format")
+	assert.Contains(t, buf.String(), "skipping net/dev line with too few fields")
+}
+
+func TestProcNetDevReader_ReadMetricsNormalizeNames(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+veth1a2b@if12: 2776770   25023    0    0    0     0          0         0  2776770   25023    0    0    0     0       0          0
+`)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "veth1a2b@if12", res[0].InterfaceName)
+
+	reader.NormalizeNames = true
+	res, err = reader.ReadMetrics()
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "veth1a2b", res[0].InterfaceName)
+}
+
+func TestProcNetDevReader_Collect(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	names := metricNames(t, reader)
+
+	// 16 counters and 2 average packet sizes per interface, 4 totals, the description
+	// count, and the modification time of the file
+	require.Len(t, names, 42)
+	assert.True(t, sort.StringsAreSorted(names[:16]))
+	assert.True(t, sort.StringsAreSorted(names[18:34]))
+	assert.True(t, sort.StringsAreSorted(names[36:40]))
+	assert.Equal(t, "roger_collector_descriptions", names[40])
+	assert.Equal(t, "roger_proc_file_mtime_seconds", names[41])
+}
+
+func TestProcNetDevReader_CollectMtime(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	modified := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(base, "net", "dev"), modified, modified))
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	expected := `
+# HELP roger_proc_file_mtime_seconds Modification time of the /proc file metrics were read from, in seconds since the epoch. procfs doesn't update it when contents change, it's only meaningful when reading a copy of /proc such as a snapshot
+# TYPE roger_proc_file_mtime_seconds gauge
+roger_proc_file_mtime_seconds{file="net/dev"} 1.6146e+09
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_proc_file_mtime_seconds"))
+}
+
+func TestProcNetDevReader_CollectDescriptionCount(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+
+	expected := `
+# HELP roger_collector_descriptions Number of metric descriptions cached by the collector. Steady growth indicates label churn
+# TYPE roger_collector_descriptions gauge
+roger_collector_descriptions{collector="netdev"} 16
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_collector_descriptions"))
+}
+
+func TestProcNetDevReader_CollectFileRemoved(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	var buf bytes.Buffer
+	logger := level.NewFilter(log.NewLogfmtLogger(&buf), level.AllowInfo())
+	reader := NewProcNetDevReader(base, logger)
+	require.True(t, reader.Exists())
+	require.NoError(t, os.Remove(filepath.Join(base, "net", "dev")))
+
+	assert.Empty(t, metricNames(t, reader))
+	assert.Empty(t, buf.String())
+}
+
+func TestProcNetDevReader_CollectFilter(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	reader.Filter = func(iface string) bool { return iface == "eth0" }
+
+	expected := fmt.Sprintf(`
+# HELP roger_net_rx_bytes generated from %s
+# TYPE roger_net_rx_bytes counter
+roger_net_rx_bytes{interface="eth0"} 1215645474
+`, filepath.Join(base, "net", "dev"))
+
+	assert.Equal(t, 24, testutil.CollectAndCount(reader))
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_net_rx_bytes"))
+}
+
+func TestProcNetDevReader_CollectAvgPacketSize(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0:   15000      10    0    0    0     0          0         0     6000     100    0    0    0     0       0          0
+  eth1:       0       0    0    0    0     0          0         0        0       0    0    0    0     0       0          0
+`)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+
+	expected := `
+# HELP roger_net_rx_avg_packet_size_bytes Average size of received packets in bytes
+# TYPE roger_net_rx_avg_packet_size_bytes gauge
+roger_net_rx_avg_packet_size_bytes{interface="eth0"} 1500
+# HELP roger_net_tx_avg_packet_size_bytes Average size of transmitted packets in bytes
+# TYPE roger_net_tx_avg_packet_size_bytes gauge
+roger_net_tx_avg_packet_size_bytes{interface="eth0"} 60
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_net_rx_avg_packet_size_bytes", "roger_net_tx_avg_packet_size_bytes"))
+}
+
+func TestProcNetDevReader_CollectSubsystemStyle(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0:   15000      10    0    0    0     0          0         0     6000     100    0    0    0     0       0          0
+`)
+
+	t.Run("rxtx", func(t *testing.T) {
+		reader := NewProcNetDevReader(base, log.NewNopLogger())
+		reader.SubsystemStyle = NetDevStyleRxTx
+
+		names := metricNames(t, reader)
+		assert.Contains(t, names, "roger_net_rx_bytes")
+		assert.Contains(t, names, "roger_net_tx_packets")
+		assert.Contains(t, names, "roger_net_rx_avg_packet_size_bytes")
+		assert.Contains(t, names, "roger_net_tx_bytes_all")
+		assert.NotContains(t, names, "roger_net_receive_bytes")
+	})
+
+	t.Run("receive-transmit", func(t *testing.T) {
+		reader := NewProcNetDevReader(base, log.NewNopLogger())
+		reader.SubsystemStyle = NetDevStyleReceiveTransmit
+
+		expected := `
+# HELP roger_net_receive_bytes_all Bytes received by all interfaces
+# TYPE roger_net_receive_bytes_all counter
+roger_net_receive_bytes_all 15000
+# HELP roger_net_transmit_avg_packet_size_bytes Average size of transmitted packets in bytes
+# TYPE roger_net_transmit_avg_packet_size_bytes gauge
+roger_net_transmit_avg_packet_size_bytes{interface="eth0"} 60
+# HELP roger_net_transmit_packets generated from %s
+# TYPE roger_net_transmit_packets counter
+roger_net_transmit_packets{interface="eth0"} 100
+`
+		expected = fmt.Sprintf(expected, filepath.Join(base, "net", "dev"))
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+			"roger_net_receive_bytes_all", "roger_net_transmit_avg_packet_size_bytes", "roger_net_transmit_packets"))
+
+		names := metricNames(t, reader)
+		assert.NotContains(t, names, "roger_net_rx_bytes")
+		assert.NotContains(t, names, "roger_net_rx_bytes_all")
+	})
+}
+
+func TestProcNetDevReader_CollectTotals(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0:   15000      10    0    0    0     0          0         0     6000     100    0    0    0     0       0          0
+  eth1:    5000      10    0    0    0     0          0         0     4000     100    0    0    0     0       0          0
+ bond0:   20000      20    0    0    0     0          0         0    10000     200    0    0    0     0       0          0
+    lo:     100       1    0    0    0     0          0         0      100       1    0    0    0     0       0          0
+`)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	reader.Filter = func(iface string) bool { return iface != "lo" }
+	reader.TotalsExclude = regexp.MustCompile("bond.*")
+	reader.Bonds = func() (map[string][]string, error) {
+		return map[string][]string{"bond0": {"eth0", "eth1"}}, nil
+	}
+
+	expected := `
+# HELP roger_net_rx_bytes_all Bytes received by all interfaces
+# TYPE roger_net_rx_bytes_all counter
+roger_net_rx_bytes_all 20000
+# HELP roger_net_rx_packets_all Packets received by all interfaces
+# TYPE roger_net_rx_packets_all counter
+roger_net_rx_packets_all 20
+# HELP roger_net_tx_bytes_all Bytes transmitted by all interfaces
+# TYPE roger_net_tx_bytes_all counter
+roger_net_tx_bytes_all 10000
+# HELP roger_net_tx_packets_all Packets transmitted by all interfaces
+# TYPE roger_net_tx_packets_all counter
+roger_net_tx_packets_all 200
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_net_rx_bytes_all", "roger_net_rx_packets_all", "roger_net_tx_bytes_all", "roger_net_tx_packets_all"))
+
+	// Per-interface series are still emitted for excluded interfaces
+	assert.Contains(t, metricNames(t, reader), "roger_net_rx_bytes")
+}
+
+func TestProcNetDevReader_CollectBonds(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0:   15000      10    0    0    0     0          0         0     6000     100    0    0    0     0       0          0
+  eth1:    5000      10    0    0    0     0          0         0     4000     100    0    0    0     0       0          0
+ bond0:   20000      20    0    0    0     0          0         0    10000     200    0    0    0     0       0          0
+`)
+
+	t.Run("aggregate", func(t *testing.T) {
+		reader := NewProcNetDevReader(base, log.NewNopLogger())
+		reader.Bonds = func() (map[string][]string, error) {
+			return map[string][]string{"bond0": {"eth0", "eth1", "eth2"}}, nil
+		}
+
+		expected := fmt.Sprintf(`
+# HELP roger_net_rx_bytes generated from %s
+# TYPE roger_net_rx_bytes counter
+roger_net_rx_bytes{interface="bond0"} 20000
+roger_net_rx_bytes{interface="bond0:aggregate"} 20000
+roger_net_rx_bytes{interface="eth0"} 15000
+roger_net_rx_bytes{interface="eth1"} 5000
+# HELP roger_net_tx_avg_packet_size_bytes Average size of transmitted packets in bytes
+# TYPE roger_net_tx_avg_packet_size_bytes gauge
+roger_net_tx_avg_packet_size_bytes{interface="bond0"} 50
+roger_net_tx_avg_packet_size_bytes{interface="bond0:aggregate"} 50
+roger_net_tx_avg_packet_size_bytes{interface="eth0"} 60
+roger_net_tx_avg_packet_size_bytes{interface="eth1"} 40
+`, filepath.Join(base, "net", "dev"))
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+			"roger_net_rx_bytes", "roger_net_tx_avg_packet_size_bytes"))
+	})
+
+	t.Run("list failure", func(t *testing.T) {
+		reader := NewProcNetDevReader(base, log.NewNopLogger())
+		reader.Bonds = func() (map[string][]string, error) {
+			return nil, errors.New("sysfs error")
+		}
+
+		assert.Equal(t, 3*18+4+2, testutil.CollectAndCount(reader))
+	})
+}
+
+func BenchmarkProcNetDevReader_Collect(b *testing.B) {
+	base := b.TempDir()
+	path := filepath.Join(base, "net", "dev")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(netDevFixture), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	b.RunParallel(func(pb *testing.PB) {
+		ch := make(chan prometheus.Metric, 64)
+		for pb.Next() {
+			_ = reader.CollectWithError(ch)
+			for len(ch) > 0 {
+				<-ch
+			}
+		}
+	})
+}
+
+func TestProcNetDevReader_CollectLogRateLimited(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "net", "dev")
+
+	// Make the file unreadable by creating a directory in its place
+	require.NoError(t, os.MkdirAll(path, 0o755))
+
+	var buf bytes.Buffer
+	reader := NewProcNetDevReader(base, log.NewLogfmtLogger(&buf))
+
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	assert.Equal(t, 1, strings.Count(buf.String(), "failed to read net/dev metrics"))
+
+	require.NoError(t, os.Remove(path))
+	writeProcFixture(t, base, "net/dev", netDevFixture)
+
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	assert.Equal(t, 1, strings.Count(buf.String(), "collected successfully after failures"))
+}
+
+func TestAllFilters(t *testing.T) {
+	filter := AllFilters(
+		func(iface string) bool { return iface != "eth1" },
+		func(iface string) bool { return iface != "eth2" },
+	)
+
+	assert.True(t, filter("eth0"))
+	assert.False(t, filter("eth1"))
+	assert.False(t, filter("eth2"))
+	assert.True(t, AllFilters()("eth0"))
+}
+
+func TestNormalizeInterfaceName(t *testing.T) {
+	assert.Equal(t, "veth1a2b", NormalizeInterfaceName("veth1a2b@if12"))
+	assert.Equal(t, "eth0.100", NormalizeInterfaceName("eth0.100@eth0"))
+	assert.Equal(t, "eth0", NormalizeInterfaceName("eth0"))
+	assert.Equal(t, "@odd", NormalizeInterfaceName("@odd"))
+}
+
+func TestProcNetDevReader_CollectLinkFaults(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0:   15000      10    0    0    0     3          0         0     6000     100    0    0    0     2       1          0
+`)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	assert.Equal(t, 0, testutil.CollectAndCount(reader, "roger_net_link_faults_total"))
+
+	reader.LinkFaults = true
+
+	expected := `
+# HELP roger_net_link_faults_total Number of link faults (carrier errors, collisions, and framing errors) by kind
+# TYPE roger_net_link_faults_total counter
+roger_net_link_faults_total{interface="eth0",kind="carrier"} 1
+roger_net_link_faults_total{interface="eth0",kind="collisions"} 2
+roger_net_link_faults_total{interface="eth0",kind="frame"} 3
+# HELP roger_net_tx_carrier Number of packets not transmitted due to carrier errors, such as link loss or a duplex mismatch
+# TYPE roger_net_tx_carrier counter
+roger_net_tx_carrier{interface="eth0"} 1
+# HELP roger_net_tx_colls Number of collisions detected while transmitting, only counted by half-duplex links
+# TYPE roger_net_tx_colls counter
+roger_net_tx_colls{interface="eth0"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_net_link_faults_total", "roger_net_tx_carrier", "roger_net_tx_colls"))
+}
+
+func TestProcNetDevReader_CollectAliases(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/dev", `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  enp3s0:   15000      10    0    0    0     3          0         0     6000     100    0    0    0     0       0          0
+      lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+`)
+
+	aliases, err := NewInterfaceAliases(writeAliasFixture(t, "enp3s0 wan\n"))
+	require.NoError(t, err)
+
+	reader := NewProcNetDevReader(base, log.NewNopLogger())
+	reader.Aliases = aliases
+	reader.LinkFaults = true
+
+	expected := `
+# HELP roger_net_link_faults_total Number of link faults (carrier errors, collisions, and framing errors) by kind
+# TYPE roger_net_link_faults_total counter
+roger_net_link_faults_total{alias="",interface="lo",kind="carrier"} 0
+roger_net_link_faults_total{alias="",interface="lo",kind="collisions"} 0
+roger_net_link_faults_total{alias="",interface="lo",kind="frame"} 0
+roger_net_link_faults_total{alias="wan",interface="enp3s0",kind="carrier"} 0
+roger_net_link_faults_total{alias="wan",interface="enp3s0",kind="collisions"} 0
+roger_net_link_faults_total{alias="wan",interface="enp3s0",kind="frame"} 3
+# HELP roger_net_rx_avg_packet_size_bytes Average size of received packets in bytes
+# TYPE roger_net_rx_avg_packet_size_bytes gauge
+roger_net_rx_avg_packet_size_bytes{alias="",interface="lo"} 100
+roger_net_rx_avg_packet_size_bytes{alias="wan",interface="enp3s0"} 1500
+# HELP roger_net_rx_frame Number of packets received with framing errors, such as misaligned or truncated frames
+# TYPE roger_net_rx_frame counter
+roger_net_rx_frame{alias="",interface="lo"} 0
+roger_net_rx_frame{alias="wan",interface="enp3s0"} 3
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_net_link_faults_total", "roger_net_rx_avg_packet_size_bytes", "roger_net_rx_frame"))
+}
+
+func TestLinkFaults(t *testing.T) {
+	metrics := NetInterfaceResults{
+		InterfaceName: "eth0",
+		MetricValues: map[string]uint64{
+			"roger_net_receive_frame":   3,
+			"roger_net_transmit_colls":  2,
+			"roger_net_transmit_bytes":  6000,
+			"roger_net_receive_packets": 10,
+		},
+	}
+
+	assert.Equal(t, []linkFault{
+		{kind: "collisions", value: 2},
+		{kind: "frame", value: 3},
+	}, linkFaults(metrics, netDevStyles[NetDevStyleReceiveTransmit]))
+	assert.Empty(t, linkFaults(metrics, netDevStyles[NetDevStyleRxTx]))
+}
+
+// netDevRxBytesFixture returns the contents of /proc/net/dev with a single interface
+// that has received the given number of bytes.
+func netDevRxBytesFixture(rxBytes uint64) string {
+	return fmt.Sprintf(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0: %d 1060434    0    0    0     0          0      1412 96209658  512403    0    0    0     0       0          0
+`, rxBytes)
+}
+
+func TestProcNetDevReader_Detect32BitWrap(t *testing.T) {
+	rxBytes := func(t *testing.T, reader *ProcNetDevReader, base string, val uint64) float64 {
+		writeProcFixture(t, base, "net/dev", netDevRxBytesFixture(val))
+		ch := make(chan prometheus.Metric, 64)
+		require.NoError(t, reader.CollectWithError(ch))
+		close(ch)
+
+		for m := range ch {
+			if strings.Contains(m.Desc().String(), `"roger_net_rx_bytes"`) {
+				var out dto.Metric
+				require.NoError(t, m.Write(&out))
+				return out.GetCounter().GetValue()
+			}
+		}
+
+		t.Fatal("roger_net_rx_bytes not collected")
+		return 0
+	}
+
+	cases := []struct {
+		name     string
+		detect   bool
+		values   []uint64
+		expected []float64
+	}{
+		{
+			name:     "wrap",
+			detect:   true,
+			values:   []uint64{4294967000, 100, 500},
+			expected: []float64{4294967000, 4294967396, 4294967796},
+		},
+		{
+			name:     "multiple wraps",
+			detect:   true,
+			values:   []uint64{4294967000, 100, 4294967000, 200},
+			expected: []float64{4294967000, 4294967396, 8589934296, 8589934792},
+		},
+		{
+			name:     "reset",
+			detect:   true,
+			values:   []uint64{1000000, 100},
+			expected: []float64{1000000, 100},
+		},
+		{
+			name:     "reset after wrap",
+			detect:   true,
+			values:   []uint64{4294967000, 100, 200000, 50},
+			expected: []float64{4294967000, 4294967396, 4295167296, 50},
+		},
+		{
+			name:     "64 bit counter",
+			detect:   true,
+			values:   []uint64{8589934592, 100},
+			expected: []float64{8589934592, 100},
+		},
+		{
+			name:     "disabled",
+			detect:   false,
+			values:   []uint64{4294967000, 100},
+			expected: []float64{4294967000, 100},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := t.TempDir()
+			reader := NewProcNetDevReader(base, log.NewNopLogger())
+			reader.Detect32BitWrap = tc.detect
+
+			for i, val := range tc.values {
+				assert.Equal(t, tc.expected[i], rxBytes(t, reader, base, val), "collection %d", i)
+			}
+		})
+	}
+
+	t.Run("removed interfaces are forgotten", func(t *testing.T) {
+		base := t.TempDir()
+		reader := NewProcNetDevReader(base, log.NewNopLogger())
+		reader.Detect32BitWrap = true
+
+		writeProcFixture(t, base, "net/dev", netDevFixture)
+		require.NoError(t, reader.CollectWithError(make(chan prometheus.Metric, 128)))
+		assert.Contains(t, reader.wraps, "lo")
+		assert.Contains(t, reader.wraps, "eth0")
+
+		rxBytes(t, reader, base, 1215645474)
+		assert.NotContains(t, reader.wraps, "lo")
+		assert.Contains(t, reader.wraps, "eth0")
+	})
+}
@@ -12,12 +12,17 @@ package roger
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -29,34 +34,162 @@ import (
 // or not summed compared to other metrics.
 const entriesHeader = "entries"
 
+// netStatSubsystems maps /proc/net/stat files to the subsystem used for their
+// metric names when it differs from the file name. Older kernels expose conntrack
+// stats as ip_conntrack, use the same metric names as nf_conntrack so dashboards
+// work across kernel versions.
+var netStatSubsystems = map[string]string{
+	"ip_conntrack": "nf_conntrack",
+}
+
+// netStatSource reads the rows of a /proc/net/stat file, one per CPU, from somewhere
+// other than the file itself such as netlink. Values are keyed by lowercase column name.
+type netStatSource interface {
+	exists() bool
+	rows() ([]map[string]uint64, error)
+}
+
 type ProcNetStatReader struct {
+	// NormalizePerCPU divides values summed across all CPUs by the number of CPUs so
+	// that they're the average per CPU, for comparing hosts with different numbers of
+	// CPUs. Averages are emitted as gauges since they can decrease when CPUs are added.
+	// The "entries" column isn't summed so it's emitted as is.
+	NormalizePerCPU bool
+
+	// Timeout, if positive, is the most time spent reading the file on each
+	// collection. Collection fails instead of emitting values summed across some of
+	// the CPUs if the file can't be read in time. Stats read over netlink aren't
+	// limited.
+	Timeout time.Duration
+
 	subsystem    string
 	path         string
-	lock         sync.Mutex
-	descriptions map[string]*prometheus.Desc
+	file         string
+	source       netStatSource
+	fields       map[string]netStatField
+	numBase      int
+	descriptions *descriptionCache
+	columns      atomic.Pointer[netStatColumns]
+	cpus         *prometheus.Desc
+	cached       *prometheus.Desc
+	mtime        *prometheus.Desc
+	created      *createdTimes
 	logger       log.Logger
+	errLog       *errorLogLimiter
+}
+
+// netStatColumns are the columns of the header line of a /proc/net/stat file, along
+// with the name of the metric for each and the index of the "entries" column, -1 if
+// the file doesn't have one.
+type netStatColumns struct {
+	line    string
+	headers []string
+	names   []string
+	entries int
 }
 
 type NetStatResults struct {
-	Values []ValueDesc
+	Values []ValueDesc `json:"values"`
+	// CPUs is the number of per-CPU rows that values were summed from
+	CPUs int `json:"cpus"`
 }
 
 type ValueDesc struct {
 	name     string
+	help     string
 	val      uint64
 	promType prometheus.ValueType
 }
 
+// MarshalJSON encodes the value with its name, help text, and type for debugging
+// since the fields themselves are only used to build metrics.
+func (v ValueDesc) MarshalJSON() ([]byte, error) {
+	promType := "untyped"
+	switch v.promType {
+	case prometheus.CounterValue:
+		promType = "counter"
+	case prometheus.GaugeValue:
+		promType = "gauge"
+	}
+
+	return json.Marshal(struct {
+		Name  string `json:"name"`
+		Help  string `json:"help"`
+		Value uint64 `json:"value"`
+		Type  string `json:"type"`
+	}{v.name, v.help, v.val, promType})
+}
+
+// NewProcNetStatReader creates a reader for /proc/net/stat/$variant with metric names
+// using the variant as the subsystem, unless the variant is known to use a different
+// subsystem (such as ip_conntrack).
 func NewProcNetStatReader(base string, variant string, logger log.Logger) *ProcNetStatReader {
+	subsystem, ok := netStatSubsystems[variant]
+	if !ok {
+		subsystem = variant
+	}
+
+	return NewProcNetStatReaderWithSubsystem(base, variant, subsystem, logger)
+}
+
+// NewProcNetStatReaderWithSubsystem creates a reader for /proc/net/stat/$pathVariant
+// with metric names using the given subsystem, e.g. roger_$subsystem_entries.
+func NewProcNetStatReaderWithSubsystem(base string, pathVariant string, subsystem string, logger log.Logger) *ProcNetStatReader {
 	return &ProcNetStatReader{
-		subsystem:    variant,
-		path:         filepath.Join(base, "net", "stat", variant),
-		lock:         sync.Mutex{},
-		descriptions: make(map[string]*prometheus.Desc),
-		logger:       logger,
+		subsystem:    subsystem,
+		path:         filepath.Join(base, "net", "stat", pathVariant),
+		file:         "net/stat/" + pathVariant,
+		fields:       lookupNetStatFields(pathVariant),
+		numBase:      16,
+		descriptions: newDescriptionCache(),
+		cpus: prometheus.NewDesc(
+			prometheus.BuildFQName("roger", subsystem, "cpus"),
+			fmt.Sprintf("Number of CPU rows read from %s", filepath.Join(base, "net", "stat", pathVariant)),
+			nil,
+			nil,
+		),
+		cached:  newDescriptionCountDesc(),
+		mtime:   newProcFileMtimeDesc(),
+		created: newCreatedTimes(),
+		logger:  logger,
+		errLog:  newErrorLogLimiter(procErrorLogInterval),
 	}
 }
 
+// NewProcNetStatReaderFromConfig creates a reader for a /proc/net/stat file described
+// in the config file, using its subsystem, numeric base, and gauge columns in place of
+// the defaults for the file.
+func NewProcNetStatReaderFromConfig(base string, cfg NetStatFileConfig, logger log.Logger) *ProcNetStatReader {
+	reader := NewProcNetStatReaderWithSubsystem(base, cfg.File, cfg.subsystem(), logger)
+	if cfg.Base != 0 {
+		reader.numBase = cfg.Base
+	}
+
+	if len(cfg.Gauges) > 0 {
+		// Known fields are shared by every reader of the file so they're copied
+		// before being changed.
+		fields := make(map[string]netStatField, len(reader.fields)+len(cfg.Gauges))
+		for header, field := range reader.fields {
+			fields[header] = field
+		}
+
+		for _, g := range cfg.Gauges {
+			header := strings.ToLower(g)
+			field, ok := fields[header]
+			if !ok {
+				field.help = fmt.Sprintf("generated from %s", reader.path)
+			}
+
+			field.promType = prometheus.GaugeValue
+			fields[header] = field
+		}
+
+		reader.fields = fields
+	}
+
+	return reader
+}
+
 func (p *ProcNetStatReader) Describe(_ chan<- *prometheus.Desc) {
 	// Unchecked collector. We don't return descriptors for the metrics that
 	// the .Collect() method will return since they're constructed dynamically
@@ -64,27 +197,78 @@ func (p *ProcNetStatReader) Describe(_ chan<- *prometheus.Desc) {
 }
 
 func (p *ProcNetStatReader) Collect(ch chan<- prometheus.Metric) {
-	res, err := p.ReadMetrics()
-	if err != nil {
-		level.Error(p.logger).Log("msg", "failed to read net/stat metrics during collection", "path", p.path, "err", err)
+	err := p.CollectWithError(ch)
+	if err == nil {
+		if p.errLog.recovered() {
+			level.Info(p.logger).Log("msg", "net/stat metrics collected successfully after failures", "path", p.path)
+		}
+
 		return
 	}
 
-	p.lock.Lock()
-	defer p.lock.Unlock()
+	if ok, suppressed := p.errLog.failed(); ok {
+		level.Error(p.logger).Log("msg", "failed to read net/stat metrics during collection", "path", p.path, "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits metrics summed across all CPUs, returning an error
+// if the net/stat file could not be read.
+func (p *ProcNetStatReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	ctx, cancel := collectContext(p.Timeout)
+	defer cancel()
 
+	res, modified, err := p.readMetrics(ctx)
+	if isProcGone(err) {
+		level.Debug(p.logger).Log("msg", "net/stat file went away during collection", "path", p.path, "err", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries := prometheus.BuildFQName("roger", p.subsystem, entriesHeader)
 	for _, v := range res.Values {
-		desc, ok := p.descriptions[v.name]
-		if !ok {
-			desc = prometheus.NewDesc(v.name, fmt.Sprintf("generated from %s", p.path), nil, nil)
-			p.descriptions[v.name] = desc
+		desc := p.descriptions.get(v.name, v.help, nil)
+
+		if p.NormalizePerCPU && v.name != entries {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, perCPU(v.val, res.CPUs))
+		} else if v.promType == prometheus.CounterValue {
+			ch <- p.created.counter(desc, float64(v.val))
+		} else {
+			ch <- prometheus.MustNewConstMetric(desc, v.promType, float64(v.val))
 		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(p.cpus, prometheus.GaugeValue, float64(res.CPUs))
+	ch <- prometheus.MustNewConstMetric(p.cached, prometheus.GaugeValue, float64(p.descriptions.len()), p.subsystem)
 
-		ch <- prometheus.MustNewConstMetric(desc, v.promType, float64(v.val))
+	// Stats read over netlink aren't read from a file
+	if !modified.IsZero() {
+		ch <- prometheus.MustNewConstMetric(p.mtime, prometheus.GaugeValue, float64(modified.UnixNano())/1e9, p.file)
 	}
+
+	p.created.sweep()
+	return nil
+}
+
+// perCPU returns the average of a value summed across the given number of CPUs.
+func perCPU(val uint64, cpus int) float64 {
+	if cpus == 0 {
+		return float64(val)
+	}
+
+	return float64(val) / float64(cpus)
+}
+
+// Subsystem returns the subsystem used for metric names emitted by this reader.
+func (p *ProcNetStatReader) Subsystem() string {
+	return p.subsystem
 }
 
 func (p *ProcNetStatReader) Exists() bool {
+	if p.source != nil {
+		return p.source.exists()
+	}
+
 	if _, err := os.Stat(p.path); os.IsNotExist(err) {
 		return false
 	}
@@ -93,65 +277,189 @@ func (p *ProcNetStatReader) Exists() bool {
 }
 
 func (p *ProcNetStatReader) ReadMetrics() (*NetStatResults, error) {
+	return p.ReadMetricsContext(context.Background())
+}
+
+// ReadMetricsContext returns the values summed across all CPUs, returning an error
+// wrapping the error of the context if it's canceled or its deadline is exceeded before
+// reading the rows of all CPUs.
+func (p *ProcNetStatReader) ReadMetricsContext(ctx context.Context) (*NetStatResults, error) {
+	res, _, err := p.readMetrics(ctx)
+	return res, err
+}
+
+// readMetrics returns the values summed across all CPUs and the modification time of
+// the file they were read from, zero if they weren't read from a file.
+func (p *ProcNetStatReader) readMetrics(ctx context.Context) (*NetStatResults, time.Time, error) {
+	if p.source != nil {
+		rows, err := p.source.rows()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		parsed := netStatValuesPool.Get().(map[string]ValueDesc)
+		defer putNetStatValues(parsed)
+
+		for _, row := range rows {
+			p.addValues(parsed, row)
+		}
+
+		return newNetStatResults(parsed, len(rows)), time.Time{}, nil
+	}
+
 	f, err := os.Open(p.path)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	defer func() { _ = f.Close() }()
 
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
 	scanner := bufio.NewScanner(f)
 	scanner.Scan()
-	headers := strings.Fields(scanner.Text())
-	parsed := make(map[string]ValueDesc)
+	columns := p.parseHeader(scanner.Bytes())
+
+	// Values are summed by column index so that nothing is allocated per CPU row
+	// other than the fields of the row itself.
+	sums := make([]uint64, len(columns.headers))
+	parsed := make([]bool, len(columns.headers))
+	cpus := 0
+
+	for scanner.Scan() {
+		if err := procScanErr(ctx, cpus); err != nil {
+			return nil, time.Time{}, fmt.Errorf("stopped reading %s after %d CPUs: %w", p.path, cpus, err)
+		}
+
+		// Blank lines, such as a trailing one, aren't CPU rows
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 0 {
+			continue
+		}
+
+		cpus++
+
+		// The "entries" column is parsed before and independently of the rest of the
+		// row so that the size of the table, the most important value for alerting on
+		// saturation, is emitted even when other columns can't be parsed. It's the
+		// total number of entries in the table, shared across all CPUs, so it's only
+		// taken from the first row it can be parsed from instead of being summed.
+		if e := columns.entries; e >= 0 && e < len(parts) && !parsed[e] {
+			if val, ok := p.parseValue(columns.names[e], parts[e]); ok {
+				sums[e] = val
+				parsed[e] = true
+			}
+		}
+
+		for i := 0; i < len(columns.headers) && i < len(parts); i++ {
+			if i == columns.entries {
+				continue
+			}
 
-	for {
-		if !scanner.Scan() {
-			break
+			if val, ok := p.parseValue(columns.names[i], parts[i]); ok {
+				sums[i] += val
+				parsed[i] = true
+			}
 		}
+	}
 
-		line := scanner.Text()
-		parts := strings.Fields(line)
-		p.parseConnTrackValues(parsed, headers, parts)
+	values := make([]ValueDesc, 0, len(columns.headers))
+	for i, header := range columns.headers {
+		if parsed[i] {
+			values = append(values, p.newValue(columns.names[i], header, sums[i]))
+		}
 	}
 
+	sortNetStatValues(values)
+
+	return &NetStatResults{Values: values, CPUs: cpus}, info.ModTime(), nil
+}
+
+// parseValue parses a single value of a row, logging a warning if it can't be parsed.
+func (p *ProcNetStatReader) parseValue(name string, raw string) (uint64, bool) {
+	val, err := strconv.ParseUint(raw, p.numBase, 64)
+	if err != nil {
+		level.Warn(p.logger).Log("msg", "failed to parse value", "name", name, "value", raw, "err", err)
+		return 0, false
+	}
+
+	return val, true
+}
+
+// parseHeader returns the columns of the header line of the file. The columns of the
+// previous collection are reused unless the header changed, which only happens when the
+// kernel does, so that metric names aren't built again for each collection.
+func (p *ProcNetStatReader) parseHeader(line []byte) *netStatColumns {
+	if c := p.columns.Load(); c != nil && c.line == string(line) {
+		return c
+	}
+
+	fields := strings.Fields(string(line))
+	c := &netStatColumns{
+		line:    string(line),
+		headers: make([]string, len(fields)),
+		names:   make([]string, len(fields)),
+		entries: -1,
+	}
+
+	for i, field := range fields {
+		c.headers[i] = strings.ToLower(field)
+		c.names[i] = prometheus.BuildFQName("roger", p.subsystem, c.headers[i])
+		if c.headers[i] == entriesHeader {
+			c.entries = i
+		}
+	}
+
+	p.columns.Store(c)
+	return c
+}
+
+// netStatValuesPool holds maps of values summed across all CPUs by metric name so
+// that they can be reused between collections.
+var netStatValuesPool = sync.Pool{
+	New: func() any { return make(map[string]ValueDesc) },
+}
+
+// putNetStatValues empties the map and returns it to the pool.
+func putNetStatValues(parsed map[string]ValueDesc) {
+	for k := range parsed {
+		delete(parsed, k)
+	}
+
+	netStatValuesPool.Put(parsed)
+}
+
+// newNetStatResults returns the values summed across all CPUs, sorted by name.
+func newNetStatResults(parsed map[string]ValueDesc, cpus int) *NetStatResults {
 	parsedValues := make([]ValueDesc, 0, len(parsed))
 	for _, v := range parsed {
 		parsedValues = append(parsedValues, v)
 	}
-	return &NetStatResults{Values: parsedValues}, nil
+
+	sortNetStatValues(parsedValues)
+	return &NetStatResults{Values: parsedValues, CPUs: cpus}
 }
 
-func (p *ProcNetStatReader) parseConnTrackValues(parsed map[string]ValueDesc, headers []string, values []string) {
-	for i := 0; i < len(headers); i++ {
-		header := strings.ToLower(headers[i])
-		name := prometheus.BuildFQName("roger", p.subsystem, header)
-		val, err := strconv.ParseUint(values[i], 16, 64)
+// sortNetStatValues sorts values by name so that they are emitted in a stable order
+// between collections.
+func sortNetStatValues(values []ValueDesc) {
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].name < values[j].name
+	})
+}
 
-		if err != nil {
-			level.Warn(p.logger).Log("msg", "failed to parse value", "name", name, "value", values[i], "err", err)
-			continue
-		}
+// addValues adds the values of a row for a single CPU to the values summed across
+// all CPUs so far.
+func (p *ProcNetStatReader) addValues(parsed map[string]ValueDesc, row map[string]uint64) {
+	for header, val := range row {
+		name := prometheus.BuildFQName("roger", p.subsystem, header)
 
 		existing, ok := parsed[name]
 		if !ok {
-			// The "entries" metrics for each of the /proc/net/stat files represents entries in
-			// some sort of table that can go up or down and hence must be a gauge. The rest of
-			// the values are counters
-			var promType prometheus.ValueType
-			if header == entriesHeader {
-				promType = prometheus.GaugeValue
-			} else {
-				promType = prometheus.CounterValue
-			}
-
-			existing = ValueDesc{
-				name:     name,
-				val:      val,
-				promType: promType,
-			}
-
-			parsed[name] = existing
+			existing = p.newValue(name, header, val)
 		} else if header != entriesHeader {
 			// The "entries" metrics for each CPU actually represents the total number of entries
 			// in the table, it is shared across all CPUs. We only sum up the values here if the
@@ -162,3 +470,24 @@ func (p *ProcNetStatReader) parseConnTrackValues(parsed map[string]ValueDesc, he
 		parsed[name] = existing
 	}
 }
+
+// newValue creates a value for the given column, typed and described based on the
+// known columns of the file. The "entries" column of every /proc/net/stat file is the
+// size of some sort of table that can go up or down, so it's always a gauge. Other
+// unknown columns are assumed to be counters.
+func (p *ProcNetStatReader) newValue(name string, header string, val uint64) ValueDesc {
+	field, ok := p.fields[header]
+	if !ok {
+		field = counterField(fmt.Sprintf("generated from %s", p.path))
+		if header == entriesHeader {
+			field.promType = prometheus.GaugeValue
+		}
+	}
+
+	return ValueDesc{
+		name:     name,
+		help:     field.help,
+		val:      val,
+		promType: field.promType,
+	}
+}
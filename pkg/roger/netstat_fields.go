@@ -0,0 +1,116 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// types and help text of fields in known /proc/net/stat files
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// netStatField is the metric type and help text for a column of a /proc/net/stat file.
+type netStatField struct {
+	promType prometheus.ValueType
+	help     string
+}
+
+func counterField(help string) netStatField {
+	return netStatField{promType: prometheus.CounterValue, help: help}
+}
+
+func gaugeField(help string) netStatField {
+	return netStatField{promType: prometheus.GaugeValue, help: help}
+}
+
+// netStatFields are the known columns of each /proc/net/stat file, keyed by file
+// name and then by lowercase column name. Columns that aren't listed here, e.g.
+// ones added by newer kernels, are emitted as counters with generated help text.
+var netStatFields = map[string]map[string]netStatField{
+	"nf_conntrack": {
+		entriesHeader:    gaugeField("Number of entries in the connection tracking table"),
+		"searched":       counterField("Connection tracking table lookups performed"),
+		"found":          counterField("Connection tracking table lookups that found an entry"),
+		"new":            counterField("Connection tracking entries added that were not expected"),
+		"invalid":        counterField("Packets that could not be tracked"),
+		"ignore":         counterField("Packets that were already tracked or not eligible for tracking"),
+		"delete":         counterField("Connection tracking entries removed"),
+		"delete_list":    counterField("Connection tracking entries put on the dying list"),
+		"insert":         counterField("Connection tracking entries inserted"),
+		"insert_failed":  counterField("Connection tracking entries that failed to be inserted"),
+		"drop":           counterField("Packets dropped due to connection tracking failures"),
+		"early_drop":     counterField("Connection tracking entries dropped to make room when the table was full"),
+		"icmp_error":     counterField("ICMP error packets that could not be tracked"),
+		"expect_new":     counterField("Connection tracking expectations added"),
+		"expect_create":  counterField("Connection tracking expectations created"),
+		"expect_delete":  counterField("Connection tracking expectations removed"),
+		"search_restart": counterField("Connection tracking table lookups restarted due to hash table resizing"),
+		"clashres":       counterField("Connection tracking insertion clashes that were resolved"),
+		"chaintoolong":   counterField("Connection tracking entries dropped because a hash chain was too long"),
+	},
+	"arp_cache": {
+		entriesHeader:         gaugeField("Number of entries in the neighbor table"),
+		"allocs":              counterField("Neighbor table entries allocated"),
+		"destroys":            counterField("Neighbor table entries destroyed"),
+		"hash_grows":          counterField("Times the neighbor table hash was resized"),
+		"lookups":             counterField("Neighbor table lookups performed"),
+		"hits":                counterField("Neighbor table lookups that found an entry"),
+		"res_failed":          counterField("Neighbor address resolutions that failed"),
+		"rcv_probes_mcast":    counterField("Multicast neighbor probes received"),
+		"rcv_probes_ucast":    counterField("Unicast neighbor probes received"),
+		"periodic_gc_runs":    counterField("Periodic garbage collection runs of the neighbor table"),
+		"forced_gc_runs":      counterField("Forced garbage collection runs of the neighbor table"),
+		"unresolved_discards": counterField("Packets discarded while waiting for neighbor address resolution"),
+		"table_fulls":         counterField("Times the neighbor table was full when adding an entry"),
+	},
+	"ndisc_cache": {
+		entriesHeader:         gaugeField("Number of entries in the IPv6 neighbor discovery table"),
+		"allocs":              counterField("IPv6 neighbor discovery table entries allocated"),
+		"destroys":            counterField("IPv6 neighbor discovery table entries destroyed"),
+		"hash_grows":          counterField("Times the IPv6 neighbor discovery table hash was resized"),
+		"lookups":             counterField("IPv6 neighbor discovery table lookups performed"),
+		"hits":                counterField("IPv6 neighbor discovery table lookups that found an entry"),
+		"res_failed":          counterField("IPv6 neighbor address resolutions that failed"),
+		"rcv_probes_mcast":    counterField("Multicast IPv6 neighbor solicitations received"),
+		"rcv_probes_ucast":    counterField("Unicast IPv6 neighbor solicitations received"),
+		"periodic_gc_runs":    counterField("Periodic garbage collection runs of the IPv6 neighbor discovery table"),
+		"forced_gc_runs":      counterField("Forced garbage collection runs of the IPv6 neighbor discovery table"),
+		"unresolved_discards": counterField("Packets discarded while waiting for IPv6 neighbor address resolution"),
+		"table_fulls":         counterField("Times the IPv6 neighbor discovery table was full when adding an entry"),
+	},
+	"rt_cache": {
+		entriesHeader:      gaugeField("Number of entries in the route cache"),
+		"in_hit":           counterField("Incoming packets routed using the route cache"),
+		"in_slow_tot":      counterField("Incoming packets that required a route lookup"),
+		"in_slow_mc":       counterField("Incoming multicast packets that required a route lookup"),
+		"in_no_route":      counterField("Incoming packets without a route"),
+		"in_brd":           counterField("Incoming broadcast packets"),
+		"in_martian_dst":   counterField("Incoming packets with a martian destination address"),
+		"in_martian_src":   counterField("Incoming packets with a martian source address"),
+		"out_hit":          counterField("Outgoing packets routed using the route cache"),
+		"out_slow_tot":     counterField("Outgoing packets that required a route lookup"),
+		"out_slow_mc":      counterField("Outgoing multicast packets that required a route lookup"),
+		"gc_total":         counterField("Route cache garbage collection runs"),
+		"gc_ignored":       counterField("Route cache garbage collection runs skipped"),
+		"gc_goal_miss":     counterField("Route cache garbage collection runs that did not reach their goal"),
+		"gc_dst_overflow":  counterField("Route cache garbage collection runs where the cache was full"),
+		"in_hlist_search":  counterField("Route cache hash chain entries searched for incoming packets"),
+		"out_hlist_search": counterField("Route cache hash chain entries searched for outgoing packets"),
+	},
+}
+
+// lookupNetStatFields returns the known columns of a /proc/net/stat file, falling
+// back to the file the subsystem normally corresponds to (such as nf_conntrack for
+// ip_conntrack). The result is nil for files that aren't known.
+func lookupNetStatFields(variant string) map[string]netStatField {
+	if fields, ok := netStatFields[variant]; ok {
+		return fields
+	}
+
+	return netStatFields[netStatSubsystems[variant]]
+}
@@ -1 +1,477 @@
 package roger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const connTrackFixture = `entries  searched found new invalid ignore delete delete_list insert insert_failed drop early_drop icmp_error  expect_new expect_create expect_delete search_restart
+000000a2  00000000 00000000 00000000 00000010 00000390 00000000 00000000 00000000 00000000 00000000 00000000 00000000  00000000 00000000 00000000 00000003
+000000a2  00000000 00000000 00000000 00000020 000001d2 00000000 00000000 00000000 00000000 00000000 00000000 00000000  00000000 00000000 00000000 00000001
+`
+
+func TestProcNetStatReader_ReadMetrics(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	values := make(map[string]ValueDesc)
+	for _, v := range res.Values {
+		values[v.name] = v
+	}
+
+	assert.Equal(t, uint64(0xa2), values["roger_nf_conntrack_entries"].val)
+	assert.Equal(t, prometheus.GaugeValue, values["roger_nf_conntrack_entries"].promType)
+	assert.Equal(t, uint64(0x30), values["roger_nf_conntrack_invalid"].val)
+	assert.Equal(t, prometheus.CounterValue, values["roger_nf_conntrack_invalid"].promType)
+	assert.Equal(t, uint64(0x390+0x1d2), values["roger_nf_conntrack_ignore"].val)
+	assert.Equal(t, uint64(4), values["roger_nf_conntrack_search_restart"].val)
+	assert.Equal(t, 2, res.CPUs)
+}
+
+func TestProcNetStatReader_ReadMetricsContextCanceled(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+	_, err := reader.ReadMetricsContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestValueDesc_MarshalJSON(t *testing.T) {
+	res := NetStatResults{
+		Values: []ValueDesc{{name: "roger_nf_conntrack_entries", help: "Entries", val: 162, promType: prometheus.GaugeValue}},
+		CPUs:   2,
+	}
+
+	out, err := json.Marshal(res)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"values":[{"name":"roger_nf_conntrack_entries","help":"Entries","value":162,"type":"gauge"}],"cpus":2}`, string(out))
+}
+
+func TestProcNetStatReader_Collect(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+	names := metricNames(t, reader)
+
+	require.Len(t, names, 20)
+	assert.True(t, sort.StringsAreSorted(names[:17]))
+	assert.Equal(t, "roger_nf_conntrack_cpus", names[17])
+	assert.Equal(t, "roger_collector_descriptions", names[18])
+	assert.Equal(t, "roger_proc_file_mtime_seconds", names[19])
+}
+
+func TestProcNetStatReader_CollectMtime(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+
+	modified := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(base, "net/stat/nf_conntrack"), modified, modified))
+
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+	expected := `
+# HELP roger_proc_file_mtime_seconds Modification time of the /proc file metrics were read from, in seconds since the epoch. procfs doesn't update it when contents change, it's only meaningful when reading a copy of /proc such as a snapshot
+# TYPE roger_proc_file_mtime_seconds gauge
+roger_proc_file_mtime_seconds{file="net/stat/nf_conntrack"} 1.6146e+09
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_proc_file_mtime_seconds"))
+}
+
+// The route cache was removed in Linux 3.6 but the stats file remains, with
+// most columns always zero. Like the other net/stat files, values are hex.
+const rtCacheFixture = `entries  in_hit   in_slow_tot in_slow_mc in_no_route in_brd in_martian_dst in_martian_src  out_hit  out_slow_tot out_slow_mc  gc_total gc_ignored gc_goal_miss gc_dst_overflow in_hlist_search out_hlist_search
+00000013  00000000 0000002c 00000000 00000000 00000001 00000000 00000000  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 
+00000013  00000000 0000001a 00000000 00000000 00000000 00000000 00000000  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 
+`
+
+func TestProcNetStatReader_RtCache(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		reader := NewProcNetStatReader(t.TempDir(), "rt_cache", log.NewNopLogger())
+		assert.False(t, reader.Exists())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/stat/rt_cache", rtCacheFixture)
+
+		reader := NewProcNetStatReader(base, "rt_cache", log.NewNopLogger())
+		require.True(t, reader.Exists())
+
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+
+		values := make(map[string]ValueDesc)
+		for _, v := range res.Values {
+			values[v.name] = v
+		}
+
+		require.Len(t, values, 17)
+		assert.Equal(t, uint64(0x13), values["roger_rt_cache_entries"].val)
+		assert.Equal(t, prometheus.GaugeValue, values["roger_rt_cache_entries"].promType)
+		assert.Equal(t, uint64(0x2c+0x1a), values["roger_rt_cache_in_slow_tot"].val)
+		assert.Equal(t, uint64(1), values["roger_rt_cache_in_brd"].val)
+		assert.Equal(t, prometheus.CounterValue, values["roger_rt_cache_in_brd"].promType)
+	})
+}
+
+func TestProcNetStatReader_IpConntrack(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/ip_conntrack", connTrackFixture)
+
+	reader := NewProcNetStatReader(base, "ip_conntrack", log.NewNopLogger())
+	require.True(t, reader.Exists())
+	assert.Equal(t, "nf_conntrack", reader.Subsystem())
+
+	names := metricNames(t, reader)
+	assert.Contains(t, names, "roger_nf_conntrack_entries")
+	assert.Contains(t, names, "roger_nf_conntrack_search_restart")
+}
+
+func TestNewProcNetStatReaderWithSubsystem(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/arp_cache", connTrackFixture)
+
+	reader := NewProcNetStatReaderWithSubsystem(base, "arp_cache", "neighbor", log.NewNopLogger())
+	require.True(t, reader.Exists())
+	assert.Equal(t, "neighbor", reader.Subsystem())
+	assert.Contains(t, metricNames(t, reader), "roger_neighbor_entries")
+}
+
+const arpCacheFixture = `entries  allocs   destroys hash_grows lookups  hits     res_failed rcv_probes_mcast rcv_probes_ucast periodic_gc_runs forced_gc_runs unresolved_discards table_fulls
+00000007  00000012 0000000b 00000000 000001f4 000001c0 00000002 00000000 00000000 00000035 00000000 00000000 00000000
+00000007  00000003 00000001 00000000 00000020 00000010 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+`
+
+// ndiscCacheFixture has the same columns as arp_cache, values are hex. Entries are
+// the size of the table which is repeated for each CPU.
+const ndiscCacheFixture = `entries  allocs   destroys hash_grows lookups  hits     res_failed rcv_probes_mcast rcv_probes_ucast periodic_gc_runs forced_gc_runs unresolved_discards table_fulls
+0000000c  00000020 00000014 00000001 00001000 00000f00 00000003 0000002a 00000005 00000040 00000000 00000000 00000000
+0000000c  00000004 00000002 00000000 000000ff 000000f0 00000000 00000000 00000001 00000000 00000000 00000000 00000000
+`
+
+func TestProcNetStatReader_KnownFields(t *testing.T) {
+	t.Run("arp_cache", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/stat/arp_cache", arpCacheFixture)
+
+		reader := NewProcNetStatReader(base, "arp_cache", log.NewNopLogger())
+		expected := `
+# HELP roger_arp_cache_entries Number of entries in the neighbor table
+# TYPE roger_arp_cache_entries gauge
+roger_arp_cache_entries 7
+# HELP roger_arp_cache_lookups Neighbor table lookups performed
+# TYPE roger_arp_cache_lookups counter
+roger_arp_cache_lookups 532
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_arp_cache_entries", "roger_arp_cache_lookups"))
+	})
+
+	t.Run("ndisc_cache", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/stat/ndisc_cache", ndiscCacheFixture)
+
+		reader := NewProcNetStatReader(base, "ndisc_cache", log.NewNopLogger())
+		expected := `
+# HELP roger_ndisc_cache_entries Number of entries in the IPv6 neighbor discovery table
+# TYPE roger_ndisc_cache_entries gauge
+roger_ndisc_cache_entries 12
+# HELP roger_ndisc_cache_lookups IPv6 neighbor discovery table lookups performed
+# TYPE roger_ndisc_cache_lookups counter
+roger_ndisc_cache_lookups 4351
+# HELP roger_ndisc_cache_rcv_probes_mcast Multicast IPv6 neighbor solicitations received
+# TYPE roger_ndisc_cache_rcv_probes_mcast counter
+roger_ndisc_cache_rcv_probes_mcast 42
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+			"roger_ndisc_cache_entries", "roger_ndisc_cache_lookups", "roger_ndisc_cache_rcv_probes_mcast"))
+	})
+
+	t.Run("ip_conntrack", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/stat/ip_conntrack", connTrackFixture)
+
+		reader := NewProcNetStatReader(base, "ip_conntrack", log.NewNopLogger())
+		expected := `
+# HELP roger_nf_conntrack_entries Number of entries in the connection tracking table
+# TYPE roger_nf_conntrack_entries gauge
+roger_nf_conntrack_entries 162
+`
+		assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_nf_conntrack_entries"))
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/stat/rt_cache", "entries  in_hit  in_future\n00000002 00000001 00000005\n")
+		writeProcFixture(t, base, "net/stat/unknown_cache", "entries  allocs\n00000003 00000004\n")
+
+		rtCache := NewProcNetStatReader(base, "rt_cache", log.NewNopLogger())
+		expected := fmt.Sprintf(`
+# HELP roger_rt_cache_in_future generated from %s
+# TYPE roger_rt_cache_in_future counter
+roger_rt_cache_in_future 5
+# HELP roger_rt_cache_in_hit Incoming packets routed using the route cache
+# TYPE roger_rt_cache_in_hit counter
+roger_rt_cache_in_hit 1
+`, filepath.Join(base, "net", "stat", "rt_cache"))
+		assert.NoError(t, testutil.CollectAndCompare(rtCache, strings.NewReader(expected), "roger_rt_cache_in_future", "roger_rt_cache_in_hit"))
+
+		unknown := NewProcNetStatReader(base, "unknown_cache", log.NewNopLogger())
+		expected = fmt.Sprintf(`
+# HELP roger_unknown_cache_allocs generated from %[1]s
+# TYPE roger_unknown_cache_allocs counter
+roger_unknown_cache_allocs 4
+# HELP roger_unknown_cache_entries generated from %[1]s
+# TYPE roger_unknown_cache_entries gauge
+roger_unknown_cache_entries 3
+`, filepath.Join(base, "net", "stat", "unknown_cache"))
+		assert.NoError(t, testutil.CollectAndCompare(unknown, strings.NewReader(expected), "roger_unknown_cache_allocs", "roger_unknown_cache_entries"))
+	})
+}
+
+func TestNewProcNetStatReaderFromConfig(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/custom_cache", `entries lookups size
+10 20 30
+10 5 30
+`)
+
+	reader := NewProcNetStatReaderFromConfig(base, NetStatFileConfig{
+		File:      "custom_cache",
+		Subsystem: "custom",
+		Base:      10,
+		Gauges:    []string{"SIZE"},
+	}, log.NewNopLogger())
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	values := make(map[string]ValueDesc)
+	for _, v := range res.Values {
+		values[v.name] = v
+	}
+
+	assert.Equal(t, "custom", reader.Subsystem())
+	assert.Equal(t, uint64(10), values["roger_custom_entries"].val)
+	assert.Equal(t, prometheus.GaugeValue, values["roger_custom_entries"].promType)
+	assert.Equal(t, uint64(25), values["roger_custom_lookups"].val)
+	assert.Equal(t, prometheus.CounterValue, values["roger_custom_lookups"].promType)
+	assert.Equal(t, uint64(60), values["roger_custom_size"].val)
+	assert.Equal(t, prometheus.GaugeValue, values["roger_custom_size"].promType)
+}
+
+func TestNewProcNetStatReaderFromConfig_KnownFields(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+
+	reader := NewProcNetStatReaderFromConfig(base, NetStatFileConfig{File: "nf_conntrack", Gauges: []string{"searched"}}, log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	values := make(map[string]ValueDesc)
+	for _, v := range res.Values {
+		values[v.name] = v
+	}
+
+	// Known help text is kept while the type is changed, without changing the
+	// fields used by other readers of the same file.
+	assert.Equal(t, prometheus.GaugeValue, values["roger_nf_conntrack_searched"].promType)
+	assert.Equal(t, netStatFields["nf_conntrack"]["searched"].help, values["roger_nf_conntrack_searched"].help)
+	assert.Equal(t, prometheus.CounterValue, netStatFields["nf_conntrack"]["searched"].promType)
+}
+
+func TestProcNetStatReader_ReadMetricsHeaderChanged(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/nf_conntrack", connTrackFixture)
+
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+	_, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	writeProcFixture(t, base, "net/stat/nf_conntrack", "entries clashres\n0000000a 00000002\n0000000a 00000003\n")
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	require.Len(t, res.Values, 2)
+	assert.Equal(t, "roger_nf_conntrack_clashres", res.Values[0].name)
+	assert.Equal(t, uint64(5), res.Values[0].val)
+	assert.Equal(t, "roger_nf_conntrack_entries", res.Values[1].name)
+	assert.Equal(t, uint64(10), res.Values[1].val)
+}
+
+func TestProcNetStatReader_BlankLines(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/arp_cache", "allocs destroys entries\n00000001 00000002 00000020\n\n   \n00000003 00000004 00000020\n\n")
+
+	reader := NewProcNetStatReader(base, "arp_cache", log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.CPUs)
+
+	require.Len(t, res.Values, 3)
+	assert.Equal(t, "roger_arp_cache_allocs", res.Values[0].name)
+	assert.Equal(t, uint64(4), res.Values[0].val)
+}
+
+func TestProcNetStatReader_EntriesWithInvalidColumns(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/arp_cache", `allocs destroys entries lookups
+zzzzzzzz 00000001 00000020 ????????
+0000000x ------   00000020 00000004
+`)
+
+	reader := NewProcNetStatReader(base, "arp_cache", log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.CPUs)
+
+	require.Len(t, res.Values, 3)
+	assert.Equal(t, "roger_arp_cache_destroys", res.Values[0].name)
+	assert.Equal(t, "roger_arp_cache_entries", res.Values[1].name)
+	assert.Equal(t, uint64(0x20), res.Values[1].val)
+	assert.Equal(t, "roger_arp_cache_lookups", res.Values[2].name)
+
+	expected := `
+# HELP roger_arp_cache_entries Number of entries in the neighbor table
+# TYPE roger_arp_cache_entries gauge
+roger_arp_cache_entries 32
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_arp_cache_entries"))
+}
+
+func TestProcNetStatReader_NormalizePerCPU(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/stat/unknown_cache", `entries  allocs   lookups
+00000020 00000001 00000004
+00000020 00000002 00000006
+00000020 00000003 00000000
+00000020 00000000 00000005
+`)
+
+	reader := NewProcNetStatReader(base, "unknown_cache", log.NewNopLogger())
+	reader.NormalizePerCPU = true
+
+	// Summed columns are divided by the number of CPUs and become gauges, the entries
+	// column is shared by all CPUs so it's unchanged.
+	help := fmt.Sprintf("generated from %s", filepath.Join(base, "net", "stat", "unknown_cache"))
+	expected := fmt.Sprintf(`
+# HELP roger_unknown_cache_allocs %[1]s
+# TYPE roger_unknown_cache_allocs gauge
+roger_unknown_cache_allocs 1.5
+# HELP roger_unknown_cache_entries %[1]s
+# TYPE roger_unknown_cache_entries gauge
+roger_unknown_cache_entries 32
+# HELP roger_unknown_cache_lookups %[1]s
+# TYPE roger_unknown_cache_lookups gauge
+roger_unknown_cache_lookups 3.75
+`, help)
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected),
+		"roger_unknown_cache_allocs", "roger_unknown_cache_entries", "roger_unknown_cache_lookups"))
+
+	// Values read for debugging are still the raw sums
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	require.Len(t, res.Values, 3)
+	assert.Equal(t, uint64(6), res.Values[0].val)
+	assert.Equal(t, uint64(15), res.Values[2].val)
+}
+
+func TestPerCPU(t *testing.T) {
+	assert.Equal(t, 2.5, perCPU(10, 4))
+	assert.Equal(t, 10.0, perCPU(10, 0))
+}
+
+// connTrackFixtureCPUs returns the contents of /proc/net/stat/nf_conntrack for a host
+// with the given number of CPUs.
+func connTrackFixtureCPUs(cpus int) string {
+	lines := strings.SplitN(connTrackFixture, "\n", 3)
+
+	var sb strings.Builder
+	sb.WriteString(lines[0] + "\n")
+	for i := 0; i < cpus; i++ {
+		sb.WriteString(lines[1+i%2] + "\n")
+	}
+
+	return sb.String()
+}
+
+// readNetStatMap is the previous approach of building metric names for every column
+// of every CPU and summing them in a new map each collection, used as a baseline for
+// comparison with ProcNetStatReader.ReadMetrics.
+func readNetStatMap(p *ProcNetStatReader) (*NetStatResults, error) {
+	contents, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	headers := strings.Fields(lines[0])
+	parsed := make(map[string]ValueDesc)
+
+	for _, line := range lines[1:] {
+		row := make(map[string]uint64)
+		for i, part := range strings.Fields(line) {
+			val, err := strconv.ParseUint(part, p.numBase, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			row[strings.ToLower(headers[i])] = val
+		}
+
+		p.addValues(parsed, row)
+	}
+
+	return newNetStatResults(parsed, len(lines)-1), nil
+}
+
+func BenchmarkProcNetStatReader_ReadMetricsMap(b *testing.B) {
+	base := b.TempDir()
+	writeProcFixture(b, base, "net/stat/nf_conntrack", connTrackFixtureCPUs(64))
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := readNetStatMap(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcNetStatReader_ReadMetrics(b *testing.B) {
+	base := b.TempDir()
+	writeProcFixture(b, base, "net/stat/nf_conntrack", connTrackFixtureCPUs(64))
+	reader := NewProcNetStatReader(base, "nf_conntrack", log.NewNopLogger())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := reader.ReadMetrics(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
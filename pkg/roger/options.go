@@ -0,0 +1,151 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// options for creating a DnsmasqReader along with its client
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/miekg/dns"
+)
+
+// DnsmasqOptions are settings used by NewDnsmasqReaderWithOptions to create a
+// DnsmasqReader and the client it uses to query the server.
+type DnsmasqOptions struct {
+	// Protocols to query the server with, tried in order, see FallbackClient.
+	// Defaults to UDP.
+	Protocols []string
+
+	// TLSServerName is the server name to use for DNS over TLS, see NewDNSClient.
+	TLSServerName string
+
+	// Timeout for dialing, writing, and reading each query, including queries made
+	// with the Dialer. The default timeouts of dns.Client are used when zero.
+	Timeout time.Duration
+
+	// Dialer creates a connection for each query instead of the client, see
+	// DnsmasqReader.Dialer.
+	Dialer ContextDialer
+
+	// Retries is the number of times to retry a failed query, see DnsmasqReader.Retries.
+	Retries int
+
+	// ChaosSuffix is appended to the name of each counter to build the name of the
+	// query for it, see DnsmasqReader.ChaosSuffix. Defaults to ".bind.".
+	ChaosSuffix string
+
+	// QueryClass is the class of each query, see DnsmasqReader.QueryClass. Defaults
+	// to dns.ClassCHAOS.
+	QueryClass uint16
+}
+
+// DnsmasqOption sets one of the DnsmasqOptions.
+type DnsmasqOption func(o *DnsmasqOptions)
+
+// WithProtocol sets the protocols to query the server with, in the order to try them.
+func WithProtocol(protocols ...string) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.Protocols = protocols
+	}
+}
+
+// WithTLSServerName sets the server name to use for DNS over TLS.
+func WithTLSServerName(name string) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.TLSServerName = name
+	}
+}
+
+// WithTimeout sets the timeout for dialing, writing, and reading each query.
+func WithTimeout(timeout time.Duration) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// WithDialer sets the dialer used to create a TCP connection to the server for each
+// query, e.g. to make queries through a tunnel or proxy.
+func WithDialer(dialer ContextDialer) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.Dialer = dialer
+	}
+}
+
+// WithRetries sets the number of times to retry a failed query.
+func WithRetries(retries int) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.Retries = retries
+	}
+}
+
+// WithChaosSuffix sets the suffix of each query name. Leading and trailing dots are
+// added if missing, e.g. "server" becomes ".server.".
+func WithChaosSuffix(suffix string) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.ChaosSuffix = "." + strings.Trim(suffix, ".") + "."
+	}
+}
+
+// WithQueryClass sets the class of each query, e.g. the result of ParseQueryClass.
+func WithQueryClass(class uint16) DnsmasqOption {
+	return func(o *DnsmasqOptions) {
+		o.QueryClass = class
+	}
+}
+
+// NewDnsmasqReaderWithOptions creates a DnsmasqReader for the server at address along
+// with a FallbackClient for querying it. NewDnsmasqReader can be used instead for the
+// common case of querying a server with an existing client.
+func NewDnsmasqReaderWithOptions(address string, logger log.Logger, opts ...DnsmasqOption) *DnsmasqReader {
+	return newDnsmasqReaderWithOptions(address, newDescriptions(), newDescriptionCache(), logger, opts)
+}
+
+// newDnsmasqOptions returns the defaults changed by each of the options.
+func newDnsmasqOptions(opts []DnsmasqOption) DnsmasqOptions {
+	o := DnsmasqOptions{
+		Protocols:   []string{ProtocolUDP},
+		ChaosSuffix: ".bind.",
+		QueryClass:  dns.ClassCHAOS,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// configure changes the settings of the reader that don't depend on its client.
+func (o DnsmasqOptions) configure(reader *DnsmasqReader) {
+	reader.Dialer = o.Dialer
+	reader.DialerTimeout = o.Timeout
+	reader.Retries = o.Retries
+	reader.ChaosSuffix = o.ChaosSuffix
+	reader.QueryClass = o.QueryClass
+}
+
+func newDnsmasqReaderWithOptions(address string, descriptions *descriptions, extraDescs *descriptionCache, logger log.Logger, opts []DnsmasqOption) *DnsmasqReader {
+	o := newDnsmasqOptions(opts)
+
+	transports := make([]transport, len(o.Protocols))
+	for i, p := range o.Protocols {
+		client := NewDNSClient(p, address, o.TLSServerName)
+		client.Timeout = o.Timeout
+		transports[i] = transport{protocol: p, client: client}
+	}
+
+	reader := newDnsmasqReader(&FallbackClient{transports: transports}, address, descriptions, extraDescs, logger)
+	o.configure(reader)
+	return reader
+}
@@ -0,0 +1,66 @@
+package roger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDnsmasqReaderWithOptions(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		reader := NewDnsmasqReaderWithOptions("127.0.0.1:53", log.NewNopLogger())
+
+		client, ok := reader.client.(*FallbackClient)
+		require.True(t, ok)
+		require.Len(t, client.transports, 1)
+		assert.Equal(t, ProtocolUDP, client.transports[0].protocol)
+		assert.Equal(t, ".bind.", reader.ChaosSuffix)
+		assert.Equal(t, 0, reader.Retries)
+		assert.Equal(t, uint16(dns.ClassCHAOS), reader.QueryClass)
+		assert.True(t, reader.RecursionDesired)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		dialer := &pipeDialer{}
+		reader := NewDnsmasqReaderWithOptions("127.0.0.1:853", log.NewNopLogger(),
+			WithProtocol(ProtocolUDP, ProtocolTLS),
+			WithTLSServerName("dns.example.com"),
+			WithTimeout(500*time.Millisecond),
+			WithRetries(2),
+			WithChaosSuffix("server"),
+			WithQueryClass(dns.ClassINET),
+			WithDialer(dialer),
+		)
+
+		client, ok := reader.client.(*FallbackClient)
+		require.True(t, ok)
+		require.Len(t, client.transports, 2)
+
+		udp := client.transports[0].client.(*dns.Client)
+		assert.Equal(t, ProtocolUDP, udp.Net)
+		assert.Equal(t, 500*time.Millisecond, udp.Timeout)
+
+		tls := client.transports[1].client.(*dns.Client)
+		assert.Equal(t, ProtocolTLS, tls.Net)
+		assert.Equal(t, "dns.example.com", tls.TLSConfig.ServerName)
+		assert.Equal(t, 500*time.Millisecond, tls.Timeout)
+
+		assert.Equal(t, 2, reader.Retries)
+		assert.Equal(t, ".server.", reader.ChaosSuffix)
+		assert.Equal(t, uint16(dns.ClassINET), reader.QueryClass)
+		assert.Same(t, dialer, reader.Dialer)
+		assert.Equal(t, 500*time.Millisecond, reader.DialerTimeout)
+	})
+}
+
+func TestWithChaosSuffix(t *testing.T) {
+	for _, suffix := range []string{"bind", ".bind", "bind.", ".bind."} {
+		var o DnsmasqOptions
+		WithChaosSuffix(suffix)(&o)
+		assert.Equal(t, ".bind.", o.ChaosSuffix, suffix)
+	}
+}
@@ -0,0 +1,247 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// push gathered metrics to an OpenTelemetry collector over OTLP/HTTP
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLPPusher periodically gathers metrics and pushes them to an OTLP/HTTP endpoint
+// using the OTLP exporter of the OpenTelemetry SDK. Counters become monotonic sums,
+// gauges and untyped metrics become gauges, and histograms and summaries are converted
+// to their OTLP equivalents. Metrics that fail to gather are skipped and the rest still
+// pushed.
+//
+// Errors pushing on the interval are reported to the global OpenTelemetry error
+// handler, see otel.SetErrorHandler.
+type OTLPPusher struct {
+	endpoint string
+	interval time.Duration
+	provider *sdkmetric.MeterProvider
+	logger   log.Logger
+}
+
+// NewOTLPPusher creates a pusher sending metrics from the gatherer to endpoint, the
+// full URL of an OTLP/HTTP metrics receiver, e.g. http://localhost:4318/v1/metrics.
+// Metrics are pushed on the interval from when the pusher is created until Run
+// returns.
+func NewOTLPPusher(endpoint string, gatherer prometheus.Gatherer, interval time.Duration, logger log.Logger) (*OTLPPusher, error) {
+	exporter, err := otlpmetrichttp.New(
+		context.Background(),
+		otlpmetrichttp.WithEndpointURL(endpoint),
+		otlpmetrichttp.WithTimeout(interval),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := &gathererProducer{gatherer: gatherer, start: time.Now(), now: time.Now, logger: logger}
+	reader := sdkmetric.NewPeriodicReader(
+		exporter,
+		sdkmetric.WithInterval(interval),
+		sdkmetric.WithTimeout(interval),
+		sdkmetric.WithProducer(producer),
+	)
+
+	return &OTLPPusher{
+		endpoint: endpoint,
+		interval: interval,
+		provider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(reader),
+			sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "roger"))),
+		),
+		logger: logger,
+	}, nil
+}
+
+// Run waits until the context is canceled and then stops pushing metrics, pushing them
+// one last time.
+func (o *OTLPPusher) Run(ctx context.Context) {
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.interval)
+	defer cancel()
+
+	if err := o.provider.Shutdown(shutdownCtx); err != nil {
+		level.Error(o.logger).Log("msg", "failed to push metrics via OTLP when stopping", "endpoint", o.endpoint, "err", err)
+	}
+}
+
+// Push gathers metrics and sends them to the endpoint once.
+func (o *OTLPPusher) Push(ctx context.Context) error {
+	return o.provider.ForceFlush(ctx)
+}
+
+// gathererProducer converts gathered metric families to the metric data exported by
+// the SDK. Counters and histograms start from when the producer was created unless
+// they have a created timestamp.
+type gathererProducer struct {
+	gatherer prometheus.Gatherer
+	start    time.Time
+	now      func() time.Time
+	logger   log.Logger
+}
+
+func (p *gathererProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		level.Warn(p.logger).Log("msg", "error gathering metrics to push via OTLP, pushing partial results", "err", err)
+	}
+
+	now := p.now()
+	metrics := make([]metricdata.Metrics, 0, len(families))
+	for _, f := range families {
+		if data, ok := p.aggregation(f, now); ok {
+			metrics = append(metrics, metricdata.Metrics{Name: f.GetName(), Description: f.GetHelp(), Data: data})
+		}
+	}
+
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: "github.com/56quarters/roger"},
+		Metrics: metrics,
+	}}, nil
+}
+
+// aggregation converts the metrics of a family, returning false for types that have no
+// OTLP equivalent.
+func (p *gathererProducer) aggregation(f *dto.MetricFamily, now time.Time) (metricdata.Aggregation, bool) {
+	switch f.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		for _, m := range f.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, p.point(m, m.GetCounter().GetValue(), m.GetCounter().GetCreatedTimestamp().AsTime(), now))
+		}
+		return sum, true
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		gauge := metricdata.Gauge[float64]{}
+		for _, m := range f.GetMetric() {
+			val := m.GetGauge().GetValue()
+			if f.GetType() == dto.MetricType_UNTYPED {
+				val = m.GetUntyped().GetValue()
+			}
+
+			gauge.DataPoints = append(gauge.DataPoints, p.point(m, val, time.Time{}, now))
+		}
+		return gauge, true
+	case dto.MetricType_HISTOGRAM:
+		hist := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+		for _, m := range f.GetMetric() {
+			hist.DataPoints = append(hist.DataPoints, p.histogramPoint(m, now))
+		}
+		return hist, true
+	case dto.MetricType_SUMMARY:
+		summary := metricdata.Summary{}
+		for _, m := range f.GetMetric() {
+			summary.DataPoints = append(summary.DataPoints, p.summaryPoint(m, now))
+		}
+		return summary, true
+	default:
+		return nil, false
+	}
+}
+
+// times returns the start and sample time of a metric, using its created timestamp
+// and timestamp if it has them.
+func (p *gathererProducer) times(m *dto.Metric, created time.Time, now time.Time) (time.Time, time.Time) {
+	start := p.start
+	if created.Unix() > 0 {
+		start = created
+	}
+
+	if m.TimestampMs != nil {
+		now = time.UnixMilli(m.GetTimestampMs())
+	}
+
+	return start, now
+}
+
+func (p *gathererProducer) point(m *dto.Metric, val float64, created time.Time, now time.Time) metricdata.DataPoint[float64] {
+	start, ts := p.times(m, created, now)
+	return metricdata.DataPoint[float64]{Attributes: otlpAttributes(m), StartTime: start, Time: ts, Value: val}
+}
+
+// histogramPoint converts the cumulative buckets of a Prometheus histogram to the
+// count of each bucket. Prometheus leaves out the +Inf bucket, OTLP has an implicit
+// bucket above the last bound.
+func (p *gathererProducer) histogramPoint(m *dto.Metric, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := m.GetHistogram()
+	start, ts := p.times(m, h.GetCreatedTimestamp().AsTime(), now)
+
+	var (
+		bounds []float64
+		counts []uint64
+		prev   uint64
+	)
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			continue
+		}
+
+		bounds = append(bounds, b.GetUpperBound())
+		counts = append(counts, b.GetCumulativeCount()-prev)
+		prev = b.GetCumulativeCount()
+	}
+	counts = append(counts, h.GetSampleCount()-prev)
+
+	return metricdata.HistogramDataPoint[float64]{
+		Attributes:   otlpAttributes(m),
+		StartTime:    start,
+		Time:         ts,
+		Count:        h.GetSampleCount(),
+		Sum:          h.GetSampleSum(),
+		Bounds:       bounds,
+		BucketCounts: counts,
+	}
+}
+
+func (p *gathererProducer) summaryPoint(m *dto.Metric, now time.Time) metricdata.SummaryDataPoint {
+	s := m.GetSummary()
+	start, ts := p.times(m, s.GetCreatedTimestamp().AsTime(), now)
+
+	quantiles := make([]metricdata.QuantileValue, 0, len(s.GetQuantile()))
+	for _, q := range s.GetQuantile() {
+		quantiles = append(quantiles, metricdata.QuantileValue{Quantile: q.GetQuantile(), Value: q.GetValue()})
+	}
+
+	return metricdata.SummaryDataPoint{
+		Attributes:     otlpAttributes(m),
+		StartTime:      start,
+		Time:           ts,
+		Count:          s.GetSampleCount(),
+		Sum:            s.GetSampleSum(),
+		QuantileValues: quantiles,
+	}
+}
+
+// otlpAttributes converts the labels of a metric to attributes.
+func otlpAttributes(m *dto.Metric) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(m.GetLabel()))
+	for _, l := range m.GetLabel() {
+		kvs = append(kvs, attribute.String(l.GetName(), l.GetValue()))
+	}
+
+	return attribute.NewSet(kvs...)
+}
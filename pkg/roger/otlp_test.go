@@ -0,0 +1,89 @@
+package roger
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPPusher_Push(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_queries_total", Help: "Queries"}, []string{"server"})
+	counter.WithLabelValues("10.0.0.1:53").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_entries", Help: "Entries"})
+	gauge.Set(7)
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_rtt_seconds", Help: "RTT", Buckets: []float64{0.1, 1}})
+	hist.Observe(0.05)
+	hist.Observe(0.5)
+	hist.Observe(5)
+	registry.MustRegister(counter, gauge, hist)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	pusher, err := NewOTLPPusher(server.URL+"/v1/metrics", registry, time.Hour, log.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, pusher.Push(context.Background()))
+
+	var req collectorpb.ExportMetricsServiceRequest
+	require.NoError(t, proto.Unmarshal(body, &req))
+	require.Len(t, req.ResourceMetrics, 1)
+	require.Len(t, req.ResourceMetrics[0].ScopeMetrics, 1)
+	assert.Equal(t, "service.name", req.ResourceMetrics[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "roger", req.ResourceMetrics[0].Resource.Attributes[0].Value.GetStringValue())
+
+	metrics := make(map[string]*metricpb.Metric)
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	queries := metrics["test_queries_total"].GetSum()
+	require.NotNil(t, queries)
+	assert.True(t, queries.IsMonotonic)
+	assert.Equal(t, metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, queries.AggregationTemporality)
+	require.Len(t, queries.DataPoints, 1)
+	assert.Equal(t, float64(3), queries.DataPoints[0].GetAsDouble())
+	assert.Equal(t, "server", queries.DataPoints[0].Attributes[0].Key)
+	assert.Equal(t, "10.0.0.1:53", queries.DataPoints[0].Attributes[0].Value.GetStringValue())
+
+	entries := metrics["test_entries"].GetGauge()
+	require.NotNil(t, entries)
+	assert.Equal(t, float64(7), entries.DataPoints[0].GetAsDouble())
+	assert.Equal(t, "Entries", metrics["test_entries"].Description)
+
+	rtt := metrics["test_rtt_seconds"].GetHistogram()
+	require.NotNil(t, rtt)
+	assert.Equal(t, uint64(3), rtt.DataPoints[0].Count)
+	assert.Equal(t, []float64{0.1, 1}, rtt.DataPoints[0].ExplicitBounds)
+	assert.Equal(t, []uint64{1, 1, 1}, rtt.DataPoints[0].BucketCounts)
+}
+
+func TestOTLPPusher_PushError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_entries", Help: "Entries"}))
+
+	pusher, err := NewOTLPPusher(server.URL, registry, time.Hour, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Error(t, pusher.Push(context.Background()))
+}
@@ -0,0 +1,189 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// collect metrics in the background instead of when scraped
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// pollJitter is the fraction of the poll interval that each delay between
+	// polls is randomly adjusted by, up or down.
+	pollJitter = 0.1
+
+	// maxBackoffLevel is the most times the poll interval is doubled while
+	// collection keeps failing.
+	maxBackoffLevel = 5
+)
+
+// ErrorCollector is a prometheus.Collector that can report when collection fails.
+type ErrorCollector interface {
+	prometheus.Collector
+	CollectWithError(ch chan<- prometheus.Metric) error
+}
+
+// Warmup collects metrics from the collector once, discarding them. This populates
+// any lazily built state of the collector (such as descriptions of dynamically named
+// metrics) so that the first real scrape isn't slower than the rest.
+func Warmup(collector ErrorCollector) error {
+	ch := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- collector.CollectWithError(ch)
+		close(ch)
+	}()
+
+	for range ch {
+	}
+
+	return <-errCh
+}
+
+// Poller collects metrics from another collector in the background on a fixed
+// interval and emits the most recently collected metrics when collected itself.
+// When collection fails, the interval is doubled (up to 2^maxBackoffLevel times)
+// until collection succeeds again to avoid hammering a struggling DNS server.
+type Poller struct {
+	name       string
+	collector  ErrorCollector
+	interval   time.Duration
+	random     func() float64
+	logger     log.Logger
+	backoffDsc *prometheus.Desc
+	hitsDsc    *prometheus.Desc
+	hits       atomic.Uint64
+	start      time.Time
+
+	lock    sync.RWMutex
+	metrics []prometheus.Metric
+	polled  bool
+	backoff int
+}
+
+func NewPoller(name string, collector ErrorCollector, interval time.Duration, logger log.Logger) *Poller {
+	return &Poller{
+		name:      name,
+		collector: collector,
+		interval:  interval,
+		random:    rand.Float64,
+		logger:    logger,
+		start:     time.Now(),
+		backoffDsc: prometheus.NewDesc(
+			"roger_collect_backoff_level",
+			"Number of times the background collection interval has been doubled due to failures",
+			nil,
+			prometheus.Labels{"collector": name},
+		),
+		hitsDsc: prometheus.NewDesc(
+			"roger_collect_cache_hit_total",
+			"Number of scrapes served from metrics collected in the background instead of collecting them",
+			nil,
+			prometheus.Labels{"collector": name},
+		),
+	}
+}
+
+func (p *Poller) Describe(ch chan<- *prometheus.Desc) {
+	p.collector.Describe(ch)
+	ch <- p.backoffDsc
+	ch <- p.hitsDsc
+}
+
+func (p *Poller) Collect(ch chan<- prometheus.Metric) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for _, m := range p.metrics {
+		ch <- m
+	}
+
+	// Scrapes before the first poll has finished have nothing to reuse
+	hits := p.hits.Load()
+	if p.polled {
+		hits = p.hits.Add(1)
+	}
+
+	ch <- prometheus.MustNewConstMetric(p.backoffDsc, prometheus.GaugeValue, float64(p.backoff))
+	ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(p.hitsDsc, prometheus.CounterValue, float64(hits), p.start)
+}
+
+// Run polls the collector until the context is canceled.
+func (p *Poller) Run(ctx context.Context) {
+	for {
+		p.Poll()
+
+		timer := time.NewTimer(p.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// Poll collects metrics from the underlying collector, replacing any previously
+// collected metrics and adjusting the backoff level based on the result.
+func (p *Poller) Poll() {
+	ch := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- p.collector.CollectWithError(ch)
+		close(ch)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	err := <-errCh
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.metrics = metrics
+	p.polled = true
+	if err != nil {
+		if p.backoff < maxBackoffLevel {
+			p.backoff++
+		}
+
+		level.Error(p.logger).Log("msg", "background collection failed", "collector", p.name, "backoff", p.backoff, "err", err)
+	} else {
+		p.backoff = 0
+	}
+}
+
+// nextDelay returns how long to wait before the next poll based on the current
+// backoff level, adjusted by a random jitter so that many instances of Roger
+// polling the same server don't end up synchronized.
+func (p *Poller) nextDelay() time.Duration {
+	p.lock.RLock()
+	backoff := p.backoff
+	p.lock.RUnlock()
+
+	delay := p.interval * time.Duration(1<<backoff)
+	jitter := (p.random()*2 - 1) * pollJitter * float64(delay)
+	return delay + time.Duration(jitter)
+}
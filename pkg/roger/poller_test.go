@@ -0,0 +1,157 @@
+package roger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCollector struct {
+	desc  *prometheus.Desc
+	value float64
+	err   error
+}
+
+func newMockCollector() *mockCollector {
+	return &mockCollector{desc: prometheus.NewDesc("roger_test_value", "Test value", nil, nil)}
+}
+
+func (m *mockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+func (m *mockCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = m.CollectWithError(ch)
+}
+
+func (m *mockCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.value)
+	return nil
+}
+
+func TestPoller_Collect(t *testing.T) {
+	t.Run("before first poll", func(t *testing.T) {
+		poller := NewPoller("test", newMockCollector(), time.Second, log.NewNopLogger())
+		assert.Equal(t, 0, testutil.CollectAndCount(poller, "roger_test_value"))
+	})
+
+	t.Run("cached values", func(t *testing.T) {
+		mock := newMockCollector()
+		mock.value = 1
+		poller := NewPoller("test", mock, time.Second, log.NewNopLogger())
+		poller.Poll()
+
+		mock.value = 2
+		assert.Equal(t, float64(1), testutil.ToFloat64(filterCollector{poller, mock.desc}))
+
+		poller.Poll()
+		assert.Equal(t, float64(2), testutil.ToFloat64(filterCollector{poller, mock.desc}))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		mock := newMockCollector()
+		poller := NewPoller("test", mock, time.Second, log.NewNopLogger())
+		poller.Poll()
+
+		mock.err = errors.New("collection failed")
+		poller.Poll()
+		assert.Equal(t, 0, testutil.CollectAndCount(poller, "roger_test_value"))
+		assert.Equal(t, 1, poller.backoff)
+	})
+
+	t.Run("cache hits", func(t *testing.T) {
+		poller := NewPoller("test", newMockCollector(), time.Second, log.NewNopLogger())
+		assert.Equal(t, float64(0), testutil.ToFloat64(filterCollector{poller, poller.hitsDsc}))
+
+		poller.Poll()
+		assert.Equal(t, float64(1), testutil.ToFloat64(filterCollector{poller, poller.hitsDsc}))
+		assert.Equal(t, float64(2), testutil.ToFloat64(filterCollector{poller, poller.hitsDsc}))
+	})
+}
+
+func TestPoller_NextDelay(t *testing.T) {
+	mock := newMockCollector()
+	mock.err = errors.New("collection failed")
+	poller := NewPoller("test", mock, 10*time.Second, log.NewNopLogger())
+
+	t.Run("jitter", func(t *testing.T) {
+		poller.random = func() float64 { return 0 }
+		assert.Equal(t, 9*time.Second, poller.nextDelay())
+
+		poller.random = func() float64 { return 0.5 }
+		assert.Equal(t, 10*time.Second, poller.nextDelay())
+
+		poller.random = func() float64 { return 1 }
+		assert.Equal(t, 11*time.Second, poller.nextDelay())
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		poller.random = func() float64 { return 0.5 }
+
+		poller.Poll()
+		assert.Equal(t, 20*time.Second, poller.nextDelay())
+
+		poller.Poll()
+		assert.Equal(t, 40*time.Second, poller.nextDelay())
+
+		for i := 0; i < 10; i++ {
+			poller.Poll()
+		}
+		assert.Equal(t, 320*time.Second, poller.nextDelay())
+
+		mock.err = nil
+		poller.Poll()
+		assert.Equal(t, 10*time.Second, poller.nextDelay())
+	})
+}
+
+// filterCollector only emits metrics from the wrapped collector matching desc.
+type filterCollector struct {
+	collector prometheus.Collector
+	desc      *prometheus.Desc
+}
+
+func (f filterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+func (f filterCollector) Collect(ch chan<- prometheus.Metric) {
+	all := make(chan prometheus.Metric)
+	go func() {
+		f.collector.Collect(all)
+		close(all)
+	}()
+
+	for m := range all {
+		if m.Desc() == f.desc {
+			ch <- m
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/dev", netDevFixture)
+		reader := NewProcNetDevReader(base, log.NewNopLogger())
+
+		assert.NoError(t, Warmup(reader))
+		assert.Equal(t, 16, reader.descriptions.len())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		mock := newMockCollector()
+		mock.err = errors.New("collection failed")
+
+		assert.Error(t, Warmup(mock))
+	})
+}
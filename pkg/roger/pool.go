@@ -0,0 +1,145 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// collect dnsmasq metrics from many servers with shared state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultPoolConcurrency is the default number of servers a DnsmasqPool queries at once.
+const DefaultPoolConcurrency = 10
+
+// DnsmasqPool collects metrics from many DNS servers, using a single client unless
+// servers are added with their own options. The readers for each server share metric
+// descriptions so that each additional server
+// only adds the state kept between collections, such as running totals.
+type DnsmasqPool struct {
+	// MaxConcurrency is the most servers queried at once during a collection, 0 for no
+	// limit. Servers beyond the limit wait for another server to finish. Defaults to
+	// DefaultPoolConcurrency. Ignored if Workers is set.
+	MaxConcurrency int
+
+	// Workers, if set, limits how many servers are queried at once instead of
+	// MaxConcurrency. Pools sharing the same workers share the limit, so that it
+	// applies to all servers regardless of how many pools they're split into.
+	Workers *CollectWorkers
+
+	client       dnsClient
+	descriptions *descriptions
+	extraDescs   *descriptionCache
+	logger       log.Logger
+
+	lock    sync.RWMutex
+	readers []*DnsmasqReader
+}
+
+func NewDnsmasqPool(client dnsClient, logger log.Logger) *DnsmasqPool {
+	return &DnsmasqPool{
+		MaxConcurrency: DefaultPoolConcurrency,
+		client:         client,
+		descriptions:   newDescriptions(),
+		extraDescs:     newDescriptionCache(),
+		logger:         logger,
+	}
+}
+
+// Add creates a reader for the server at address using the client of the pool,
+// returning it so that its settings can be changed. Settings must be changed before
+// the pool is registered. Options for the client, such as the protocols, are ignored.
+func (p *DnsmasqPool) Add(address string, opts ...DnsmasqOption) *DnsmasqReader {
+	reader := newDnsmasqReader(p.client, address, p.descriptions, p.extraDescs, p.logger)
+	newDnsmasqOptions(opts).configure(reader)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.readers = append(p.readers, reader)
+	return reader
+}
+
+// AddWithOptions creates a reader for the server at address with its own client
+// created from the options, see NewDnsmasqReaderWithOptions. This allows settings
+// such as the TLS server name to differ between servers while still sharing metric
+// descriptions.
+func (p *DnsmasqPool) AddWithOptions(address string, opts ...DnsmasqOption) *DnsmasqReader {
+	reader := newDnsmasqReaderWithOptions(address, p.descriptions, p.extraDescs, p.logger, opts)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.readers = append(p.readers, reader)
+	return reader
+}
+
+// Readers returns the reader for each server in the order they were added.
+func (p *DnsmasqPool) Readers() []*DnsmasqReader {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	out := make([]*DnsmasqReader, len(p.readers))
+	copy(out, p.readers)
+	return out
+}
+
+func (p *DnsmasqPool) Describe(ch chan<- *prometheus.Desc) {
+	// Shared descriptions are sent once per reader. That's fine since the registry
+	// ignores duplicate descriptions from the same collector.
+	for _, r := range p.Readers() {
+		r.Describe(ch)
+	}
+}
+
+func (p *DnsmasqPool) Collect(ch chan<- prometheus.Metric) {
+	if err := p.CollectWithError(ch); err != nil {
+		level.Error(p.logger).Log("msg", "failed to read dnsmasq metrics from some servers during collection", "err", err)
+	}
+}
+
+// CollectWithError queries servers concurrently, up to MaxConcurrency at once or as
+// many as Workers allows, and emits metrics, returning errors for each of the servers
+// that could not be queried or parsed.
+func (p *DnsmasqPool) CollectWithError(ch chan<- prometheus.Metric) error {
+	readers := p.Readers()
+	errs := make([]error, len(readers))
+
+	workers := p.Workers
+	if workers == nil {
+		workers = NewCollectWorkers(p.MaxConcurrency)
+	}
+
+	// Servers are started in the order they were added as others finish
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		release := workers.acquire()
+		wg.Add(1)
+		go func(i int, r *DnsmasqReader) {
+			defer func() {
+				release()
+				wg.Done()
+			}()
+
+			if err := r.CollectWithError(ch); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", r.address, err)
+			}
+		}(i, r)
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,250 @@
+package roger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticDNSClient returns the same answers for every query, failing for any of the
+// addresses in failing. Unlike mockDNSClient it's safe to use concurrently.
+type staticDNSClient struct {
+	msg     *dns.Msg
+	failing map[string]bool
+}
+
+func (c *staticDNSClient) Exchange(q *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	if c.failing[address] {
+		return nil, 0, errors.New("connection refused")
+	}
+
+	var msg dns.Msg
+	msg.Question = q.Question
+	msg.Answer = c.msg.Answer
+	return &msg, 1 * time.Millisecond, nil
+}
+
+// concurrencyDNSClient answers every query after a delay, keeping track of the most
+// queries that were in flight at once.
+type concurrencyDNSClient struct {
+	staticDNSClient
+	delay    time.Duration
+	inFlight atomic.Int32
+	max      atomic.Int32
+}
+
+func (c *concurrencyDNSClient) Exchange(q *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	n := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	for {
+		max := c.max.Load()
+		if n <= max || c.max.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	time.Sleep(c.delay)
+	return c.staticDNSClient.Exchange(q, address)
+}
+
+func TestDnsmasqPool_Add(t *testing.T) {
+	pool := NewDnsmasqPool(&staticDNSClient{msg: statsMsg("1", "2", "3")}, log.NewNopLogger())
+	first := pool.Add("10.0.0.1:53")
+	second := pool.Add("10.0.0.2:53", WithProtocol(ProtocolTCP), WithRetries(1), WithChaosSuffix("server"))
+
+	assert.Equal(t, []*DnsmasqReader{first, second}, pool.Readers())
+	assert.Same(t, first.descriptions, second.descriptions)
+	assert.Same(t, first.extraDescs, second.extraDescs)
+	assert.Same(t, first.client, second.client)
+	assert.Equal(t, 0, first.Retries)
+	assert.Equal(t, ".bind.", first.ChaosSuffix)
+	assert.Equal(t, 1, second.Retries)
+	assert.Equal(t, ".server.", second.ChaosSuffix)
+}
+
+func TestDnsmasqPool_AddWithOptions(t *testing.T) {
+	pool := NewDnsmasqPool(nil, log.NewNopLogger())
+	first := pool.AddWithOptions("10.0.0.1:853", WithProtocol(ProtocolTLS))
+	second := pool.AddWithOptions("10.0.0.2:853", WithProtocol(ProtocolTLS), WithRetries(1))
+
+	assert.Same(t, first.descriptions, second.descriptions)
+	assert.NotSame(t, first.client, second.client)
+	assert.Equal(t, 0, first.Retries)
+	assert.Equal(t, 1, second.Retries)
+
+	// Each server gets its own client so the TLS server name matches its address
+	assert.Equal(t, "10.0.0.2", second.client.(*FallbackClient).transports[0].client.(*dns.Client).TLSConfig.ServerName)
+}
+
+func TestDnsmasqPool_Collect(t *testing.T) {
+	t.Run("all servers", func(t *testing.T) {
+		pool := NewDnsmasqPool(&staticDNSClient{msg: statsMsg("1", "2", "3")}, log.NewNopLogger())
+		pool.Add("10.0.0.1:53")
+		pool.Add("10.0.0.2:53")
+
+		reg := prometheus.NewPedanticRegistry()
+		require.NoError(t, reg.Register(pool))
+
+		count, err := testutil.GatherAndCount(reg, "roger_dns_cache_hits_total")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("some servers failing", func(t *testing.T) {
+		client := &staticDNSClient{msg: statsMsg("1", "2", "3"), failing: map[string]bool{"10.0.0.2:53": true}}
+		pool := NewDnsmasqPool(client, log.NewNopLogger())
+		pool.Add("10.0.0.1:53")
+		pool.Add("10.0.0.2:53")
+
+		ch := make(chan prometheus.Metric)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- pool.CollectWithError(ch)
+			close(ch)
+		}()
+
+		var hits int
+		for m := range ch {
+			if m.Desc() == pool.descriptions.dnsCacheHits {
+				hits++
+			}
+		}
+
+		err := <-errCh
+		assert.ErrorIs(t, err, ErrUpstream)
+		assert.ErrorContains(t, err, "10.0.0.2:53")
+		assert.Equal(t, 1, hits)
+	})
+
+	t.Run("failing servers don't affect others", func(t *testing.T) {
+		client := &staticDNSClient{msg: statsMsg("1", "2", "3"), failing: map[string]bool{"10.0.0.2:53": true}}
+		pool := NewDnsmasqPool(client, log.NewNopLogger())
+		pool.Add("10.0.0.1:53")
+		pool.Add("10.0.0.2:53")
+		pool.Add("10.0.0.3:53")
+
+		reg := prometheus.NewPedanticRegistry()
+		require.NoError(t, reg.Register(pool))
+
+		expected := `
+# HELP roger_dns_cache_hits_total Number of hits in the DNS cache
+# TYPE roger_dns_cache_hits_total counter
+roger_dns_cache_hits_total{server="10.0.0.1:53"} 1
+roger_dns_cache_hits_total{server="10.0.0.3:53"} 1
+# HELP roger_dns_up If the most recent attempt to read metrics from the DNS server succeeded (1) or not (0)
+# TYPE roger_dns_up gauge
+roger_dns_up{server="10.0.0.1:53"} 1
+roger_dns_up{server="10.0.0.2:53"} 0
+roger_dns_up{server="10.0.0.3:53"} 1
+`
+		assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+			"roger_dns_cache_hits_total", "roger_dns_up"))
+	})
+}
+
+func TestDnsmasqPool_MaxConcurrency(t *testing.T) {
+	newPool := func(client *concurrencyDNSClient, servers int) *DnsmasqPool {
+		pool := NewDnsmasqPool(client, log.NewNopLogger())
+		for i := 0; i < servers; i++ {
+			pool.Add(fmt.Sprintf("10.0.0.%d:53", i+1))
+		}
+
+		return pool
+	}
+
+	t.Run("limited", func(t *testing.T) {
+		client := &concurrencyDNSClient{staticDNSClient: staticDNSClient{msg: statsMsg("1", "2", "3")}, delay: 5 * time.Millisecond}
+		pool := newPool(client, 12)
+		pool.MaxConcurrency = 3
+
+		require.NoError(t, pool.CollectWithError(make(chan prometheus.Metric, 4096)))
+		assert.LessOrEqual(t, client.max.Load(), int32(3))
+		assert.Greater(t, client.max.Load(), int32(1))
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		client := &concurrencyDNSClient{staticDNSClient: staticDNSClient{msg: statsMsg("1", "2", "3")}, delay: 50 * time.Millisecond}
+		pool := newPool(client, 12)
+		pool.MaxConcurrency = 0
+
+		require.NoError(t, pool.CollectWithError(make(chan prometheus.Metric, 4096)))
+		assert.Greater(t, client.max.Load(), int32(DefaultPoolConcurrency/2))
+	})
+
+	t.Run("every server collected", func(t *testing.T) {
+		client := &concurrencyDNSClient{staticDNSClient: staticDNSClient{msg: statsMsg("1", "2", "3")}}
+		pool := newPool(client, 12)
+		pool.MaxConcurrency = 1
+
+		assert.Equal(t, 12, testutil.CollectAndCount(pool, "roger_dns_up"))
+		assert.Equal(t, int32(1), client.max.Load())
+	})
+
+	t.Run("workers shared between pools", func(t *testing.T) {
+		client := &concurrencyDNSClient{staticDNSClient: staticDNSClient{msg: statsMsg("1", "2", "3")}, delay: 5 * time.Millisecond}
+		workers := NewCollectWorkers(3)
+		first := newPool(client, 6)
+		first.Workers = workers
+		second := newPool(client, 6)
+		second.Workers = workers
+
+		var wg sync.WaitGroup
+		for _, pool := range []*DnsmasqPool{first, second} {
+			wg.Add(1)
+			go func(pool *DnsmasqPool) {
+				defer wg.Done()
+				assert.NoError(t, pool.CollectWithError(make(chan prometheus.Metric, 4096)))
+			}(pool)
+		}
+
+		wg.Wait()
+		assert.LessOrEqual(t, client.max.Load(), int32(3))
+	})
+}
+
+func BenchmarkDnsmasqPool_Collect(b *testing.B) {
+	const servers = 50
+	client := &staticDNSClient{msg: statsMsg("1", "2", "3")}
+
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+
+		pool := NewDnsmasqPool(client, log.NewNopLogger())
+		for i := 0; i < servers; i++ {
+			pool.Add(fmt.Sprintf("10.0.0.%d:53", i+1))
+		}
+
+		for i := 0; i < b.N; i++ {
+			testutil.CollectAndCount(pool)
+		}
+	})
+
+	b.Run("readers", func(b *testing.B) {
+		b.ReportAllocs()
+
+		// Separate readers can't be registered together since their descriptions
+		// conflict so they're each collected from directly.
+		readers := make([]*DnsmasqReader, servers)
+		for i := range readers {
+			readers[i] = NewDnsmasqReader(client, fmt.Sprintf("10.0.0.%d:53", i+1), log.NewNopLogger())
+		}
+
+		for i := 0; i < b.N; i++ {
+			for _, r := range readers {
+				testutil.CollectAndCount(r)
+			}
+		}
+	})
+}
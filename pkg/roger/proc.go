@@ -0,0 +1,180 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// helpers shared by the /proc readers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// procErrorLogInterval is how often errors are logged while reading /proc entries
+// keeps failing on each scrape.
+const procErrorLogInterval = 1 * time.Minute
+
+// procCheckLines is how many lines of a /proc file are read between checks of
+// whether collection has been canceled or its deadline exceeded.
+const procCheckLines = 1000
+
+// procScanErr returns the error of the context if it's done, only checking every
+// procCheckLines lines so that files with millions of lines (such as the conntrack
+// table) aren't slowed down. The context is always checked before the first line.
+func procScanErr(ctx context.Context, line int) error {
+	if line%procCheckLines != 0 {
+		return nil
+	}
+
+	return ctx.Err()
+}
+
+// collectContext returns the context for reading a /proc file during a collection,
+// with a deadline if timeout is positive.
+func collectContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// isProcGone returns true if the error indicates that a /proc entry went away
+// while being read, e.g. because the process it belongs to exited between checking
+// that it exists and opening it. This is expected to happen occasionally and isn't
+// treated as a failure.
+func isProcGone(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH)
+}
+
+// descriptionCache holds descriptions of metrics whose names are only known after
+// parsing a /proc file. Once every metric has been seen, the set of descriptions stops
+// changing so lookups are lock-free. Adding a description takes a lock and replaces the
+// map with an updated copy (copy-on-write).
+type descriptionCache struct {
+	lock         sync.Mutex
+	descriptions atomic.Pointer[map[string]*prometheus.Desc]
+}
+
+func newDescriptionCache() *descriptionCache {
+	c := &descriptionCache{}
+	empty := make(map[string]*prometheus.Desc)
+	c.descriptions.Store(&empty)
+	return c
+}
+
+// get returns the description for the named metric, creating it with the given
+// help text and variable labels if it doesn't exist yet.
+func (c *descriptionCache) get(name string, help string, labels []string) *prometheus.Desc {
+	if desc, ok := (*c.descriptions.Load())[name]; ok {
+		return desc
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check again in case another collection added it while we waited for the lock
+	current := *c.descriptions.Load()
+	if desc, ok := current[name]; ok {
+		return desc
+	}
+
+	next := make(map[string]*prometheus.Desc, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+
+	desc := prometheus.NewDesc(name, help, labels, nil)
+	next[name] = desc
+	c.descriptions.Store(&next)
+	return desc
+}
+
+// newDescriptionCountDesc creates the description of the metric for the number of
+// descriptions cached by a reader.
+func newDescriptionCountDesc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		"roger_collector_descriptions",
+		"Number of metric descriptions cached by the collector. Steady growth indicates label churn",
+		[]string{"collector"},
+		nil,
+	)
+}
+
+// newProcFileMtimeDesc creates the description of the metric for the modification time
+// of a /proc file read by a reader.
+func newProcFileMtimeDesc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		"roger_proc_file_mtime_seconds",
+		"Modification time of the /proc file metrics were read from, in seconds since the epoch. procfs doesn't update it when contents change, it's only meaningful when reading a copy of /proc such as a snapshot",
+		[]string{"file"},
+		nil,
+	)
+}
+
+func (c *descriptionCache) len() int {
+	return len(*c.descriptions.Load())
+}
+
+// errorLogLimiter limits how often repeated collection errors are logged so that a
+// file being unreadable for a while doesn't log an error on every scrape. The first
+// error is logged, then at most one per interval while failures continue, and then
+// recovery once collection succeeds again.
+type errorLogLimiter struct {
+	interval time.Duration
+	now      func() time.Time
+
+	lock       sync.Mutex
+	failing    bool
+	lastLogged time.Time
+	suppressed int
+}
+
+func newErrorLogLimiter(interval time.Duration) *errorLogLimiter {
+	return &errorLogLimiter{interval: interval, now: time.Now}
+}
+
+// failed records a collection error and returns true if it should be logged along
+// with the number of errors that weren't logged since the last one that was.
+func (l *errorLogLimiter) failed() (bool, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	if l.failing && now.Sub(l.lastLogged) < l.interval {
+		l.suppressed++
+		return false, 0
+	}
+
+	suppressed := l.suppressed
+	l.failing = true
+	l.lastLogged = now
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// recovered records a successful collection and returns true if the previous
+// collection failed, meaning that recovery should be logged.
+func (l *errorLogLimiter) recovered() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	wasFailing := l.failing
+	l.failing = false
+	l.suppressed = 0
+	return wasFailing
+}
@@ -0,0 +1,118 @@
+package roger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsProcGone(t *testing.T) {
+	assert.True(t, isProcGone(os.ErrNotExist))
+	assert.True(t, isProcGone(fmt.Errorf("wrapped: %w", syscall.ENOENT)))
+	assert.True(t, isProcGone(&os.PathError{Op: "open", Path: "/proc/1234/stat", Err: syscall.ESRCH}))
+	assert.False(t, isProcGone(errors.New("unexpected header line format")))
+	assert.False(t, isProcGone(nil))
+}
+
+func TestDescriptionCache(t *testing.T) {
+	cache := newDescriptionCache()
+	first := cache.get("roger_test_a", "help", nil)
+	second := cache.get("roger_test_a", "help", nil)
+	other := cache.get("roger_test_b", "help", nil)
+
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, other)
+	assert.Equal(t, 2, cache.len())
+}
+
+// mutexDescriptionCache is the previous approach of locking around every lookup,
+// used as a baseline for comparison with descriptionCache.
+type mutexDescriptionCache struct {
+	lock         sync.Mutex
+	descriptions map[string]*prometheus.Desc
+}
+
+func (c *mutexDescriptionCache) get(name string, help string, labels []string) *prometheus.Desc {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	desc, ok := c.descriptions[name]
+	if !ok {
+		desc = prometheus.NewDesc(name, help, labels, nil)
+		c.descriptions[name] = desc
+	}
+
+	return desc
+}
+
+func benchmarkNames() []string {
+	names := make([]string, 16)
+	for i := range names {
+		names[i] = fmt.Sprintf("roger_net_rx_value_%d", i)
+	}
+	return names
+}
+
+func BenchmarkDescriptionCache_Mutex(b *testing.B) {
+	cache := &mutexDescriptionCache{descriptions: make(map[string]*prometheus.Desc)}
+	names := benchmarkNames()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, n := range names {
+				cache.get(n, "help", []string{"interface"})
+			}
+		}
+	})
+}
+
+func BenchmarkDescriptionCache_CopyOnWrite(b *testing.B) {
+	cache := newDescriptionCache()
+	names := benchmarkNames()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, n := range names {
+				cache.get(n, "help", []string{"interface"})
+			}
+		}
+	})
+}
+
+func TestErrorLogLimiter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newErrorLogLimiter(time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	assert.False(t, limiter.recovered())
+
+	ok, suppressed := limiter.failed()
+	assert.True(t, ok)
+	assert.Equal(t, 0, suppressed)
+
+	now = now.Add(10 * time.Second)
+	ok, _ = limiter.failed()
+	assert.False(t, ok)
+	ok, _ = limiter.failed()
+	assert.False(t, ok)
+
+	now = now.Add(time.Minute)
+	ok, suppressed = limiter.failed()
+	assert.True(t, ok)
+	assert.Equal(t, 2, suppressed)
+
+	assert.True(t, limiter.recovered())
+	assert.False(t, limiter.recovered())
+
+	// Failing again after recovering is logged right away
+	ok, suppressed = limiter.failed()
+	assert.True(t, ok)
+	assert.Equal(t, 0, suppressed)
+}
@@ -0,0 +1,299 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// read DNS server process stats from /proc/<pid>
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// userHZ is the number of clock ticks per second used for CPU times in
+// /proc/<pid>/stat. This is fixed at 100 on all architectures Roger runs on.
+const userHZ = 100
+
+type processDescriptions struct {
+	cpuSeconds          *prometheus.Desc
+	residentMemoryBytes *prometheus.Desc
+	openFDs             *prometheus.Desc
+	threads             *prometheus.Desc
+}
+
+func newProcessDescriptions() *processDescriptions {
+	return &processDescriptions{
+		cpuSeconds: prometheus.NewDesc(
+			"roger_dns_process_cpu_seconds_total",
+			"Total user and system CPU time spent by the DNS server process in seconds",
+			nil,
+			nil,
+		),
+		residentMemoryBytes: prometheus.NewDesc(
+			"roger_dns_process_resident_memory_bytes",
+			"Resident memory size of the DNS server process in bytes",
+			nil,
+			nil,
+		),
+		openFDs: prometheus.NewDesc(
+			"roger_dns_process_open_fds",
+			"Number of open file descriptors of the DNS server process",
+			nil,
+			nil,
+		),
+		threads: prometheus.NewDesc(
+			"roger_dns_process_threads",
+			"Number of threads of the DNS server process",
+			nil,
+			nil,
+		),
+	}
+}
+
+type ProcessResult struct {
+	CPUSeconds          float64
+	ResidentMemoryBytes uint64
+	Threads             uint64
+	// OpenFDs is nil when the file descriptors of the process could not
+	// be listed, typically because Roger runs as a different user.
+	OpenFDs *uint64
+}
+
+type ProcessReader struct {
+	base         string
+	pid          int
+	pidFile      string
+	descriptions *processDescriptions
+	created      *createdTimes
+	logger       log.Logger
+	errLog       *errorLogLimiter
+}
+
+// NewProcessReader creates a reader for stats of the DNS server process. The
+// process is identified by pid or, if pidFile is non-empty, by the pid stored
+// in pidFile which is re-read on each collection to handle server restarts.
+func NewProcessReader(base string, pid int, pidFile string, logger log.Logger) *ProcessReader {
+	return &ProcessReader{
+		base:         base,
+		pid:          pid,
+		pidFile:      pidFile,
+		descriptions: newProcessDescriptions(),
+		created:      newCreatedTimes(),
+		logger:       logger,
+		errLog:       newErrorLogLimiter(procErrorLogInterval),
+	}
+}
+
+func (p *ProcessReader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.descriptions.cpuSeconds
+	ch <- p.descriptions.residentMemoryBytes
+	ch <- p.descriptions.openFDs
+	ch <- p.descriptions.threads
+}
+
+func (p *ProcessReader) Collect(ch chan<- prometheus.Metric) {
+	err := p.CollectWithError(ch)
+	if err == nil {
+		if p.errLog.recovered() {
+			level.Info(p.logger).Log("msg", "DNS process metrics collected successfully after failures")
+		}
+
+		return
+	}
+
+	if ok, suppressed := p.errLog.failed(); ok {
+		level.Error(p.logger).Log("msg", "failed to read DNS process metrics during collection", "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits metrics for the DNS server process, returning an
+// error if its proc entries could not be read. A process that has exited is
+// not considered an error.
+func (p *ProcessReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	res, err := p.ReadMetrics()
+	if isProcGone(err) {
+		level.Debug(p.logger).Log("msg", "DNS process went away during collection", "err", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	ch <- p.created.counter(p.descriptions.cpuSeconds, res.CPUSeconds)
+	ch <- prometheus.MustNewConstMetric(p.descriptions.residentMemoryBytes, prometheus.GaugeValue, float64(res.ResidentMemoryBytes))
+	ch <- prometheus.MustNewConstMetric(p.descriptions.threads, prometheus.GaugeValue, float64(res.Threads))
+
+	if res.OpenFDs != nil {
+		ch <- prometheus.MustNewConstMetric(p.descriptions.openFDs, prometheus.GaugeValue, float64(*res.OpenFDs))
+	}
+
+	return nil
+}
+
+// Exists returns true if a pid or pid file has been provided and the proc
+// entries for the process exist.
+func (p *ProcessReader) Exists() bool {
+	if p.pid == 0 && p.pidFile == "" {
+		return false
+	}
+
+	dir, err := p.procDir()
+	if err != nil {
+		return false
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "stat")); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
+
+func (p *ProcessReader) ReadMetrics() (*ProcessResult, error) {
+	dir, err := p.procDir()
+	if err != nil {
+		return nil, err
+	}
+
+	cpuSeconds, err := readProcessCPU(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	rss, threads, err := readProcessStatus(filepath.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ProcessResult{
+		CPUSeconds:          cpuSeconds,
+		ResidentMemoryBytes: rss,
+		Threads:             threads,
+	}
+
+	// Listing the fd directory requires the same user as the process (or root)
+	// so don't fail the entire collection if we aren't able to.
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		level.Debug(p.logger).Log("msg", "unable to count open fds of DNS process", "path", dir, "err", err)
+	} else {
+		count := uint64(len(fds))
+		res.OpenFDs = &count
+	}
+
+	return res, nil
+}
+
+// Pid returns the pid of the DNS process, read from the pid file if one was given.
+func (p *ProcessReader) Pid() (int, error) {
+	if p.pidFile == "" {
+		return p.pid, nil
+	}
+
+	contents, err := os.ReadFile(p.pidFile)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid in %s: %w", p.pidFile, err)
+	}
+
+	return pid, nil
+}
+
+func (p *ProcessReader) procDir() (string, error) {
+	pid, err := p.Pid()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(p.base, strconv.Itoa(pid)), nil
+}
+
+func readProcessCPU(path string) (float64, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// The second field is the command name in parens which may contain spaces
+	// so start parsing fields after the closing paren. The first field after it
+	// is the process state (field 3 in proc(5)), utime and stime are 14 and 15.
+	stat := string(contents)
+	end := strings.LastIndex(stat, ")")
+	if end == -1 {
+		return 0, fmt.Errorf("unexpected stat format in %s", path)
+	}
+
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("expected at least 13 stat fields, got %d from %s", len(fields), path)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(utime+stime) / userHZ, nil
+}
+
+func readProcessStatus(path string) (uint64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var (
+		rss     uint64
+		threads uint64
+	)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "VmRSS:":
+			// Reported in kB, despite what the unit suffix says it's actually KiB
+			rss, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			rss *= 1024
+		case "Threads:":
+			threads, err = strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return rss, threads, scanner.Err()
+}
@@ -0,0 +1,166 @@
+package roger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const processStat = "1234 (dns masq) S 1 1234 1234 0 -1 4194560 2075 0 0 0 250 125 0 0 20 0 1 0 1686 10481664 397 18446744073709551615 1 1 0 0 0 0 0 4096 92675 0 0 0 17 2 0 0 0 0 0 0 0 0 0 0 0 0 0\n"
+
+const processStatus = `Name:	dnsmasq
+State:	S (sleeping)
+Pid:	1234
+VmRSS:	    1588 kB
+Threads:	1
+`
+
+func writeProcessFixture(t *testing.T, base string, pid string) {
+	dir := filepath.Join(base, pid)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(processStat), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "status"), []byte(processStatus), 0o644))
+
+	for _, fd := range []string{"0", "1", "2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "fd", fd), nil, 0o644))
+	}
+}
+
+func TestProcessReader_Exists(t *testing.T) {
+	t.Run("no pid or pid file", func(t *testing.T) {
+		reader := NewProcessReader(t.TempDir(), 0, "", log.NewNopLogger())
+		assert.False(t, reader.Exists())
+	})
+
+	t.Run("process missing", func(t *testing.T) {
+		reader := NewProcessReader(t.TempDir(), 1234, "", log.NewNopLogger())
+		assert.False(t, reader.Exists())
+	})
+
+	t.Run("process exists", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcessFixture(t, base, "1234")
+
+		reader := NewProcessReader(base, 1234, "", log.NewNopLogger())
+		assert.True(t, reader.Exists())
+	})
+}
+
+func TestProcessReader_Pid(t *testing.T) {
+	t.Run("pid", func(t *testing.T) {
+		pid, err := NewProcessReader(t.TempDir(), 1234, "", log.NewNopLogger()).Pid()
+		require.NoError(t, err)
+		assert.Equal(t, 1234, pid)
+	})
+
+	t.Run("pid file", func(t *testing.T) {
+		pidFile := filepath.Join(t.TempDir(), "dnsmasq.pid")
+		require.NoError(t, os.WriteFile(pidFile, []byte("5678\n"), 0o644))
+
+		pid, err := NewProcessReader(t.TempDir(), 0, pidFile, log.NewNopLogger()).Pid()
+		require.NoError(t, err)
+		assert.Equal(t, 5678, pid)
+	})
+
+	t.Run("invalid pid file", func(t *testing.T) {
+		pidFile := filepath.Join(t.TempDir(), "dnsmasq.pid")
+		require.NoError(t, os.WriteFile(pidFile, []byte("dnsmasq\n"), 0o644))
+
+		_, err := NewProcessReader(t.TempDir(), 0, pidFile, log.NewNopLogger()).Pid()
+		assert.Error(t, err)
+	})
+}
+
+func TestProcessReader_ReadMetrics(t *testing.T) {
+	t.Run("pid", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcessFixture(t, base, "1234")
+
+		reader := NewProcessReader(base, 1234, "", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Equal(t, 3.75, res.CPUSeconds)
+		assert.Equal(t, uint64(1588*1024), res.ResidentMemoryBytes)
+		assert.Equal(t, uint64(1), res.Threads)
+		require.NotNil(t, res.OpenFDs)
+		assert.Equal(t, uint64(3), *res.OpenFDs)
+	})
+
+	t.Run("pid file", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcessFixture(t, base, "1234")
+		pidFile := filepath.Join(t.TempDir(), "dnsmasq.pid")
+		require.NoError(t, os.WriteFile(pidFile, []byte("1234\n"), 0o644))
+
+		reader := NewProcessReader(base, 0, pidFile, log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Equal(t, 3.75, res.CPUSeconds)
+	})
+
+	t.Run("bad pid file", func(t *testing.T) {
+		pidFile := filepath.Join(t.TempDir(), "dnsmasq.pid")
+		require.NoError(t, os.WriteFile(pidFile, []byte("fail\n"), 0o644))
+
+		reader := NewProcessReader(t.TempDir(), 0, pidFile, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+
+		assert.Error(t, err)
+	})
+
+	t.Run("fds unavailable", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcessFixture(t, base, "1234")
+		require.NoError(t, os.RemoveAll(filepath.Join(base, "1234", "fd")))
+
+		reader := NewProcessReader(base, 1234, "", log.NewNopLogger())
+		res, err := reader.ReadMetrics()
+
+		require.NoError(t, err)
+		assert.Nil(t, res.OpenFDs)
+	})
+}
+
+func TestProcessReader_Collect(t *testing.T) {
+	t.Run("process exits between reads", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcessFixture(t, base, "1234")
+
+		var buf bytes.Buffer
+		logger := level.NewFilter(log.NewLogfmtLogger(&buf), level.AllowInfo())
+		reader := NewProcessReader(base, 1234, "", logger)
+		require.True(t, reader.Exists())
+
+		// The stat file exists but status has vanished by the time it's opened
+		require.NoError(t, os.Remove(filepath.Join(base, "1234", "status")))
+
+		_, err := reader.ReadMetrics()
+		assert.True(t, isProcGone(err))
+		assert.Equal(t, 0, testutil.CollectAndCount(reader))
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("parse failure", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcessFixture(t, base, "1234")
+		require.NoError(t, os.WriteFile(filepath.Join(base, "1234", "stat"), []byte("1234 (dnsmasq) S 1\n"), 0o644))
+
+		var buf bytes.Buffer
+		logger := level.NewFilter(log.NewLogfmtLogger(&buf), level.AllowInfo())
+		reader := NewProcessReader(base, 1234, "", logger)
+
+		_, err := reader.ReadMetrics()
+		assert.False(t, isProcGone(err))
+		assert.Equal(t, 0, testutil.CollectAndCount(reader))
+		assert.Contains(t, buf.String(), "failed to read DNS process metrics")
+	})
+}
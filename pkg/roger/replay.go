@@ -0,0 +1,64 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// set the timestamp of metrics read from captured snapshots
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TimestampedCollector wraps another collector, setting the timestamp of every metric
+// to a fixed time. This is used when replaying a snapshot of /proc or DNS stats captured
+// in the past so that the metrics are stored at the time of the capture instead of the
+// time of the scrape.
+type TimestampedCollector struct {
+	collector ErrorCollector
+	timestamp time.Time
+}
+
+func NewTimestampedCollector(collector ErrorCollector, timestamp time.Time) *TimestampedCollector {
+	return &TimestampedCollector{collector: collector, timestamp: timestamp}
+}
+
+func (t *TimestampedCollector) Describe(ch chan<- *prometheus.Desc) {
+	t.collector.Describe(ch)
+}
+
+func (t *TimestampedCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = t.collect(ch, func(inner chan<- prometheus.Metric) error {
+		// The wrapped collector logs its own errors
+		t.collector.Collect(inner)
+		return nil
+	})
+}
+
+func (t *TimestampedCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	return t.collect(ch, t.collector.CollectWithError)
+}
+
+func (t *TimestampedCollector) collect(ch chan<- prometheus.Metric, collect func(chan<- prometheus.Metric) error) error {
+	inner := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- collect(inner)
+		close(inner)
+	}()
+
+	for m := range inner {
+		ch <- prometheus.NewMetricWithTimestamp(t.timestamp, m)
+	}
+
+	return <-errCh
+}
@@ -0,0 +1,48 @@
+package roger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampedCollector_Collect(t *testing.T) {
+	ts := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	inner := newMockCollector()
+	inner.value = 42
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewTimestampedCollector(inner, ts))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	require.Len(t, families[0].Metric, 1)
+
+	m := families[0].Metric[0]
+	assert.Equal(t, 42.0, m.GetGauge().GetValue())
+	assert.Equal(t, ts.UnixMilli(), m.GetTimestampMs())
+}
+
+func TestTimestampedCollector_CollectWithError(t *testing.T) {
+	ts := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	inner := newMockCollector()
+	inner.err = errors.New("read failed")
+
+	ch := make(chan prometheus.Metric, 1)
+	err := NewTimestampedCollector(inner, ts).CollectWithError(ch)
+	assert.EqualError(t, err, "read failed")
+	assert.Empty(t, ch)
+
+	inner.err = nil
+	require.NoError(t, NewTimestampedCollector(inner, ts).CollectWithError(ch))
+
+	var m dto.Metric
+	require.NoError(t, (<-ch).Write(&m))
+	assert.Equal(t, ts.UnixMilli(), m.GetTimestampMs())
+}
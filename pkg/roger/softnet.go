@@ -0,0 +1,230 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// read per-CPU packet processing stats from /proc/net/softnet_stat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// softnetMinColumns is the number of columns every kernel has in each row of the
+// softnet_stat file: processed, dropped, and time_squeeze.
+const softnetMinColumns = 3
+
+// softnetCPUColumn is the column with the ID of the CPU on kernels that include it
+// (5.10 and later). Rows for offline CPUs are left out so the row number is only the
+// CPU ID when every CPU is online.
+const softnetCPUColumn = 12
+
+type softnetDescriptions struct {
+	processed   *prometheus.Desc
+	dropped     *prometheus.Desc
+	timeSqueeze *prometheus.Desc
+}
+
+func newSoftnetDescriptions() *softnetDescriptions {
+	return &softnetDescriptions{
+		processed: prometheus.NewDesc(
+			"roger_softnet_processed_total",
+			"Number of packets processed by the CPU",
+			[]string{"cpu"},
+			nil,
+		),
+		dropped: prometheus.NewDesc(
+			"roger_softnet_dropped_total",
+			"Number of packets dropped by the CPU because its backlog queue was full",
+			[]string{"cpu"},
+			nil,
+		),
+		timeSqueeze: prometheus.NewDesc(
+			"roger_softnet_time_squeeze_total",
+			"Number of times the CPU ran out of budget or time while packets were still waiting to be processed",
+			[]string{"cpu"},
+			nil,
+		),
+	}
+}
+
+// SoftnetResult is the packet processing stats of a single CPU.
+type SoftnetResult struct {
+	CPU         int    `json:"cpu"`
+	Processed   uint64 `json:"processed"`
+	Dropped     uint64 `json:"dropped"`
+	TimeSqueeze uint64 `json:"time_squeeze"`
+}
+
+// ProcNetSoftnetReader reads how many packets each CPU processed and dropped from
+// /proc/net/softnet_stat. Drops mean packets were lost because a CPU couldn't keep up
+// with the rate they arrived at, and time squeezes that NIC softirq processing ran
+// out of budget, which are early signs of packet loss under load.
+type ProcNetSoftnetReader struct {
+	// Timeout, if positive, is the most time spent reading softnet_stat on each
+	// collection. Collection fails instead of emitting some of the CPUs if the file
+	// can't be read in time.
+	Timeout time.Duration
+
+	path         string
+	descriptions *softnetDescriptions
+	mtime        *prometheus.Desc
+	created      *createdTimes
+	logger       log.Logger
+	errLog       *errorLogLimiter
+}
+
+func NewProcNetSoftnetReader(base string, logger log.Logger) *ProcNetSoftnetReader {
+	return &ProcNetSoftnetReader{
+		path:         filepath.Join(base, "net", "softnet_stat"),
+		descriptions: newSoftnetDescriptions(),
+		mtime:        newProcFileMtimeDesc(),
+		created:      newCreatedTimes(),
+		logger:       logger,
+		errLog:       newErrorLogLimiter(procErrorLogInterval),
+	}
+}
+
+func (p *ProcNetSoftnetReader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.descriptions.processed
+	ch <- p.descriptions.dropped
+	ch <- p.descriptions.timeSqueeze
+	ch <- p.mtime
+}
+
+func (p *ProcNetSoftnetReader) Collect(ch chan<- prometheus.Metric) {
+	err := p.CollectWithError(ch)
+	if err == nil {
+		if p.errLog.recovered() {
+			level.Info(p.logger).Log("msg", "softnet metrics collected successfully after failures", "path", p.path)
+		}
+
+		return
+	}
+
+	if ok, suppressed := p.errLog.failed(); ok {
+		level.Error(p.logger).Log("msg", "failed to read softnet metrics during collection", "path", p.path, "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits metrics for each CPU, returning an error if the
+// softnet_stat file could not be read.
+func (p *ProcNetSoftnetReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := collectContext(p.Timeout)
+	defer cancel()
+
+	res, err := p.parse(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range res {
+		cpu := strconv.Itoa(r.CPU)
+		ch <- p.created.counter(p.descriptions.processed, float64(r.Processed), cpu)
+		ch <- p.created.counter(p.descriptions.dropped, float64(r.Dropped), cpu)
+		ch <- p.created.counter(p.descriptions.timeSqueeze, float64(r.TimeSqueeze), cpu)
+	}
+
+	ch <- prometheus.MustNewConstMetric(p.mtime, prometheus.GaugeValue, float64(info.ModTime().UnixNano())/1e9, "net/softnet_stat")
+
+	p.created.sweep()
+	return nil
+}
+
+func (p *ProcNetSoftnetReader) Exists() bool {
+	if _, err := os.Stat(p.path); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
+
+func (p *ProcNetSoftnetReader) ReadMetrics() ([]SoftnetResult, error) {
+	return p.ReadMetricsContext(context.Background())
+}
+
+// ReadMetricsContext returns the stats of each CPU, returning an error wrapping the
+// error of the context if it's canceled or its deadline is exceeded before reading the
+// rows of all CPUs.
+func (p *ProcNetSoftnetReader) ReadMetricsContext(ctx context.Context) ([]SoftnetResult, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	return p.parse(ctx, f)
+}
+
+// parse reads a row of hex values for each online CPU. The file has no header and
+// kernels have added columns over time, only the first few are read.
+func (p *ProcNetSoftnetReader) parse(ctx context.Context, r io.Reader) ([]SoftnetResult, error) {
+	var out []SoftnetResult
+
+	scanner := bufio.NewScanner(r)
+	for row := 0; scanner.Scan(); row++ {
+		if err := procScanErr(ctx, row); err != nil {
+			return nil, fmt.Errorf("stopped reading %s after %d CPUs: %w", p.path, row, err)
+		}
+
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < softnetMinColumns {
+			return nil, fmt.Errorf("expected at least %d softnet_stat columns, got %d from %s", softnetMinColumns, len(parts), p.path)
+		}
+
+		var vals [softnetMinColumns]uint64
+		for i := range vals {
+			val, err := strconv.ParseUint(parts[i], 16, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			vals[i] = val
+		}
+
+		cpu := row
+		if len(parts) > softnetCPUColumn {
+			id, err := strconv.ParseUint(parts[softnetCPUColumn], 16, 32)
+			if err != nil {
+				return nil, err
+			}
+
+			cpu = int(id)
+		}
+
+		out = append(out, SoftnetResult{CPU: cpu, Processed: vals[0], Dropped: vals[1], TimeSqueeze: vals[2]})
+	}
+
+	return out, scanner.Err()
+}
@@ -0,0 +1,106 @@
+package roger
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Newer kernels include the backlog length and CPU ID in the last two columns, here
+// CPU 1 is offline so the second row is CPU 2.
+const softnetFixture = `0003a5f2 00000000 0000001c 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+0000ff10 0000000a 000001f4 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000002
+`
+
+// Older kernels only have the first 11 columns so rows are numbered instead.
+const softnetOldFixture = `00000010 00000001 00000002 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+00000020 00000000 00000003 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000
+`
+
+func TestProcNetSoftnetReader_Exists(t *testing.T) {
+	base := t.TempDir()
+	reader := NewProcNetSoftnetReader(base, log.NewNopLogger())
+	assert.False(t, reader.Exists())
+
+	writeProcFixture(t, base, "net/softnet_stat", softnetFixture)
+	assert.True(t, reader.Exists())
+}
+
+func TestProcNetSoftnetReader_ReadMetrics(t *testing.T) {
+	t.Run("cpu column", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/softnet_stat", softnetFixture)
+
+		res, err := NewProcNetSoftnetReader(base, log.NewNopLogger()).ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, []SoftnetResult{
+			{CPU: 0, Processed: 0x3a5f2, Dropped: 0, TimeSqueeze: 0x1c},
+			{CPU: 2, Processed: 0xff10, Dropped: 0xa, TimeSqueeze: 0x1f4},
+		}, res)
+	})
+
+	t.Run("no cpu column", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/softnet_stat", softnetOldFixture)
+
+		res, err := NewProcNetSoftnetReader(base, log.NewNopLogger()).ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, []SoftnetResult{
+			{CPU: 0, Processed: 16, Dropped: 1, TimeSqueeze: 2},
+			{CPU: 1, Processed: 32, Dropped: 0, TimeSqueeze: 3},
+		}, res)
+	})
+
+	t.Run("too few columns", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/softnet_stat", "00000010 00000001\n")
+
+		_, err := NewProcNetSoftnetReader(base, log.NewNopLogger()).ReadMetrics()
+		assert.Error(t, err)
+	})
+
+	t.Run("not hex", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "net/softnet_stat", "0000001g 00000001 00000002\n")
+
+		_, err := NewProcNetSoftnetReader(base, log.NewNopLogger()).ReadMetrics()
+		assert.Error(t, err)
+	})
+}
+
+func TestProcNetSoftnetReader_ReadMetricsContextCanceled(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/softnet_stat", softnetFixture)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	reader := NewProcNetSoftnetReader(base, log.NewNopLogger())
+	_, err := reader.ReadMetricsContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestProcNetSoftnetReader_Collect(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "net/softnet_stat", softnetFixture)
+
+	reader := NewProcNetSoftnetReader(base, log.NewNopLogger())
+	assert.Equal(t, 7, testutil.CollectAndCount(reader))
+
+	expected := `
+# HELP roger_softnet_dropped_total Number of packets dropped by the CPU because its backlog queue was full
+# TYPE roger_softnet_dropped_total counter
+roger_softnet_dropped_total{cpu="0"} 0
+roger_softnet_dropped_total{cpu="2"} 10
+# HELP roger_softnet_time_squeeze_total Number of times the CPU ran out of budget or time while packets were still waiting to be processed
+# TYPE roger_softnet_time_squeeze_total counter
+roger_softnet_time_squeeze_total{cpu="0"} 28
+roger_softnet_time_squeeze_total{cpu="2"} 500
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected), "roger_softnet_dropped_total", "roger_softnet_time_squeeze_total"))
+}
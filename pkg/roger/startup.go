@@ -0,0 +1,104 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// quiet expected collection errors while dependencies are starting up
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StartupGate tracks whether Roger has finished starting up. Roger is ready once a
+// collector wrapped by the gate succeeds or the grace period has passed, whichever
+// comes first. Until then, errors and warnings from loggers wrapped by the gate are
+// logged at debug level since they're expected when Roger starts at the same time as
+// the DNS server it exports metrics for.
+type StartupGate struct {
+	grace     time.Duration
+	start     time.Time
+	now       func() time.Time
+	succeeded atomic.Bool
+}
+
+// NewStartupGate creates a gate that is ready after the grace period even if no
+// collector has succeeded. A grace period of zero means the gate is always ready.
+func NewStartupGate(grace time.Duration) *StartupGate {
+	return &StartupGate{
+		grace: grace,
+		start: time.Now(),
+		now:   time.Now,
+	}
+}
+
+// Ready returns true if a wrapped collector has succeeded or the grace period has passed.
+func (s *StartupGate) Ready() bool {
+	return s.succeeded.Load() || s.now().Sub(s.start) >= s.grace
+}
+
+// Logger returns a logger that logs error and warning messages at debug level until
+// the gate is ready.
+func (s *StartupGate) Logger(logger log.Logger) log.Logger {
+	return log.LoggerFunc(func(keyvals ...interface{}) error {
+		if s.Ready() {
+			return logger.Log(keyvals...)
+		}
+
+		out := make([]interface{}, len(keyvals))
+		copy(out, keyvals)
+		for i := 1; i < len(out); i += 2 {
+			if out[i] == level.ErrorValue() || out[i] == level.WarnValue() {
+				out[i] = level.DebugValue()
+			}
+		}
+
+		return logger.Log(out...)
+	})
+}
+
+// GatedCollector wraps another collector, marking a StartupGate as ready the first
+// time collection succeeds.
+type GatedCollector struct {
+	name      string
+	collector ErrorCollector
+	gate      *StartupGate
+	logger    log.Logger
+}
+
+// Collector wraps the collector so that the gate becomes ready when it succeeds. Errors
+// from collecting when scraped are logged using logger, which should be wrapped by the
+// gate as well.
+func (s *StartupGate) Collector(name string, collector ErrorCollector, logger log.Logger) *GatedCollector {
+	return &GatedCollector{name: name, collector: collector, gate: s, logger: logger}
+}
+
+func (g *GatedCollector) Describe(ch chan<- *prometheus.Desc) {
+	g.collector.Describe(ch)
+}
+
+func (g *GatedCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := g.CollectWithError(ch); err != nil {
+		level.Error(g.logger).Log("msg", "failed to collect metrics", "collector", g.name, "err", err)
+	}
+}
+
+func (g *GatedCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	err := g.collector.CollectWithError(ch)
+	if err == nil {
+		g.gate.succeeded.Store(true)
+	}
+
+	return err
+}
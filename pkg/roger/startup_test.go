@@ -0,0 +1,63 @@
+package roger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartupGate_Ready(t *testing.T) {
+	t.Run("no grace period", func(t *testing.T) {
+		gate := NewStartupGate(0)
+		assert.True(t, gate.Ready())
+	})
+
+	t.Run("grace period", func(t *testing.T) {
+		gate := NewStartupGate(time.Minute)
+		gate.now = func() time.Time { return gate.start.Add(30 * time.Second) }
+		assert.False(t, gate.Ready())
+
+		gate.now = func() time.Time { return gate.start.Add(time.Minute) }
+		assert.True(t, gate.Ready())
+	})
+
+	t.Run("collector success", func(t *testing.T) {
+		mock := newMockCollector()
+		mock.err = errors.New("connection refused")
+		gate := NewStartupGate(time.Minute)
+		collector := gate.Collector("test", mock, log.NewNopLogger())
+
+		assert.Equal(t, 0, testutil.CollectAndCount(collector, "roger_test_value"))
+		assert.False(t, gate.Ready())
+
+		mock.err = nil
+		assert.Equal(t, 1, testutil.CollectAndCount(collector, "roger_test_value"))
+		assert.True(t, gate.Ready())
+
+		// Once ready, later failures don't make the gate unready
+		mock.err = errors.New("connection refused")
+		assert.Equal(t, 0, testutil.CollectAndCount(collector, "roger_test_value"))
+		assert.True(t, gate.Ready())
+	})
+}
+
+func TestStartupGate_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	gate := NewStartupGate(time.Minute)
+	logger := gate.Logger(level.NewFilter(log.NewLogfmtLogger(&buf), level.AllowInfo()))
+
+	level.Error(logger).Log("msg", "during grace")
+	level.Info(logger).Log("msg", "info during grace")
+	assert.Equal(t, "level=info msg=\"info during grace\"\n", buf.String())
+
+	buf.Reset()
+	gate.succeeded.Store(true)
+	level.Error(logger).Log("msg", "after grace")
+	assert.Equal(t, "level=error msg=\"after grace\"\n", buf.String())
+}
@@ -0,0 +1,123 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// answer stats queries from a file instead of a DNS server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ProtocolFile is the transport reported for responses read from a stats file.
+const ProtocolFile = "file"
+
+// StatsFileClient answers stats queries from a file of "name value" lines written by
+// an out-of-band job instead of querying a DNS server, for hosts where Roger can't
+// reach the server. The address of each query is the path of the file. Names are the
+// names of the CHAOS TXT queries, e.g.
+//
+//	cachesize.bind. 150
+//	hits.bind. 1523
+//	servers.bind. 10.0.0.1#53 120 2
+//	servers.bind. 10.0.0.2#53 98 0
+//
+// Each line with the same name is a separate string of the TXT answer, as dnsmasq
+// does for upstream servers. Blank lines and lines starting with "#" are ignored and
+// questions without a line in the file aren't answered. The file is read on each query
+// so that it can be replaced between collections.
+type StatsFileClient struct{}
+
+func NewStatsFileClient() *StatsFileClient {
+	return &StatsFileClient{}
+}
+
+func (c *StatsFileClient) Exchange(m *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	r, rtt, _, err := c.ExchangeTransport(m, address)
+	return r, rtt, err
+}
+
+// ExchangeTransport answers each question of the message from the file at address.
+func (c *StatsFileClient) ExchangeTransport(m *dns.Msg, address string) (*dns.Msg, time.Duration, string, error) {
+	start := time.Now()
+
+	values, err := readStatsFile(address)
+	if err != nil {
+		return nil, 0, ProtocolFile, err
+	}
+
+	return answerStats(m, values), time.Since(start), ProtocolFile, nil
+}
+
+// answerStats answers each question of the message with a TXT record of the values for
+// its name, keyed by the lowercase, fully qualified name. Questions without values
+// aren't answered.
+func answerStats(m *dns.Msg, values map[string][]string) *dns.Msg {
+	r := &dns.Msg{}
+	r.SetReply(m)
+
+	for _, q := range m.Question {
+		txt, ok := values[strings.ToLower(q.Name)]
+		if !ok {
+			continue
+		}
+
+		r.Answer = append(r.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: q.Qclass},
+			Txt: txt,
+		})
+	}
+
+	return r
+}
+
+// readStatsFile returns the values of each name in the file, keyed by the lowercase,
+// fully qualified name.
+func readStatsFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	values := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Names and values may be separated by any whitespace, values are kept as
+		// written after it, e.g. "10.0.0.1#53 120 2"
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid line %d of stats file %s: expected name and value", lineNum, path)
+		}
+
+		name := parts[0]
+		value := strings.TrimSpace(strings.TrimPrefix(line, name))
+
+		name = strings.ToLower(dns.Fqdn(name))
+		values[name] = append(values[name], value)
+	}
+
+	return values, scanner.Err()
+}
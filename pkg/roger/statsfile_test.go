@@ -0,0 +1,61 @@
+package roger
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statsFileFixture = `# written by the stats job
+cachesize.bind. 150
+insertions.bind. 10
+evictions.bind. 0
+misses.bind.	40
+hits.bind   1523
+AUTH.bind. 2
+
+servers.bind. 10.0.0.1#53 120 2
+servers.bind. 10.0.0.2#53 98 0
+`
+
+func TestStatsFileClient_ReadMetrics(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "stats", statsFileFixture)
+	path := filepath.Join(base, "stats")
+
+	reader := NewDnsmasqReader(NewStatsFileClient(), path, log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(150), res.CacheSize)
+	assert.Equal(t, uint64(10), res.CacheInsertions)
+	assert.Equal(t, uint64(0), res.CacheEvictions)
+	assert.Equal(t, uint64(40), res.CacheMisses)
+	assert.Equal(t, uint64(1523), res.CacheHits)
+	assert.Equal(t, uint64(2), res.Authoritative)
+	assert.Equal(t, ProtocolFile, res.Transport)
+	assert.Equal(t, []ServerStats{
+		{Address: "10.0.0.1#53", QueriesSent: 120, QueryErrors: 2, Family: "ipv4"},
+		{Address: "10.0.0.2#53", QueriesSent: 98, QueryErrors: 0, Family: "ipv4"},
+	}, res.Servers)
+}
+
+func TestStatsFileClient_Errors(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		reader := NewDnsmasqReader(NewStatsFileClient(), filepath.Join(t.TempDir(), "stats"), log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		assert.ErrorIs(t, err, ErrUpstream)
+	})
+
+	t.Run("missing value", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "stats", "hits.bind.\n")
+
+		reader := NewDnsmasqReader(NewStatsFileClient(), filepath.Join(base, "stats"), log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,371 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// note collectors that failed in the body of metrics responses
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// CollectorStatus keeps the result of the most recent collection by each collector
+// so that responses with metrics can note which collectors failed. Collectors log
+// their errors and return the metrics that could be collected instead of failing
+// the scrape, leaving no sign in the response itself that any metrics are missing.
+type CollectorStatus struct {
+	now func() time.Time
+
+	lock       sync.Mutex
+	collectors []*TrackedCollector
+	disabled   []string
+}
+
+func NewCollectorStatus() *CollectorStatus {
+	return &CollectorStatus{now: time.Now}
+}
+
+// CollectorFailure is a collector whose most recent collection failed.
+type CollectorFailure struct {
+	Name string
+	Err  error
+}
+
+// CollectorState is the result of the most recent collection by a collector.
+type CollectorState struct {
+	Name string `json:"name"`
+	// Enabled is false for collectors that Roger knows of but that aren't collected
+	// from, because they were turned off or what they read isn't available.
+	Enabled bool `json:"enabled"`
+	// LastCollection is when the most recent collection started, nil if the collector
+	// hasn't been collected from yet.
+	LastCollection *time.Time `json:"last_collection"`
+	// LastDuration is how long the most recent collection took in seconds.
+	LastDuration float64 `json:"last_duration_seconds"`
+	// LastError is the error of the most recent collection, empty if it succeeded.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// States returns the result of the most recent collection by each collector in the
+// order they were added, followed by each disabled collector. Collectors aren't
+// collected from, so this is cheap enough to call on every request to a status
+// endpoint.
+func (s *CollectorStatus) States() []CollectorState {
+	collectors := s.tracked()
+	out := make([]CollectorState, 0, len(collectors))
+	enabled := make(map[string]bool, len(collectors))
+	for _, c := range collectors {
+		state := c.state()
+		state.Enabled = true
+		enabled[c.name] = true
+		out = append(out, state)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// A collector may be disabled in favor of another way of collecting the same
+	// metrics under the same name, e.g. conntrack stats over netlink.
+	for _, name := range s.disabled {
+		if !enabled[name] {
+			out = append(out, CollectorState{Name: name, Enabled: false})
+		}
+	}
+
+	return out
+}
+
+// Disabled adds a collector that isn't collected from, so that it's included in
+// States as disabled instead of being left out.
+func (s *CollectorStatus) Disabled(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, d := range s.disabled {
+		if d == name {
+			return
+		}
+	}
+
+	s.disabled = append(s.disabled, name)
+}
+
+// Running returns the names of collectors whose current collection started more than
+// the given duration ago, in the order they were added.
+func (s *CollectorStatus) Running(longerThan time.Duration) []string {
+	now := s.now()
+
+	var out []string
+	for _, c := range s.tracked() {
+		if started := c.runningSince(); !started.IsZero() && now.Sub(started) > longerThan {
+			out = append(out, c.name)
+		}
+	}
+
+	return out
+}
+
+// Failures returns the collectors whose most recent collection failed in the order
+// they were added.
+func (s *CollectorStatus) Failures() []CollectorFailure {
+	collectors := s.tracked()
+
+	var out []CollectorFailure
+	for _, c := range collectors {
+		if err := c.lastError(); err != nil {
+			out = append(out, CollectorFailure{Name: c.name, Err: err})
+		}
+	}
+
+	return out
+}
+
+func (s *CollectorStatus) tracked() []*TrackedCollector {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out := make([]*TrackedCollector, len(s.collectors))
+	copy(out, s.collectors)
+	return out
+}
+
+// Collector wraps the collector to keep the result of its most recent collection.
+func (s *CollectorStatus) Collector(name string, collector ErrorCollector, logger log.Logger) *TrackedCollector {
+	c := &TrackedCollector{
+		name:      name,
+		collector: collector,
+		now:       s.now,
+		logger:    logger,
+		errLog:    newErrorLogLimiter(procErrorLogInterval),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.collectors = append(s.collectors, c)
+	return c
+}
+
+// Handler wraps a handler of metrics requests to add a comment for each collector
+// whose most recent collection failed to the end of responses in the Prometheus text
+// format, e.g.
+//
+//	# roger: collector "dnsmasq" failed: upstream error: read udp: i/o timeout
+//
+// Other formats such as OpenMetrics don't allow comments and are left as is. The
+// response of the wrapped handler is buffered to add the comments so it must not
+// compress responses itself, responses are compressed by this handler instead.
+func (s *CollectorStatus) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &bufferedResponse{header: make(http.Header), code: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+
+		body := rec.body.Bytes()
+		if strings.HasPrefix(rec.header.Get("Content-Type"), "text/plain") {
+			var sb strings.Builder
+			for _, f := range s.Failures() {
+				msg := strings.ReplaceAll(f.Err.Error(), "\n", " ")
+				fmt.Fprintf(&sb, "# roger: collector %s failed: %s\n", strconv.Quote(f.Name), msg)
+			}
+
+			body = append(body, sb.String()...)
+		}
+
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+
+		if acceptsGzip(r) {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			_, _ = gz.Write(body)
+			_ = gz.Close()
+
+			body = buf.Bytes()
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(rec.code)
+		_, _ = w.Write(body)
+	})
+}
+
+// Once gathers metrics a single time and writes them to w in the Prometheus text format
+// followed by a comment for each collector that failed, as responses of Handler do. The
+// collectors that failed are returned along with any error gathering, for callers that
+// only care whether every collector succeeded. Metrics that could be gathered are
+// written even if some collectors failed.
+func (s *CollectorStatus) Once(w io.Writer, gatherer prometheus.Gatherer) ([]CollectorFailure, error) {
+	families, gatherErr := gatherer.Gather()
+	for _, f := range families {
+		if _, err := expfmt.MetricFamilyToText(w, f); err != nil {
+			return nil, err
+		}
+	}
+
+	failures := s.Failures()
+	for _, f := range failures {
+		msg := strings.ReplaceAll(f.Err.Error(), "\n", " ")
+		if _, err := fmt.Fprintf(w, "# roger: collector %s failed: %s\n", strconv.Quote(f.Name), msg); err != nil {
+			return nil, err
+		}
+	}
+
+	return failures, gatherErr
+}
+
+// acceptsGzip returns true if the client accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(encoding) == "gzip" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bufferedResponse is a http.ResponseWriter that keeps the response in memory.
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	b.code = code
+}
+
+// TrackedCollector keeps the result of the most recent collection of the collector it
+// wraps, see CollectorStatus. Since the collector is collected from with its errors
+// returned instead of logged, errors are logged here at most once per interval while
+// collection keeps failing, the same as the /proc readers do on their own.
+type TrackedCollector struct {
+	name      string
+	collector ErrorCollector
+	now       func() time.Time
+	logger    log.Logger
+	errLog    *errorLogLimiter
+
+	lock      sync.Mutex
+	err       error
+	collected time.Time
+	duration  time.Duration
+	// Start of each collection that hasn't finished yet, collections can overlap
+	// when scrapes do.
+	running map[*time.Time]struct{}
+}
+
+func (t *TrackedCollector) Describe(ch chan<- *prometheus.Desc) {
+	t.collector.Describe(ch)
+}
+
+func (t *TrackedCollector) Collect(ch chan<- prometheus.Metric) {
+	err := t.CollectWithError(ch)
+	if err == nil {
+		if t.errLog.recovered() {
+			level.Info(t.logger).Log("msg", "metrics collected successfully after failures", "collector", t.name)
+		}
+
+		return
+	}
+
+	if ok, suppressed := t.errLog.failed(); ok {
+		level.Error(t.logger).Log("msg", "failed to collect metrics", "collector", t.name, "suppressed", suppressed, "err", err)
+	}
+}
+
+func (t *TrackedCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	start := t.now()
+	t.lock.Lock()
+	if t.running == nil {
+		t.running = make(map[*time.Time]struct{})
+	}
+	t.running[&start] = struct{}{}
+	t.lock.Unlock()
+
+	err := t.collector.CollectWithError(ch)
+	duration := t.now().Sub(start)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.running, &start)
+
+	t.err = err
+	t.collected = start
+	t.duration = duration
+	return err
+}
+
+func (t *TrackedCollector) lastError() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.err
+}
+
+// runningSince returns when the oldest collection that hasn't finished yet started,
+// zero if the collector isn't collecting.
+func (t *TrackedCollector) runningSince() time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var oldest time.Time
+	for start := range t.running {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = *start
+		}
+	}
+
+	return oldest
+}
+
+func (t *TrackedCollector) state() CollectorState {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	out := CollectorState{Name: t.name}
+	if !t.collected.IsZero() {
+		collected := t.collected
+		out.LastCollection = &collected
+		out.LastDuration = t.duration.Seconds()
+	}
+
+	if t.err != nil {
+		out.LastError = t.err.Error()
+	}
+
+	return out
+}
@@ -0,0 +1,175 @@
+package roger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStatusTestHandler() (http.Handler, *mockCollector, *mockCollector) {
+	working := newMockCollector()
+	working.value = 42
+
+	failing := &mockCollector{desc: prometheus.NewDesc("roger_test_failing", "Test failing", nil, nil), err: errors.New("read failed\nbadly")}
+
+	status := NewCollectorStatus()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(status.Collector("working", working, log.NewNopLogger()))
+	registry.MustRegister(status.Collector("failing", failing, log.NewNopLogger()))
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError, DisableCompression: true})
+	return status.Handler(handler), working, failing
+}
+
+func TestCollectorStatus_Handler(t *testing.T) {
+	t.Run("failed collector", func(t *testing.T) {
+		handler, _, _ := newStatusTestHandler()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `# HELP roger_test_value Test value
+# TYPE roger_test_value gauge
+roger_test_value 42
+# roger: collector "failing" failed: read failed badly
+`, rec.Body.String())
+	})
+
+	t.Run("recovered collector", func(t *testing.T) {
+		handler, _, failing := newStatusTestHandler()
+		failing.err = nil
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.NotContains(t, rec.Body.String(), "# roger:")
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		handler, _, _ := newStatusTestHandler()
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+		gz, err := gzip.NewReader(rec.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `# roger: collector "failing" failed: read failed badly`)
+	})
+}
+
+func TestCollectorStatus_Once(t *testing.T) {
+	working := newMockCollector()
+	working.value = 42
+
+	failing := &mockCollector{desc: prometheus.NewDesc("roger_test_failing", "Test failing", nil, nil), err: errors.New("read failed")}
+
+	status := NewCollectorStatus()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(status.Collector("working", working, log.NewNopLogger()))
+	registry.MustRegister(status.Collector("failing", failing, log.NewNopLogger()))
+
+	t.Run("failed collector", func(t *testing.T) {
+		var out strings.Builder
+		failures, err := status.Once(&out, registry)
+		require.NoError(t, err)
+
+		require.Len(t, failures, 1)
+		assert.Equal(t, "failing", failures[0].Name)
+		assert.Equal(t, `# HELP roger_test_value Test value
+# TYPE roger_test_value gauge
+roger_test_value 42
+# roger: collector "failing" failed: read failed
+`, out.String())
+	})
+
+	t.Run("all collectors succeeded", func(t *testing.T) {
+		failing.err = nil
+
+		var out strings.Builder
+		failures, err := status.Once(&out, registry)
+		require.NoError(t, err)
+		assert.Empty(t, failures)
+		assert.NotContains(t, out.String(), "# roger:")
+	})
+}
+
+func TestCollectorStatus_States(t *testing.T) {
+	working := newMockCollector()
+	failing := &mockCollector{desc: prometheus.NewDesc("roger_test_failing", "Test failing", nil, nil), err: errors.New("read failed")}
+
+	// Collectors are collected concurrently so each call to now moves the clock forward
+	var calls atomic.Int64
+	status := NewCollectorStatus()
+	status.now = func() time.Time {
+		return time.Unix(1600000000, 0).Add(time.Duration(calls.Add(1)) * 250 * time.Millisecond)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(status.Collector("working", working, log.NewNopLogger()))
+	registry.MustRegister(status.Collector("failing", failing, log.NewNopLogger()))
+	status.Disabled("conntrack")
+	status.Disabled("working")
+
+	t.Run("not collected", func(t *testing.T) {
+		assert.Equal(t, []CollectorState{
+			{Name: "working", Enabled: true},
+			{Name: "failing", Enabled: true},
+			{Name: "conntrack", Enabled: false},
+		}, status.States())
+	})
+
+	t.Run("collected", func(t *testing.T) {
+		_, _ = registry.Gather()
+
+		states := status.States()
+		require.Len(t, states, 3)
+		for _, s := range states[:2] {
+			require.NotNil(t, s.LastCollection)
+			assert.True(t, s.Enabled)
+			assert.Greater(t, s.LastDuration, 0.0)
+		}
+		assert.Equal(t, CollectorState{Name: "conntrack", Enabled: false}, states[2])
+
+		assert.Equal(t, "working", states[0].Name)
+		assert.Empty(t, states[0].LastError)
+		assert.Equal(t, "failing", states[1].Name)
+		assert.Equal(t, "read failed", states[1].LastError)
+	})
+}
+
+func TestTrackedCollector_CollectLogsRateLimited(t *testing.T) {
+	failing := &mockCollector{desc: prometheus.NewDesc("roger_test_failing", "Test failing", nil, nil), err: errors.New("read failed")}
+
+	var buf bytes.Buffer
+	c := NewCollectorStatus().Collector("failing", failing, log.NewLogfmtLogger(&buf))
+
+	for i := 0; i < 3; i++ {
+		_ = metricNames(t, c)
+	}
+
+	assert.Equal(t, 1, strings.Count(buf.String(), "failed to collect metrics"))
+
+	failing.err = nil
+	_ = metricNames(t, c)
+	assert.Contains(t, buf.String(), "metrics collected successfully after failures")
+}
@@ -0,0 +1,344 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// read network interface attributes from /sys/class/net
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SysClassNet reads attributes of network interfaces from sysfs.
+type SysClassNet struct {
+	path string
+}
+
+func NewSysClassNet(base string) *SysClassNet {
+	return &SysClassNet{path: filepath.Join(base, "class", "net")}
+}
+
+func (s *SysClassNet) Exists() bool {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return false
+	}
+
+	return true
+}
+
+// OperState returns the RFC 2863 operational state of the interface, e.g. "up",
+// "down", "dormant", or "unknown".
+func (s *SysClassNet) OperState(iface string) (string, error) {
+	return s.readAttribute(iface, "operstate")
+}
+
+// UpFilter returns an InterfaceFilter that only includes interfaces that are up.
+// Interfaces in the "unknown" state (such as loopback and many tunnel devices)
+// and interfaces whose state can't be read are included.
+func (s *SysClassNet) UpFilter() InterfaceFilter {
+	return func(iface string) bool {
+		state, err := s.OperState(iface)
+		if err != nil {
+			return true
+		}
+
+		return state == "up" || state == "unknown"
+	}
+}
+
+// Bonds returns the member interfaces of each bond interface, keyed by the name of
+// the bond. An empty map is returned if the bonding driver isn't loaded.
+func (s *SysClassNet) Bonds() (map[string][]string, error) {
+	bonds := make(map[string][]string)
+
+	masters, err := os.ReadFile(filepath.Join(s.path, "bonding_masters"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return bonds, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, bond := range strings.Fields(string(masters)) {
+		members, err := s.readAttribute(bond, filepath.Join("bonding", "slaves"))
+		if errors.Is(err, fs.ErrNotExist) {
+			// The bond was removed between reading the list of bonds and its members
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		bonds[bond] = strings.Fields(members)
+	}
+
+	return bonds, nil
+}
+
+// Interfaces returns the names of all network interfaces, sorted.
+func (s *SysClassNet) Interfaces() ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, e := range entries {
+		// Interfaces are symlinks to their device directory, other entries such
+		// as bonding_masters are regular files.
+		info, err := os.Stat(filepath.Join(s.path, e.Name()))
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		out = append(out, e.Name())
+	}
+
+	sort.Strings(out)
+	return out, nil
+}
+
+// MTU returns the maximum transmission unit of the interface in bytes.
+func (s *SysClassNet) MTU(iface string) (uint64, error) {
+	return s.readUintAttribute(iface, "mtu")
+}
+
+// TxQueueLen returns the maximum number of packets in the transmit queue of the interface.
+func (s *SysClassNet) TxQueueLen(iface string) (uint64, error) {
+	return s.readUintAttribute(iface, "tx_queue_len")
+}
+
+func (s *SysClassNet) readUintAttribute(iface string, attr string) (uint64, error) {
+	val, err := s.readAttribute(iface, attr)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(val, 10, 64)
+}
+
+func (s *SysClassNet) readAttribute(iface string, attr string) (string, error) {
+	contents, err := os.ReadFile(filepath.Join(s.path, iface, attr))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+type SysInterfaceResult struct {
+	InterfaceName string
+	// MTU is nil if the interface doesn't have an MTU.
+	MTU *uint64
+	// TxQueueLen is nil if the interface doesn't have a transmit queue length.
+	TxQueueLen *uint64
+	// OperState is empty if the interface doesn't have an operational state.
+	OperState string
+}
+
+// SysClassNetReader emits attributes of network interfaces from sysfs that aren't
+// part of /proc/net/dev, using the same interface names as the net/dev metrics.
+type SysClassNetReader struct {
+	// Filter, if set, selects which interfaces metrics are emitted for. Metrics
+	// for all interfaces are emitted when nil.
+	Filter InterfaceFilter
+
+	// NormalizeNames strips "@" suffixes from interface names, see NormalizeInterfaceName.
+	NormalizeNames bool
+
+	sys          *SysClassNet
+	mtu          *prometheus.Desc
+	txQueueLen   *prometheus.Desc
+	stateChanges *prometheus.Desc
+	created      *createdTimes
+	logger       log.Logger
+	errLog       *errorLogLimiter
+
+	// Operational state of each interface as of the previous collection and the
+	// number of times it has changed, guarded by lock.
+	lock    sync.Mutex
+	states  map[string]string
+	changes map[string]uint64
+}
+
+func NewSysClassNetReader(base string, logger log.Logger) *SysClassNetReader {
+	return &SysClassNetReader{
+		sys: NewSysClassNet(base),
+		mtu: prometheus.NewDesc(
+			"roger_net_interface_mtu_bytes",
+			"Maximum transmission unit of the interface in bytes",
+			[]string{"interface"},
+			nil,
+		),
+		txQueueLen: prometheus.NewDesc(
+			"roger_net_interface_tx_queue_length",
+			"Maximum number of packets in the transmit queue of the interface",
+			[]string{"interface"},
+			nil,
+		),
+		stateChanges: prometheus.NewDesc(
+			"roger_net_interface_state_changes_total",
+			"Number of times the operational state of the interface changed between collections",
+			[]string{"interface"},
+			nil,
+		),
+		created: newCreatedTimes(),
+		logger:  logger,
+		errLog:  newErrorLogLimiter(procErrorLogInterval),
+		states:  make(map[string]string),
+		changes: make(map[string]uint64),
+	}
+}
+
+func (r *SysClassNetReader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.mtu
+	ch <- r.txQueueLen
+	ch <- r.stateChanges
+}
+
+func (r *SysClassNetReader) Collect(ch chan<- prometheus.Metric) {
+	err := r.CollectWithError(ch)
+	if err == nil {
+		if r.errLog.recovered() {
+			level.Info(r.logger).Log("msg", "sysfs interface metrics collected successfully after failures", "path", r.sys.path)
+		}
+
+		return
+	}
+
+	if ok, suppressed := r.errLog.failed(); ok {
+		level.Error(r.logger).Log("msg", "failed to read sysfs interface metrics during collection", "path", r.sys.path, "suppressed", suppressed, "err", err)
+	}
+}
+
+// CollectWithError emits metrics for each interface, returning an error if the
+// interfaces or their attributes could not be read.
+func (r *SysClassNetReader) CollectWithError(ch chan<- prometheus.Metric) error {
+	res, err := r.ReadMetrics()
+	if err != nil {
+		return err
+	}
+
+	changes := r.trackStates(res)
+
+	for _, iface := range res {
+		if iface.MTU != nil {
+			ch <- prometheus.MustNewConstMetric(r.mtu, prometheus.GaugeValue, float64(*iface.MTU), iface.InterfaceName)
+		}
+
+		if iface.TxQueueLen != nil {
+			ch <- prometheus.MustNewConstMetric(r.txQueueLen, prometheus.GaugeValue, float64(*iface.TxQueueLen), iface.InterfaceName)
+		}
+
+		if count, ok := changes[iface.InterfaceName]; ok {
+			ch <- r.created.counter(r.stateChanges, float64(count), iface.InterfaceName)
+		}
+	}
+
+	r.created.sweep()
+	return nil
+}
+
+// trackStates compares the operational state of each interface to its state as of
+// the previous collection and returns the number of times the state of each interface
+// has changed. Interfaces seen for the first time start with no changes and interfaces
+// that are no longer present are forgotten so that short-lived interfaces (such as
+// container veth pairs) don't accumulate.
+func (r *SysClassNetReader) trackStates(res []SysInterfaceResult) map[string]uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	seen := make(map[string]struct{}, len(res))
+	out := make(map[string]uint64, len(res))
+
+	for _, iface := range res {
+		if iface.OperState == "" {
+			continue
+		}
+
+		name := iface.InterfaceName
+		seen[name] = struct{}{}
+
+		if prev, ok := r.states[name]; ok && prev != iface.OperState {
+			r.changes[name]++
+		}
+
+		r.states[name] = iface.OperState
+		out[name] = r.changes[name]
+	}
+
+	for name := range r.states {
+		if _, ok := seen[name]; !ok {
+			delete(r.states, name)
+			delete(r.changes, name)
+		}
+	}
+
+	return out
+}
+
+func (r *SysClassNetReader) Exists() bool {
+	return r.sys.Exists()
+}
+
+// ReadMetrics reads attributes of each interface. Attributes missing for an
+// interface, or interfaces removed while being read, are skipped.
+func (r *SysClassNetReader) ReadMetrics() ([]SysInterfaceResult, error) {
+	ifaces, err := r.sys.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SysInterfaceResult, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if r.Filter != nil && !r.Filter(iface) {
+			continue
+		}
+
+		res := SysInterfaceResult{InterfaceName: iface}
+		if r.NormalizeNames {
+			res.InterfaceName = NormalizeInterfaceName(iface)
+		}
+
+		mtu, err := r.sys.MTU(iface)
+		if err == nil {
+			res.MTU = &mtu
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		qlen, err := r.sys.TxQueueLen(iface)
+		if err == nil {
+			res.TxQueueLen = &qlen
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		state, err := r.sys.OperState(iface)
+		if err == nil {
+			res.OperState = state
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		out = append(out, res)
+	}
+
+	return out, nil
+}
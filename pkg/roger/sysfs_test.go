@@ -0,0 +1,164 @@
+package roger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSysFixture(t *testing.T, base string, iface string, attr string, contents string) {
+	writeProcFixture(t, base, "class/net/"+iface+"/"+attr, contents+"\n")
+}
+
+func TestSysClassNet_OperState(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "eth0", "operstate", "up")
+
+	sys := NewSysClassNet(base)
+	require.True(t, sys.Exists())
+
+	state, err := sys.OperState("eth0")
+	require.NoError(t, err)
+	assert.Equal(t, "up", state)
+
+	_, err = sys.OperState("eth1")
+	assert.Error(t, err)
+}
+
+func TestSysClassNet_UpFilter(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "lo", "operstate", "unknown")
+	writeSysFixture(t, base, "eth0", "operstate", "up")
+	writeSysFixture(t, base, "eth1", "operstate", "down")
+
+	filter := NewSysClassNet(base).UpFilter()
+
+	assert.True(t, filter("lo"))
+	assert.True(t, filter("eth0"))
+	assert.False(t, filter("eth1"))
+	assert.True(t, filter("eth2"))
+}
+
+func TestSysClassNet_Bonds(t *testing.T) {
+	t.Run("bonding not loaded", func(t *testing.T) {
+		base := t.TempDir()
+		writeSysFixture(t, base, "eth0", "operstate", "up")
+
+		bonds, err := NewSysClassNet(base).Bonds()
+		require.NoError(t, err)
+		assert.Empty(t, bonds)
+	})
+
+	t.Run("bonds", func(t *testing.T) {
+		base := t.TempDir()
+		writeProcFixture(t, base, "class/net/bonding_masters", "bond0 bond1 bond2\n")
+		writeSysFixture(t, base, "bond0", "bonding/slaves", "eth0 eth1")
+		writeSysFixture(t, base, "bond1", "bonding/slaves", "")
+
+		bonds, err := NewSysClassNet(base).Bonds()
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]string{"bond0": {"eth0", "eth1"}, "bond1": {}}, bonds)
+	})
+}
+
+func TestSysClassNet_Interfaces(t *testing.T) {
+	base := t.TempDir()
+	writeProcFixture(t, base, "class/net/bonding_masters", "bond0\n")
+	writeSysFixture(t, base, "eth1", "mtu", "1500")
+	writeSysFixture(t, base, "eth0", "mtu", "1500")
+
+	ifaces, err := NewSysClassNet(base).Interfaces()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eth0", "eth1"}, ifaces)
+}
+
+func TestSysClassNetReader_Collect(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "eth0", "mtu", "9000")
+	writeSysFixture(t, base, "eth0", "tx_queue_len", "1000")
+	writeSysFixture(t, base, "eth1", "mtu", "1500")
+	writeSysFixture(t, base, "eth1", "tx_queue_len", "1000")
+	writeSysFixture(t, base, "wg0", "mtu", "1420")
+
+	reader := NewSysClassNetReader(base, log.NewNopLogger())
+	reader.Filter = func(iface string) bool { return iface != "eth1" }
+	require.True(t, reader.Exists())
+
+	expected := `
+# HELP roger_net_interface_mtu_bytes Maximum transmission unit of the interface in bytes
+# TYPE roger_net_interface_mtu_bytes gauge
+roger_net_interface_mtu_bytes{interface="eth0"} 9000
+roger_net_interface_mtu_bytes{interface="wg0"} 1420
+# HELP roger_net_interface_tx_queue_length Maximum number of packets in the transmit queue of the interface
+# TYPE roger_net_interface_tx_queue_length gauge
+roger_net_interface_tx_queue_length{interface="eth0"} 1000
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected)))
+}
+
+func TestSysClassNetReader_ReadMetricsInvalid(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "eth0", "mtu", "large")
+
+	_, err := NewSysClassNetReader(base, log.NewNopLogger()).ReadMetrics()
+	assert.Error(t, err)
+}
+
+func TestSysClassNetReader_CollectLogRateLimited(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "eth0", "mtu", "large")
+
+	var buf bytes.Buffer
+	reader := NewSysClassNetReader(base, log.NewLogfmtLogger(&buf))
+
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	assert.Equal(t, 1, strings.Count(buf.String(), "failed to read sysfs interface metrics"))
+
+	writeSysFixture(t, base, "eth0", "mtu", "1500")
+
+	testutil.CollectAndCount(reader)
+	testutil.CollectAndCount(reader)
+	assert.Equal(t, 1, strings.Count(buf.String(), "collected successfully after failures"))
+}
+
+func TestSysClassNetReader_ReadMetricsNormalizeNames(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "veth1a2b@if12", "mtu", "1500")
+
+	reader := NewSysClassNetReader(base, log.NewNopLogger())
+	reader.NormalizeNames = true
+
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "veth1a2b", res[0].InterfaceName)
+}
+
+func TestSysClassNetReader_CollectStateChanges(t *testing.T) {
+	base := t.TempDir()
+	writeSysFixture(t, base, "eth0", "operstate", "up")
+	writeSysFixture(t, base, "eth1", "operstate", "up")
+
+	reader := NewSysClassNetReader(base, log.NewNopLogger())
+
+	expected := `
+# HELP roger_net_interface_state_changes_total Number of times the operational state of the interface changed between collections
+# TYPE roger_net_interface_state_changes_total counter
+roger_net_interface_state_changes_total{interface="eth0"} 0
+roger_net_interface_state_changes_total{interface="eth1"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(expected)))
+
+	writeSysFixture(t, base, "eth0", "operstate", "down")
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(strings.Replace(expected, `{interface="eth0"} 0`, `{interface="eth0"} 1`, 1))))
+
+	writeSysFixture(t, base, "eth0", "operstate", "up")
+	assert.NoError(t, testutil.CollectAndCompare(reader, strings.NewReader(strings.Replace(expected, `{interface="eth0"} 0`, `{interface="eth0"} 2`, 1))))
+}
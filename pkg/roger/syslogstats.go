@@ -0,0 +1,210 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// answer stats queries from dnsmasq stats dumps in a log file
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ProtocolLog is the transport reported for responses read from a log file.
+const ProtocolLog = "log"
+
+// errNoStatsDump is returned until a stats dump has been read from the log file.
+var errNoStatsDump = errors.New("no dnsmasq stats dump found in log file, dnsmasq logs one when sent SIGUSR1")
+
+// Lines of the stats dump dnsmasq logs when sent SIGUSR1. Older versions log the retried
+// and failed queries of each server together, newer versions separately.
+var (
+	syslogCacheSize   = regexp.MustCompile(`dnsmasq(?:\[\d+\])?: cache size (\d+), (\d+)/(\d+) cache insertions re-used unexpired cache entries`)
+	syslogQueries     = regexp.MustCompile(`dnsmasq(?:\[\d+\])?: queries forwarded (\d+), queries answered locally (\d+)`)
+	syslogAuthQueries = regexp.MustCompile(`dnsmasq(?:\[\d+\])?: queries for authoritative zones (\d+)`)
+	syslogServer      = regexp.MustCompile(`dnsmasq(?:\[\d+\])?: server (\S+): queries sent (\d+), retried (?:or failed (\d+)|\d+, failed (\d+))`)
+)
+
+// Lines that can't be part of a stats dump: lines of other programs and queries
+// logged with --log-queries. Other dnsmasq lines may be part of a dump, newer versions
+// log more stats than are read, e.g. "pool memory in use".
+var (
+	syslogDnsmasq = regexp.MustCompile(`dnsmasq(?:\[\d+\])?: `)
+	syslogQuery   = regexp.MustCompile(`dnsmasq(?:\[\d+\])?: query\[`)
+)
+
+// SyslogStatsClient answers stats queries from the most recent stats dump in a log file
+// that dnsmasq logs to, for hosts where the DNS server can't be queried but its logs
+// are available. The address of each query is the path of the log file. dnsmasq logs
+// a stats dump when sent SIGUSR1, e.g.
+//
+//	dnsmasq[123]: cache size 150, 0/10 cache insertions re-used unexpired cache entries.
+//	dnsmasq[123]: queries forwarded 40, queries answered locally 1523
+//	dnsmasq[123]: queries for authoritative zones 2
+//	dnsmasq[123]: server 10.0.0.1#53: queries sent 120, retried or failed 2
+//
+// Lines added to the file since the previous query are read on each query. The file
+// is read from the start again if it's replaced or truncated, such as when it's rotated.
+// Lines that haven't been completely written yet are read again once the rest is
+// written. A new stats dump replaces the previous one once it's complete: when a line
+// that isn't part of it is logged, another dump starts, or the file doesn't grow
+// between queries.
+type SyslogStatsClient struct {
+	lock  sync.Mutex
+	tails map[string]*syslogTail
+}
+
+func NewSyslogStatsClient() *SyslogStatsClient {
+	return &SyslogStatsClient{tails: make(map[string]*syslogTail)}
+}
+
+func (c *SyslogStatsClient) Exchange(m *dns.Msg, address string) (*dns.Msg, time.Duration, error) {
+	r, rtt, _, err := c.ExchangeTransport(m, address)
+	return r, rtt, err
+}
+
+// ExchangeTransport answers each question of the message from the most recent stats
+// dump in the log file at address.
+func (c *SyslogStatsClient) ExchangeTransport(m *dns.Msg, address string) (*dns.Msg, time.Duration, string, error) {
+	start := time.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tail, ok := c.tails[address]
+	if !ok {
+		tail = &syslogTail{path: address}
+		c.tails[address] = tail
+	}
+
+	if err := tail.read(); err != nil {
+		return nil, 0, ProtocolLog, err
+	}
+
+	if tail.values == nil {
+		return nil, 0, ProtocolLog, fmt.Errorf("%w: %s", errNoStatsDump, address)
+	}
+
+	return answerStats(m, tail.values), time.Since(start), ProtocolLog, nil
+}
+
+// syslogTail keeps the position in a log file and the values of the most recent
+// complete stats dump read from it, keyed by the name of the CHAOS TXT query for each.
+// The values of a dump still being read are kept separately until it's complete.
+type syslogTail struct {
+	path    string
+	file    os.FileInfo
+	offset  int64
+	values  map[string][]string
+	pending map[string][]string
+}
+
+// read parses lines added to the file since it was last read.
+func (t *syslogTail) read() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Rotated files are replaced by a new file at the same path, truncated files are
+	// smaller than what has already been read. The values of the last stats dump are
+	// kept until the next one.
+	if t.file == nil || !os.SameFile(t.file, info) || info.Size() < t.offset {
+		t.offset = 0
+		t.pending = nil
+	}
+	t.file = info
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Only complete lines are read, the offset isn't moved past a line that's still
+	// being written so that it's read again along with the rest of it.
+	grew := false
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		t.offset += int64(len(line))
+		grew = true
+		t.parseLine(line[:len(line)-1])
+	}
+
+	if !grew {
+		t.complete()
+	}
+
+	return nil
+}
+
+// parseLine updates the values of the pending stats dump from a line of the log file.
+// The cache size line is the first line of each stats dump so it completes the previous
+// dump and starts a new one. Other lines of the dump are added to it as they're read.
+func (t *syslogTail) parseLine(line string) {
+	if m := syslogCacheSize.FindStringSubmatch(line); m != nil {
+		t.complete()
+		t.pending = map[string][]string{
+			"cachesize.bind.":  {m[1]},
+			"evictions.bind.":  {m[2]},
+			"insertions.bind.": {m[3]},
+		}
+
+		return
+	}
+
+	if t.pending == nil {
+		return
+	}
+
+	if m := syslogQueries.FindStringSubmatch(line); m != nil {
+		t.pending["misses.bind."] = []string{m[1]}
+		t.pending["hits.bind."] = []string{m[2]}
+	} else if m := syslogAuthQueries.FindStringSubmatch(line); m != nil {
+		t.pending["auth.bind."] = []string{m[1]}
+	} else if m := syslogServer.FindStringSubmatch(line); m != nil {
+		failed := m[3]
+		if failed == "" {
+			failed = m[4]
+		}
+
+		t.pending["servers.bind."] = append(t.pending["servers.bind."], m[1]+" "+m[2]+" "+failed)
+	} else if !syslogDnsmasq.MatchString(line) || syslogQuery.MatchString(line) {
+		t.complete()
+	}
+}
+
+// complete replaces the values of the previous stats dump with the pending one, if any.
+func (t *syslogTail) complete() {
+	if t.pending != nil {
+		t.values = t.pending
+		t.pending = nil
+	}
+}
@@ -0,0 +1,177 @@
+package roger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const syslogStatsFixture = `Jan  1 00:00:00 host dnsmasq[123]: started, version 2.85 cachesize 150
+Jan  1 00:01:00 host dnsmasq[123]: time 1609459260
+Jan  1 00:01:00 host dnsmasq[123]: cache size 150, 0/10 cache insertions re-used unexpired cache entries.
+Jan  1 00:01:00 host dnsmasq[123]: queries forwarded 40, queries answered locally 1523
+Jan  1 00:01:00 host dnsmasq[123]: queries for authoritative zones 2
+Jan  1 00:01:00 host dnsmasq[123]: pool memory in use 0, max 0, allocated 0
+Jan  1 00:01:00 host dnsmasq[123]: server 10.0.0.1#53: queries sent 120, retried or failed 2
+Jan  1 00:01:00 host dnsmasq[123]: server 10.0.0.2#53: queries sent 98, retried 3, failed 0, nxdomain replies 4, avg. latency 5ms
+Jan  1 00:01:05 host sshd[456]: Accepted publickey for root
+`
+
+// secondStatsDump is a stats dump logged after syslogStatsFixture.
+const secondStatsDump = `Jan  1 00:02:00 host dnsmasq[123]: cache size 150, 1/12 cache insertions re-used unexpired cache entries.
+Jan  1 00:02:00 host dnsmasq[123]: queries forwarded 45, queries answered locally 1600
+Jan  1 00:02:00 host dnsmasq[123]: queries for authoritative zones 2
+Jan  1 00:02:00 host dnsmasq[123]: server 10.0.0.1#53: queries sent 130, retried or failed 2
+`
+
+// syslogOtherLine is logged by another program, completing any stats dump before it.
+const syslogOtherLine = "Jan  1 00:02:05 host sshd[456]: Accepted publickey for root\n"
+
+func appendLogFixture(t *testing.T, path string, contents string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	_, err = f.WriteString(contents)
+	require.NoError(t, err)
+}
+
+func TestSyslogStatsClient_ReadMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.log")
+	appendLogFixture(t, path, syslogStatsFixture)
+
+	reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+	res, err := reader.ReadMetrics()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(150), res.CacheSize)
+	assert.Equal(t, uint64(10), res.CacheInsertions)
+	assert.Equal(t, uint64(0), res.CacheEvictions)
+	assert.Equal(t, uint64(40), res.CacheMisses)
+	assert.Equal(t, uint64(1523), res.CacheHits)
+	assert.Equal(t, uint64(2), res.Authoritative)
+	assert.Equal(t, ProtocolLog, res.Transport)
+	assert.Equal(t, []ServerStats{
+		{Address: "10.0.0.1#53", QueriesSent: 120, QueryErrors: 2, Family: "ipv4"},
+		{Address: "10.0.0.2#53", QueriesSent: 98, QueryErrors: 0, Family: "ipv4"},
+	}, res.Servers)
+}
+
+func TestSyslogStatsClient_Tail(t *testing.T) {
+	t.Run("new stats dump", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dnsmasq.log")
+		appendLogFixture(t, path, syslogStatsFixture)
+
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		require.NoError(t, err)
+
+		// Nothing is logged after the new dump so it's only used once the file
+		// doesn't change between collections, more lines could still be written.
+		appendLogFixture(t, path, secondStatsDump)
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1523), res.CacheHits)
+
+		res, err = reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1600), res.CacheHits)
+		assert.Equal(t, uint64(1), res.CacheEvictions)
+		assert.Equal(t, []ServerStats{{Address: "10.0.0.1#53", QueriesSent: 130, QueryErrors: 2, Family: "ipv4"}}, res.Servers)
+	})
+
+	t.Run("completed by query", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dnsmasq.log")
+		appendLogFixture(t, path, syslogStatsFixture)
+
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		require.NoError(t, err)
+
+		appendLogFixture(t, path, secondStatsDump+"Jan  1 00:02:01 host dnsmasq[123]: query[A] example.com from 10.0.0.5\n")
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1600), res.CacheHits)
+	})
+
+	t.Run("partial lines", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dnsmasq.log")
+		appendLogFixture(t, path, syslogStatsFixture)
+
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		require.NoError(t, err)
+
+		// The line with the number of queries is cut off in the middle of the number
+		// of hits, the rest of the line is written later. Values of the incomplete
+		// dump aren't used in the meantime.
+		appendLogFixture(t, path, secondStatsDump[:190])
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), res.CacheEvictions)
+		assert.Equal(t, uint64(1523), res.CacheHits)
+
+		appendLogFixture(t, path, secondStatsDump[190:])
+		_, err = reader.ReadMetrics()
+		require.NoError(t, err)
+
+		res, err = reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), res.CacheEvictions)
+		assert.Equal(t, uint64(1600), res.CacheHits)
+		assert.Equal(t, uint64(45), res.CacheMisses)
+	})
+
+	t.Run("rotated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dnsmasq.log")
+		appendLogFixture(t, path, syslogStatsFixture)
+
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		require.NoError(t, err)
+
+		require.NoError(t, os.Rename(path, path+".1"))
+		appendLogFixture(t, path, secondStatsDump+syslogOtherLine)
+
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1600), res.CacheHits)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dnsmasq.log")
+		appendLogFixture(t, path, syslogStatsFixture)
+
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		require.NoError(t, err)
+
+		require.NoError(t, os.Truncate(path, 0))
+		appendLogFixture(t, path, secondStatsDump+syslogOtherLine)
+
+		res, err := reader.ReadMetrics()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1600), res.CacheHits)
+	})
+}
+
+func TestSyslogStatsClient_Errors(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), filepath.Join(t.TempDir(), "dnsmasq.log"), log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		assert.ErrorIs(t, err, ErrUpstream)
+	})
+
+	t.Run("no stats dump", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dnsmasq.log")
+		appendLogFixture(t, path, "Jan  1 00:00:00 host dnsmasq[123]: started, version 2.85 cachesize 150\n")
+
+		reader := NewDnsmasqReader(NewSyslogStatsClient(), path, log.NewNopLogger())
+		_, err := reader.ReadMetrics()
+		assert.ErrorIs(t, err, errNoStatsDump)
+	})
+}
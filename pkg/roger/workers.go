@@ -0,0 +1,78 @@
+// Roger - DNS and network metrics exporter for Prometheus
+//
+// Copyright 2020-2021 Nick Pillitteri
+//
+// Licensed under the Apache License, Version 2.0 <LICENSE-APACHE or
+// http://www.apache.org/licenses/LICENSE-2.0> or the MIT license
+// <LICENSE-MIT or http://opensource.org/licenses/MIT>, at your
+// option. This file may not be copied, modified, or distributed
+// except according to those terms.
+
+package roger
+
+// bound how many collectors run at once during a scrape
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// CollectWorkers limits how many collectors run at once. The registry collects from
+// every collector in its own goroutine when scraped and all of them write to the same
+// channel, so with many collectors on a host with few CPUs they mostly compete with
+// each other. Collectors wrapped by the same CollectWorkers instead wait for one of a
+// fixed number of workers to be free before collecting.
+type CollectWorkers struct {
+	slots chan struct{}
+}
+
+// NewCollectWorkers creates workers allowing n collectors to run at once, without
+// any limit if n is 0 or less.
+func NewCollectWorkers(n int) *CollectWorkers {
+	if n <= 0 {
+		return &CollectWorkers{}
+	}
+
+	return &CollectWorkers{slots: make(chan struct{}, n)}
+}
+
+// Size returns the number of collectors allowed to run at once, 0 for no limit.
+func (w *CollectWorkers) Size() int {
+	return cap(w.slots)
+}
+
+// acquire waits for a worker to be free, returning a function to free it again.
+func (w *CollectWorkers) acquire() func() {
+	if w.slots == nil {
+		return func() {}
+	}
+
+	w.slots <- struct{}{}
+	return func() { <-w.slots }
+}
+
+// WorkerCollector wraps another collector, only collecting from it while holding one
+// of the workers of a CollectWorkers.
+type WorkerCollector struct {
+	collector ErrorCollector
+	workers   *CollectWorkers
+}
+
+// Collector wraps the collector so that it's only collected from by one of the workers.
+func (w *CollectWorkers) Collector(collector ErrorCollector) *WorkerCollector {
+	return &WorkerCollector{collector: collector, workers: w}
+}
+
+func (c *WorkerCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.collector.Describe(ch)
+}
+
+func (c *WorkerCollector) Collect(ch chan<- prometheus.Metric) {
+	defer c.workers.acquire()()
+
+	// The wrapped collector logs its own errors
+	c.collector.Collect(ch)
+}
+
+func (c *WorkerCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	defer c.workers.acquire()()
+
+	return c.collector.CollectWithError(ch)
+}
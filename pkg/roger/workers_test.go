@@ -0,0 +1,136 @@
+package roger
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// busyCollector takes some time to collect, keeping track of the most collectors
+// shared with it that were collecting at once.
+type busyCollector struct {
+	desc     *prometheus.Desc
+	work     func()
+	inFlight *atomic.Int32
+	max      *atomic.Int32
+}
+
+func newBusyCollectors(n int, work func()) []*busyCollector {
+	var inFlight, max atomic.Int32
+
+	out := make([]*busyCollector, n)
+	for i := range out {
+		out[i] = &busyCollector{
+			desc:     prometheus.NewDesc(fmt.Sprintf("roger_test_busy_%d", i), "Test busy", nil, nil),
+			work:     work,
+			inFlight: &inFlight,
+			max:      &max,
+		}
+	}
+
+	return out
+}
+
+func (b *busyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.desc
+}
+
+func (b *busyCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = b.CollectWithError(ch)
+}
+
+func (b *busyCollector) CollectWithError(ch chan<- prometheus.Metric) error {
+	n := b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+
+	for {
+		max := b.max.Load()
+		if n <= max || b.max.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	b.work()
+	ch <- prometheus.MustNewConstMetric(b.desc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestNewCollectWorkers(t *testing.T) {
+	assert.Equal(t, 3, NewCollectWorkers(3).Size())
+	assert.Equal(t, 0, NewCollectWorkers(0).Size())
+}
+
+func TestCollectWorkers_Collector(t *testing.T) {
+	busy := newBusyCollectors(8, func() { time.Sleep(20 * time.Millisecond) })
+	workers := NewCollectWorkers(2)
+
+	registry := prometheus.NewRegistry()
+	for _, b := range busy {
+		registry.MustRegister(workers.Collector(b))
+	}
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.Len(t, families, 8)
+	assert.Equal(t, int32(2), busy[0].max.Load())
+}
+
+func TestCollectWorkers_Unlimited(t *testing.T) {
+	busy := newBusyCollectors(8, func() { time.Sleep(20 * time.Millisecond) })
+	workers := NewCollectWorkers(0)
+
+	registry := prometheus.NewRegistry()
+	for _, b := range busy {
+		registry.MustRegister(workers.Collector(b))
+	}
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.Len(t, families, 8)
+	assert.Greater(t, busy[0].max.Load(), int32(2))
+}
+
+// spin uses the CPU for a while like a collector parsing a large /proc file.
+func spin() {
+	sum := 0
+	for i := 0; i < 200_000; i++ {
+		sum += i % 7
+	}
+
+	runtime.KeepAlive(sum)
+}
+
+// BenchmarkCollectWorkers gathers from many collectors that each use the CPU for a
+// while, reporting the time taken per scrape with different numbers of workers as
+// well as without any limit.
+func BenchmarkCollectWorkers(b *testing.B) {
+	const collectors = 64
+
+	for _, n := range []int{1, 4, runtime.GOMAXPROCS(0), 0} {
+		name := fmt.Sprintf("workers=%d", n)
+		if n == 0 {
+			name = "unlimited"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			workers := NewCollectWorkers(n)
+			registry := prometheus.NewRegistry()
+			for _, c := range newBusyCollectors(collectors, spin) {
+				registry.MustRegister(workers.Collector(c))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := registry.Gather(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
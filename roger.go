@@ -11,16 +11,29 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/56quarters/roger/pkg/roger"
@@ -33,6 +46,9 @@ var (
 	Revision string
 )
 
+// shutdownTimeout is how long in-flight requests are given to finish when shutting down.
+const shutdownTimeout = 5 * time.Second
+
 const indexTpt = `
 <!doctype html>
 <html>
@@ -44,29 +60,464 @@ const indexTpt = `
 </html>
 `
 
-func setupLogger(l level.Option) log.Logger {
-	logger := log.NewSyncLogger(log.NewLogfmtLogger(os.Stderr))
-	logger = level.NewFilter(logger, l)
+func setupLogger(base log.Logger, l level.Option) log.Logger {
+	logger := level.NewFilter(base, l)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 	return logger
 }
 
+// levelOption returns an option allowing messages at the given level (debug, info, warn,
+// or error) or above. The level is assumed to be valid since it's validated by kingpin.
+func levelOption(l string) level.Option {
+	v, _ := level.Parse(l)
+	return level.Allow(v)
+}
+
+// parseTransports parses a comma separated list of protocols to query the DNS server with.
+func parseTransports(s string) ([]string, error) {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		switch p {
+		case roger.ProtocolUDP, roger.ProtocolTCP, roger.ProtocolTLS:
+			out = append(out, p)
+		default:
+			return nil, fmt.Errorf("unsupported protocol %q", p)
+		}
+	}
+
+	return out, nil
+}
+
+// parseBuckets parses a comma separated list of histogram buckets, which must be
+// in increasing order.
+func parseBuckets(s string) ([]float64, error) {
+	var out []float64
+	for _, b := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(out) > 0 && v <= out[len(out)-1] {
+			return nil, fmt.Errorf("buckets must be in increasing order, got %v after %v", v, out[len(out)-1])
+		}
+
+		out = append(out, v)
+	}
+
+	return out, nil
+}
+
+// joinBuckets formats histogram buckets as a comma separated list.
+func joinBuckets(buckets []float64) string {
+	parts := make([]string, len(buckets))
+	for i, b := range buckets {
+		parts[i] = strconv.FormatFloat(b, 'g', -1, 64)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// promLogger adapts a go-kit logger to the logger interface used by promhttp to report
+// errors gathering or encoding metrics.
+type promLogger struct {
+	logger log.Logger
+}
+
+func (l promLogger) Println(v ...interface{}) {
+	level.Error(l.logger).Log("msg", fmt.Sprint(v...))
+}
+
+// errNotExported is returned by debug endpoints for a server or file that Roger doesn't
+// export metrics for, such as an unknown ?variant=.
+var errNotExported = errors.New("no metrics exported")
+
+// jsonHandler returns a handler that writes the result of read as JSON or responds
+// with an error if read fails: a 404 if read returns errNotExported, a 500 otherwise.
+func jsonHandler(logger log.Logger, read func(r *http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := read(r)
+		if errors.Is(err, errNotExported) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			level.Error(logger).Log("msg", "failed to read values for debug endpoint", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			level.Error(logger).Log("msg", "failed to encode values for debug endpoint", "path", r.URL.Path, "err", err)
+		}
+	})
+}
+
+// configFilePath returns the value of --config.file from the command line, if set, so
+// that the file can be loaded before the rest of the flags are parsed.
+func configFilePath(kp *kingpin.Application, args []string) string {
+	ctx, err := kp.ParseContext(args)
+	if err != nil {
+		// Invalid flags are reported when parsing the flags for real
+		return ""
+	}
+
+	for _, el := range ctx.Elements {
+		if f, ok := el.Clause.(*kingpin.FlagClause); ok && f.Model().Name == "config.file" && el.Value != nil {
+			return *el.Value
+		}
+	}
+
+	return ""
+}
+
+// serve starts an HTTP server on each address using the default mux. It blocks until
+// any of the servers fail or ctx is canceled and then shuts down all of them, returning
+// errors from each server that failed.
+func serve(ctx context.Context, logger log.Logger, addrs []string) error {
+	// Listen on every address before serving on any of them so that a bad address
+	// is reported at startup instead of leaving the other servers running alone.
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, open := range listeners {
+				_ = open.Close()
+			}
+			return err
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	servers := make([]*http.Server, len(listeners))
+	errCh := make(chan error, len(listeners))
+	for i, l := range listeners {
+		servers[i] = &http.Server{}
+		go func(s *http.Server, l net.Listener) {
+			level.Info(logger).Log("msg", "serving metrics", "addr", l.Addr())
+			if err := s.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("server on %s failed: %w", l.Addr(), err)
+				return
+			}
+
+			errCh <- nil
+		}(servers[i], l)
+	}
+
+	var errs []error
+	remaining := len(servers)
+
+	select {
+	case <-ctx.Done():
+		level.Info(logger).Log("msg", "shutting down")
+	case err := <-errCh:
+		errs = append(errs, err)
+		remaining--
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for ; remaining > 0; remaining-- {
+		errs = append(errs, <-errCh)
+	}
+
+	return errors.Join(errs...)
+}
+
+// reloadOnHangup reloads the interface aliases each time Roger receives SIGHUP until
+// ctx is canceled. The previous aliases are kept if the file can't be reloaded.
+func reloadOnHangup(ctx context.Context, logger log.Logger, aliases *roger.InterfaceAliases) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := aliases.Reload(); err != nil {
+				level.Warn(logger).Log("msg", "failed to reload interface aliases, keeping previous aliases", "path", aliases.Path(), "err", err)
+			} else {
+				level.Info(logger).Log("msg", "reloaded interface aliases", "path", aliases.Path())
+			}
+		}
+	}
+}
+
 func main() {
-	logger := setupLogger(level.AllowInfo())
+	baseLogger := log.NewSyncLogger(log.NewLogfmtLogger(os.Stderr))
+	logger := setupLogger(baseLogger, level.AllowInfo())
 
 	kp := kingpin.New(os.Args[0], "Roger: DNS and network metrics exporter for Prometheus")
+	kp.Flag("config.file", "Path to a YAML file with settings to use instead of flag defaults. Flags set on the command line take precedence").String()
+	logLevel := kp.Flag("log.level", "Minimum level of log messages to output (debug, info, warn, error)").Default("info").Enum("debug", "info", "warn", "error")
+	logLevelDnsmasq := kp.Flag("log.level.dnsmasq", "Minimum log level for the dnsmasq collectors, overriding --log.level").Enum("debug", "info", "warn", "error")
+	logLevelProcess := kp.Flag("log.level.process", "Minimum log level for the DNS process collector, overriding --log.level").Enum("debug", "info", "warn", "error")
+	logLevelNetDev := kp.Flag("log.level.netdev", "Minimum log level for the net/dev collector, overriding --log.level").Enum("debug", "info", "warn", "error")
+	logLevelNetStat := kp.Flag("log.level.netstat", "Minimum log level for the net/stat collectors, overriding --log.level").Enum("debug", "info", "warn", "error")
 	metricsPath := kp.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-	webAddr := kp.Flag("web.listen-address", "Address and port to expose Prometheus metrics on").Default(":9779").String()
-	dnsServer := kp.Flag("dns.server", "DNS server to export metrics for, including port").Default("127.0.0.1:53").String()
+	webAddrs := kp.Flag("web.listen-address", "Address and port to expose Prometheus metrics on. May be repeated to listen on multiple addresses.").Default(":9779").Strings()
+	webDebug := kp.Flag("web.debug", "Expose endpoints under /debug with the raw values read by collectors, as JSON").Bool()
+	webOpenMetrics := kp.Flag("web.enable-openmetrics", "Serve metrics in the OpenMetrics format when requested by the scraper").Bool()
+	webDisableDefaults := kp.Flag("web.disable-default-collectors", "Only expose Roger metrics, not the default Go runtime and process metrics").Bool()
+	collectorGo := kp.Flag("collector.go", "Export Go runtime metrics for Roger itself. Use --no-collector.go to disable, always disabled with --web.disable-default-collectors").Default("true").Bool()
+	collectorProcess := kp.Flag("collector.process", "Export process metrics for Roger itself. Use --no-collector.process to disable, always disabled with --web.disable-default-collectors").Default("true").Bool()
+	metricAllowlist := kp.Flag("metric.allowlist", "Regular expression matching names of Roger metrics to export, all others are dropped. May be repeated.").Strings()
+	metricDenylist := kp.Flag("metric.denylist", "Regular expression matching names of Roger metrics to drop, applied after --metric.allowlist. May be repeated.").Strings()
+	metricInstanceLabel := kp.Flag("metric.instance-label", "Label added to every metric to tell apart metrics from many hosts once aggregated, either name=value (e.g. host=db1) or a value for the instance label").String()
+	collectorHost := kp.Flag("collector.host", "Export basic vitals (load average and memory) of the host Roger is running on").Bool()
+	collectorSysNet := kp.Flag("collector.sysnet", "Export the MTU, transmit queue length, and number of carrier changes of each interface from /sys/class/net, for the same interfaces as net/dev metrics").Bool()
+	collectorConntrack := kp.Flag("collector.conntrack", "Export the number of entries in /proc/net/nf_conntrack by protocol and state. Reading the table can be slow on busy firewalls").Bool()
+	conntrackMaxEntries := kp.Flag("conntrack.max-entries", "Most entries of /proc/net/nf_conntrack to read on each collection, -1 for no limit").Default(strconv.Itoa(roger.DefaultConntrackMaxEntries)).Int()
+	conntrackTimeout := kp.Flag("conntrack.timeout", "Most time spent reading /proc/net/nf_conntrack on each collection, collection fails if the table can't be read in time. 0 for no limit").Default("0s").Duration()
+	collectInterval := kp.Flag("collect.interval", "Collect metrics in the background on this interval instead of when scraped, 0 to disable").Default("0s").Duration()
+	dnsInterval := kp.Flag("dns.interval", "Collect DNS server metrics in the background on this interval, e.g. to query a remote server less often than /proc is read. Defaults to --collect.interval").Default("0s").Duration()
+	procInterval := kp.Flag("proc.interval", "Collect /proc and /sys metrics in the background on this interval. Defaults to --collect.interval").Default("0s").Duration()
+	once := kp.Flag("once", "Collect metrics a single time, print them, and exit instead of serving them. Exits nonzero if any collector failed, for use as a check in scripts").Bool()
+	collectTimeout := kp.Flag("collect.timeout", "Most time spent reading each of net/dev, net/stat, softnet_stat, loadavg, and meminfo on each collection, collection fails if a file can't be read in time. --conntrack.timeout is used for the conntrack table instead. 0 for no limit").Default("0s").Duration()
+	collectWorkers := kp.Flag("collect.workers", "Most /proc and /sys readers to run at once when scraped, 0 for no limit. DNS servers are limited by --dns.max-concurrency instead and readers with a background interval aren't limited").Default("0").Int()
+	collectWarmup := kp.Flag("collect.warmup", "Collect metrics once at startup so that the first scrape is as fast as the rest").Bool()
+	startupGrace := kp.Flag("startup.grace", "How long after startup to log DNS scrape errors at debug level and report not ready from /readyz, unless a scrape succeeds first. 0 to disable").Default("0s").Duration()
+	dnsServers := kp.Flag("dns.server", "DNS server to export metrics for, including port. May be repeated.").Default("127.0.0.1:53").Strings()
+	dnsProtocol := kp.Flag("dns.protocol", "Protocol to query the DNS server with (udp, tcp, or tcp-tls for DNS over TLS)").Default(roger.ProtocolUDP).Enum(roger.ProtocolUDP, roger.ProtocolTCP, roger.ProtocolTLS)
+	dnsTransports := kp.Flag("dns.transport", "Comma separated list of protocols (udp, tcp, tcp-tls) to try in order until one returns a complete response, e.g. udp,tcp. Defaults to --dns.protocol").String()
+	dnsStatsFile := kp.Flag("dns.stats-file", "Read DNS server stats from a file of \"name value\" lines, e.g. \"hits.bind. 1523\", written by another job instead of querying --dns.server").String()
+	dnsmasqLogFile := kp.Flag("dnsmasq.log-file", "Read DNS server stats from the stats dumps dnsmasq logs when sent SIGUSR1 to this file instead of querying --dns.server, following the file as it's written and rotated").String()
+	dnsTLSServerName := kp.Flag("dns.tls-servername", "Server name to use for DNS over TLS, defaults to the host of --dns.server").String()
+	dnsEDNS0Size := kp.Flag("dns.edns0-size", "UDP buffer size to advertise to the DNS server via EDNS0, 0 to disable EDNS0").Default("0").Uint16()
+	dnsRecursion := kp.Flag("dns.recursion-desired", "Set the recursion desired (RD) bit on stats queries. Use --no-dns.recursion-desired for servers that reject it").Default("true").Bool()
+	dnsLogRawAnswers := kp.Flag("dns.log-raw-answers", "Log the name and contents of each answer from the DNS server at debug level").Bool()
+	dnsIdentity := kp.Flag("dns.identity", "Identity to send in an EDNS0 NSID option of stats queries so that DNS server logs can attribute them to Roger").String()
+	dnsDetectAnomalies := kp.Flag("dns.detect-counter-anomalies", "Log and count in roger_dns_counter_anomaly_total counters from the DNS server that decrease without the server restarting").Bool()
+	dnsMetricLayout := kp.Flag("dns.metric-layout", "Layout of DNS cache metrics, separate for a metric per event such as roger_dns_cache_hits_total or labeled for roger_dns_cache_events_total with an event label").Default(roger.DnsMetricLayoutSeparate).Enum(roger.DnsMetricLayoutSeparate, roger.DnsMetricLayoutLabeled)
+	dnsLabelHostname := kp.Flag("dns.label-by-hostname", "Query hostname.bind. at startup and use the hostname the DNS server reports as the value of the server label instead of its address").Bool()
+	dnsDetect := kp.Flag("dns.detect", "Query version.bind. at startup and only export DNS server metrics if the server matches --dns.flavor").Bool()
+	dnsFlavor := kp.Flag("dns.flavor", "Name of the DNS server software expected in the version.bind. response when using --dns.detect").Default("dnsmasq").String()
+	dnsQueryClass := kp.Flag("dns.qclass", "Class of DNS stats queries, chaos as used by dnsmasq or inet for resolvers that answer the same names in the INET class").Default(roger.QueryClassChaos).Enum(roger.QueryClassChaos, roger.QueryClassINET)
+	dnsMaxConcurrency := kp.Flag("dns.max-concurrency", "Most DNS servers queried at once during a collection, others wait for their turn. Applies across all servers, including groups of servers with different labels from the config file. 0 for no limit").Default(strconv.Itoa(roger.DefaultPoolConcurrency)).Int()
+	dnsInfoTTL := kp.Flag("dns.info-ttl", "How long the DNS server version from version.bind. is cached before querying it again").Default(roger.DefaultInfoTTL.String()).Duration()
+	dnsRTTBuckets := kp.Flag("dns.rtt-buckets", "Comma separated buckets, in seconds, of the stats query RTT histogram").Default(joinBuckets(roger.DefaultRTTBuckets)).String()
+	dnsExtraQueries := kp.Flag("dns.extra-queries", "Name of an additional <name>.bind. counter to query and export as roger_dns_<name>, for counters only exposed by some builds. May be repeated.").Strings()
+	dnsUpstreamInclude := kp.Flag("dns.upstream-include", "Regular expression matching addresses of upstream servers, as reported by the DNS server, to export roger_dns_upstream_* metrics for. May be repeated.").Strings()
+	dnsUpstreamExclude := kp.Flag("dns.upstream-exclude", "Regular expression matching addresses of upstream servers to not export roger_dns_upstream_* metrics for, applied after --dns.upstream-include. May be repeated.").Strings()
+	dnsPid := kp.Flag("dns.pid", "PID of the DNS server process to export process metrics for").Int()
+	dnsPidFile := kp.Flag("dns.pidfile", "Path to the PID file of the DNS server process to export process metrics for").String()
+	procEnabled := kp.Flag("proc", "Export metrics read from /proc and /sys. Use --no-proc to only export DNS server metrics and skip reading either file system").Default("true").Bool()
 	procPath := kp.Flag("proc.path", "Path to the proc file system to scrape metrics from").Default("/proc").String()
+	sysPath := kp.Flag("sys.path", "Path to the sys file system to read interface attributes from").Default("/sys").String()
+	netDevUpOnly := kp.Flag("netdev.up-only", "Only export /proc/net/dev metrics for interfaces that are up according to sysfs").Bool()
+	netDevIncludeCIDRs := kp.Flag("netdev.include-cidr", "Only export /proc/net/dev metrics for interfaces with an address in this range, e.g. 10.0.0.0/8. May be repeated.").Strings()
+	netDevBondAggregate := kp.Flag("netdev.bond-aggregate", "Export /proc/net/dev metrics summing the members of each bond interface as \"<bond>:aggregate\"").Bool()
+	netDevTotalsExclude := kp.Flag("netdev.totals-exclude", "Regular expression matching interfaces to leave out of the roger_net_*_all totals, e.g. bond.* to avoid counting bonded traffic twice").String()
+	netDevNormalizeNames := kp.Flag("netdev.normalize-names", "Strip everything from \"@\" onward in interface names, e.g. \"veth1a2b@if12\" becomes \"veth1a2b\", so series from net/dev and sysfs join cleanly").Bool()
+	netDevLinkFaults := kp.Flag("netdev.link-faults", "Also export carrier errors, collisions, and framing errors from /proc/net/dev as roger_net_link_faults_total{interface,kind} for alerting on any kind of link fault").Bool()
+	netDevDetectWrap := kp.Flag("netdev.detect-32bit-wrap", "Correct /proc/net/dev counters of NICs that wrap at 2^32 so that they keep increasing instead of looking like resets").Bool()
+	netDevAliasFile := kp.Flag("netdev.alias-file", "File of \"interface alias\" lines, e.g. \"enp3s0 wan\", adding an alias label to /proc/net/dev metrics. Reloaded on SIGHUP").String()
+	netDevSubsystemStyle := kp.Flag("netdev.subsystem-style", "Wording of /proc/net/dev metric names, rxtx for roger_net_rx_bytes or receive-transmit for roger_net_receive_bytes as used by node_exporter").Default(roger.NetDevStyleRxTx).Enum(roger.NetDevStyleRxTx, roger.NetDevStyleReceiveTransmit)
+	replayTimestamp := kp.Flag("replay.timestamp", "RFC 3339 time to use as the timestamp of all Roger metrics, e.g. 2021-03-01T12:00:00Z, when exporting captured /proc or DNS stats snapshots to backfill").String()
+	otlpEndpoint := kp.Flag("otlp.endpoint", "URL of an OTLP/HTTP receiver to periodically push metrics to in addition to serving them, e.g. http://localhost:4318/v1/metrics").String()
+	otlpInterval := kp.Flag("otlp.interval", "How often to push metrics to --otlp.endpoint").Default("30s").Duration()
+	graphiteAddress := kp.Flag("graphite.address", "Host and port of a Graphite carbon plaintext receiver to periodically push metrics to in addition to serving them, e.g. localhost:2003").String()
+	graphiteInterval := kp.Flag("graphite.interval", "How often to push metrics to --graphite.address").Default("30s").Duration()
+	netStatVariants := kp.Flag("proc.netstat", "Files under /proc/net/stat to export metrics for, if they exist. May be repeated.").Default("nf_conntrack", "ip_conntrack", "arp_cache", "ndisc_cache", "rt_cache").Strings()
+	netStatNormalize := kp.Flag("netstat.normalize-per-cpu", "Divide /proc/net/stat values summed across CPUs by the number of CPUs and export the per-CPU averages as gauges, for comparing hosts with different numbers of CPUs").Bool()
+
+	// Settings from the config file replace the defaults of the corresponding flags so
+	// that flags set on the command line take precedence and values are parsed the same
+	// way regardless of where they come from.
+	args := os.Args[1:]
+	var (
+		dnsServerLabels roger.DNSServerLabels
+		netStatFiles    []roger.NetStatFileConfig
+	)
+	if path := configFilePath(kp, args); path != "" {
+		cfg, err := roger.LoadConfig(path)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load config file", "err", err)
+			os.Exit(1)
+		}
+
+		for name, values := range cfg.Flags() {
+			kp.GetFlag(name).Default(values...)
+		}
+
+		dnsServerLabels = cfg.DNSServerLabels()
+		netStatFiles = cfg.Proc.NetStatFiles
+	}
 
-	_, err := kp.Parse(os.Args[1:])
+	_, err := kp.Parse(args)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to parse CLI options", "err", err)
 		os.Exit(1)
 	}
 
-	registry := prometheus.DefaultRegisterer
+	logger = setupLogger(baseLogger, levelOption(*logLevel))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rttBuckets, err := parseBuckets(*dnsRTTBuckets)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid DNS RTT buckets", "buckets", *dnsRTTBuckets, "err", err)
+		os.Exit(1)
+	}
+
+	var replayAt time.Time
+	if *replayTimestamp != "" {
+		replayAt, err = time.Parse(time.RFC3339, *replayTimestamp)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid replay timestamp", "timestamp", *replayTimestamp, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	includeCIDRs := make([]netip.Prefix, len(*netDevIncludeCIDRs))
+	for i, c := range *netDevIncludeCIDRs {
+		includeCIDRs[i], err = netip.ParsePrefix(c)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid interface CIDR", "cidr", c, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Anchored the same way as the metric allowlist and denylist
+	totalsExclude, err := regexp.Compile("^(?:" + *netDevTotalsExclude + ")$")
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid interface totals exclusion", "regexp", *netDevTotalsExclude, "err", err)
+		os.Exit(1)
+	}
+
+	var instanceLabels prometheus.Labels
+	if *metricInstanceLabel != "" {
+		name, value, err := roger.ParseInstanceLabel(*metricInstanceLabel)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid instance label", "label", *metricInstanceLabel, "err", err)
+			os.Exit(1)
+		}
+
+		for server, labels := range dnsServerLabels {
+			if _, ok := labels[name]; ok {
+				level.Error(logger).Log("msg", "instance label is also a label of a DNS server in the config file", "label", name, "server", server)
+				os.Exit(1)
+			}
+		}
+
+		instanceLabels = prometheus.Labels{name: value}
+	}
+
+	metricFilter, err := roger.NewMetricFilter(*metricAllowlist, *metricDenylist)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid metric allowlist or denylist", "err", err)
+		os.Exit(1)
+	}
+
+	// Upstream addresses are matched the same way as metric names, with patterns
+	// that must match the entire address.
+	upstreamFilter, err := roger.NewMetricFilter(*dnsUpstreamInclude, *dnsUpstreamExclude)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid DNS upstream include or exclude", "err", err)
+		os.Exit(1)
+	}
+
+	var extraQueries []string
+	for _, name := range *dnsExtraQueries {
+		name = strings.TrimSuffix(name, ".bind.")
+		if err := roger.CheckExtraQuery(name); err != nil {
+			level.Error(logger).Log("msg", "invalid DNS extra query", "name", name, "err", err)
+			os.Exit(1)
+		}
+
+		extraQueries = append(extraQueries, name)
+	}
+
+	transports := []string{*dnsProtocol}
+	if *dnsTransports != "" {
+		transports, err = parseTransports(*dnsTransports)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid DNS transports", "transports", *dnsTransports, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Each collector can have its own log level to allow debugging one of them
+	// without being flooded by log messages from the others.
+	collectorLogger := func(name string, override string) log.Logger {
+		if override == "" {
+			override = *logLevel
+		}
+
+		return log.With(setupLogger(baseLogger, levelOption(override)), "collector", name)
+	}
+
+	var (
+		registry prometheus.Registerer = prometheus.DefaultRegisterer
+		gatherer prometheus.Gatherer   = prometheus.DefaultGatherer
+		handler  http.Handler
+	)
+
+	// Errors from collectors are logged and the metrics that could be gathered are
+	// still returned instead of failing the entire scrape, along with a comment for
+	// each collector that failed. Responses are compressed after the comments are
+	// added instead of by promhttp.
+	collectorStatus := roger.NewCollectorStatus()
+	handlerOpts := promhttp.HandlerOpts{
+		ErrorLog:           promLogger{logger: logger},
+		ErrorHandling:      promhttp.ContinueOnError,
+		DisableCompression: true,
+	}
+
+	if *webOpenMetrics {
+		handlerOpts.EnableOpenMetrics = true
+	}
+
+	// The Go and process collectors of the default registry can't be removed and don't
+	// have the instance label, so a new registry is used with the enabled ones
+	// registered again if either is disabled or the label is set.
+	exportGo := *collectorGo && !*webDisableDefaults
+	exportProcess := *collectorProcess && !*webDisableDefaults
+	customRegistry := !exportGo || !exportProcess || len(instanceLabels) > 0
+	if customRegistry {
+		custom := prometheus.NewRegistry()
+		registry = custom
+		gatherer = custom
+	}
+
+	// Every metric registered from here on, including those of the handler itself,
+	// has the instance label.
+	if len(instanceLabels) > 0 {
+		registry = prometheus.WrapRegistererWith(instanceLabels, registry)
+	}
+
+	if customRegistry && exportGo {
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+
+	if customRegistry && exportProcess {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	// Metrics are dropped by name once gathered so that every consumer of them, such
+	// as the OTLP and Graphite pushers, gets the same metrics as scrapes.
+	if len(*metricAllowlist) > 0 || len(*metricDenylist) > 0 {
+		gatherer = roger.NewFilteredGatherer(gatherer, metricFilter)
+	}
+
+	if *webDisableDefaults {
+		handler = promhttp.HandlerFor(gatherer, handlerOpts)
+	} else {
+		handler = promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(gatherer, handlerOpts))
+	}
+	handler = collectorStatus.Handler(handler)
 
 	versionInfo := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: "roger",
@@ -81,22 +532,469 @@ func main() {
 	}, func() float64 { return 1 })
 	registry.MustRegister(versionInfo)
 
-	dnsmasqReader := roger.NewDnsmasqReader(new(dns.Client), *dnsServer, logger)
-	registry.MustRegister(dnsmasqReader)
+	// Configured DNS servers and enabled collectors are exported as soon as Roger
+	// starts, before and regardless of whether collecting from them succeeds.
+	inventory := roger.NewInventoryCollector()
+	registry.MustRegister(inventory)
+
+	// DNS and /proc readers can be collected from on their own intervals, each falling
+	// back to --collect.interval.
+	intervalOr := func(interval time.Duration) time.Duration {
+		// Everything is collected when gathered a single time, not in the background
+		if *once {
+			return 0
+		}
+
+		if interval > 0 {
+			return interval
+		}
+
+		return *collectInterval
+	}
+
+	// Readers are either collected from when scraped or, if an interval is set,
+	// collected from in the background with scrapes returning the latest values.
+	// Each background reader has its own poller and timer. Readers collected from
+	// when scraped can share a fixed number of workers, nil for no limit.
+	registerWith := func(registry prometheus.Registerer, name string, c roger.ErrorCollector, interval time.Duration, workers *roger.CollectWorkers, logger log.Logger) {
+		c = collectorStatus.Collector(name, c, logger)
+
+		if !replayAt.IsZero() {
+			c = roger.NewTimestampedCollector(c, replayAt)
+		}
+
+		if interval <= 0 {
+			if workers != nil {
+				c = workers.Collector(c)
+			}
+
+			registry.MustRegister(c)
+
+			if *collectWarmup {
+				if err := roger.Warmup(c); err != nil {
+					level.Warn(logger).Log("msg", "failed to warm up collector", "collector", name, "err", err)
+				}
+			}
+
+			return
+		}
+
+		poller := roger.NewPoller(name, c, interval, logger)
+		registry.MustRegister(poller)
+		go poller.Run(ctx)
+	}
+
+	// All readers other than the DNS server pools read /proc or /sys. They only use the
+	// CPU and local files so they share workers, DNS servers are queried over the network
+	// and limited separately so that a slow server can't hold up the readers.
+	var procWorkers *roger.CollectWorkers
+	if *collectWorkers > 0 {
+		procWorkers = roger.NewCollectWorkers(*collectWorkers)
+	}
+
+	register := func(name string, c roger.ErrorCollector, logger log.Logger) {
+		inventory.AddCollector(name)
+		registerWith(registry, name, c, intervalOr(*procInterval), procWorkers, logger)
+	}
+
+	// Errors from the DNS server are expected if it's started at the same time as
+	// Roger so they're quieted until it responds or the grace period is over.
+	startupGate := roger.NewStartupGate(*startupGrace)
+	dnsmasqLogger := startupGate.Logger(collectorLogger("dnsmasq", *logLevelDnsmasq))
+	// The flag only allows known classes
+	queryClass, _ := roger.ParseQueryClass(*dnsQueryClass)
+	dnsmasqOpts := []roger.DnsmasqOption{roger.WithProtocol(transports...), roger.WithTLSServerName(*dnsTLSServerName), roger.WithQueryClass(queryClass)}
+	configureDnsmasqReader := func(reader *roger.DnsmasqReader) {
+		reader.EDNS0Size = *dnsEDNS0Size
+		reader.RecursionDesired = *dnsRecursion
+		reader.LogRawAnswers = *dnsLogRawAnswers
+		reader.Identity = *dnsIdentity
+		reader.RTTBuckets = rttBuckets
+		reader.DetectAnomalies = *dnsDetectAnomalies
+		reader.MetricLayout = *dnsMetricLayout
+		reader.InfoTTL = *dnsInfoTTL
+		if len(*dnsUpstreamInclude) > 0 || len(*dnsUpstreamExclude) > 0 {
+			reader.UpstreamFilter = upstreamFilter.Allowed
+		}
+		reader.ExtraQueries = extraQueries
+	}
+
+	// Servers with the same labels from the config file are collected from by the same
+	// pool. Each pool has its labels added to all of its metrics so that they don't
+	// conflict with the metrics of other pools.
+	var (
+		dnsmasqReaders   = make(map[string]*roger.DnsmasqReader)
+		dnsmasqAdded     []*roger.DnsmasqReader
+		dnsmasqServers   []string
+		dnsmasqPools     = make(map[string]*roger.DnsmasqPool)
+		dnsmasqPoolOrder []string
+	)
+
+	if *dnsStatsFile != "" && *dnsmasqLogFile != "" {
+		level.Error(logger).Log("msg", "only one of --dns.stats-file and --dnsmasq.log-file may be set")
+		os.Exit(1)
+	}
+
+	if *dnsStatsFile != "" || *dnsmasqLogFile != "" {
+		// Stats are read from the file as if it were a server, using its path as
+		// the address of the server.
+		var (
+			pool *roger.DnsmasqPool
+			path string
+		)
+		if *dnsStatsFile != "" {
+			pool = roger.NewDnsmasqPool(roger.NewStatsFileClient(), dnsmasqLogger)
+			path = *dnsStatsFile
+		} else {
+			pool = roger.NewDnsmasqPool(roger.NewSyslogStatsClient(), dnsmasqLogger)
+			path = *dnsmasqLogFile
+		}
+
+		dnsmasqPools[roger.LabelsKey(nil)] = pool
+		dnsmasqPoolOrder = append(dnsmasqPoolOrder, path)
+		dnsmasqServers = append(dnsmasqServers, path)
+
+		reader := pool.Add(path, dnsmasqOpts...)
+		configureDnsmasqReader(reader)
+		dnsmasqAdded = append(dnsmasqAdded, reader)
+		dnsmasqReaders[path] = reader
+	} else {
+		for _, server := range *dnsServers {
+			dnsmasqServers = append(dnsmasqServers, server)
+
+			if *dnsDetect {
+				detect := roger.NewDnsmasqReaderWithOptions(server, dnsmasqLogger, dnsmasqOpts...)
+				configureDnsmasqReader(detect)
+
+				version, err := detect.ServerVersion()
+				if err != nil {
+					level.Warn(logger).Log("msg", "unable to detect DNS server version, not exporting DNS server metrics", "server", server, "err", err)
+					continue
+				} else if !strings.Contains(strings.ToLower(version), strings.ToLower(*dnsFlavor)) {
+					level.Warn(logger).Log("msg", "DNS server does not match expected flavor, not exporting DNS server metrics", "server", server, "version", version, "flavor", *dnsFlavor)
+					continue
+				}
+
+				level.Info(logger).Log("msg", "detected DNS server", "server", server, "version", version)
+			}
+
+			key := roger.LabelsKey(dnsServerLabels.For(server))
+			pool, ok := dnsmasqPools[key]
+			if !ok {
+				pool = roger.NewDnsmasqPool(nil, dnsmasqLogger)
+				dnsmasqPools[key] = pool
+				dnsmasqPoolOrder = append(dnsmasqPoolOrder, server)
+			}
+
+			reader := pool.AddWithOptions(server, dnsmasqOpts...)
+			configureDnsmasqReader(reader)
+			dnsmasqAdded = append(dnsmasqAdded, reader)
+			if _, ok := dnsmasqReaders[server]; !ok {
+				dnsmasqReaders[server] = reader
+			}
+		}
+	}
+
+	// The hostname is only queried at startup since it doesn't change while the server
+	// is running.
+	if *dnsLabelHostname {
+		roger.LabelByHostname(dnsmasqAdded, logger)
+	}
+
+	// Configured servers are listed with the same server label as their metrics, or
+	// by address if they aren't collected from because detection failed.
+	for _, server := range dnsmasqServers {
+		if reader, ok := dnsmasqReaders[server]; ok && reader.ServerLabel != "" {
+			inventory.AddServer(reader.ServerLabel)
+		} else {
+			inventory.AddServer(server)
+		}
+	}
+
+	// Each pool is its own collector named by its labels, e.g. dnsmasq{site="a"}, so
+	// that they can be told apart in /status. They're a single dnsmasq collector in
+	// roger_collector_enabled.
+	dnsWorkers := roger.NewCollectWorkers(*dnsMaxConcurrency)
+	for _, server := range dnsmasqPoolOrder {
+		labels := dnsServerLabels.For(server)
+		name := "dnsmasq"
+		if len(labels) > 0 {
+			name = "dnsmasq{" + strings.TrimSuffix(roger.LabelsKey(labels), ",") + "}"
+		}
+
+		dnsmasqPools[roger.LabelsKey(labels)].Workers = dnsWorkers
+		pool := startupGate.Collector("dnsmasq", dnsmasqPools[roger.LabelsKey(labels)], dnsmasqLogger)
+		inventory.AddCollector("dnsmasq")
+		registerWith(prometheus.WrapRegistererWith(labels, registry), name, pool, intervalOr(*dnsInterval), nil, dnsmasqLogger)
+	}
+
+	if len(dnsmasqPoolOrder) == 0 {
+		collectorStatus.Disabled("dnsmasq")
+	}
+
+	// Readers of /proc and /sys aren't created at all with --no-proc so that hosts where
+	// they aren't wanted, such as DNS appliances, aren't probed for files.
+	var (
+		netDevReader   *roger.ProcNetDevReader
+		netStatReaders = make(map[string]*roger.ProcNetStatReader)
+		netStatOrder   []string
+	)
+
+	if *procEnabled {
+		if *collectorHost {
+			hostLogger := collectorLogger("host", "")
+			hostReader := roger.NewHostReader(*procPath, hostLogger)
+			hostReader.Timeout = *collectTimeout
+			loadAvgExists := inventory.CheckProcFile(*procPath, "loadavg")
+			memInfoExists := inventory.CheckProcFile(*procPath, "meminfo")
+			if loadAvgExists && memInfoExists {
+				register("host", hostReader, hostLogger)
+			} else {
+				collectorStatus.Disabled("host")
+				level.Warn(logger).Log("msg", "host vitals not available, skipping host collector", "path", *procPath)
+			}
+		} else {
+			collectorStatus.Disabled("host")
+		}
+
+		if *collectorConntrack {
+			conntrackLogger := collectorLogger("conntrack", *logLevelNetStat)
+			conntrackReader := roger.NewProcNetConntrackReader(*procPath, conntrackLogger)
+			conntrackReader.MaxEntries = *conntrackMaxEntries
+			conntrackReader.Timeout = *conntrackTimeout
+			conntrackExists := conntrackReader.Exists()
+			inventory.SetProcFile("net/nf_conntrack", conntrackExists)
+			if conntrackExists {
+				register("conntrack", conntrackReader, conntrackLogger)
+			} else {
+				collectorStatus.Disabled("conntrack")
+				level.Warn(logger).Log("msg", "conntrack table not available, skipping conntrack collector", "path", *procPath)
+			}
+		} else {
+			collectorStatus.Disabled("conntrack")
+		}
+
+		processLogger := collectorLogger("dns_process", *logLevelProcess)
+		processReader := roger.NewProcessReader(*procPath, *dnsPid, *dnsPidFile, processLogger)
+		if pid, err := processReader.Pid(); err == nil && pid != 0 {
+			inventory.CheckProcFile(*procPath, strconv.Itoa(pid)+"/stat")
+			inventory.CheckProcFile(*procPath, strconv.Itoa(pid)+"/status")
+		}
+		if processReader.Exists() {
+			register("dns_process", processReader, processLogger)
+		} else {
+			collectorStatus.Disabled("dns_process")
+		}
+
+		netDevLogger := collectorLogger("netdev", *logLevelNetDev)
+		netDevReader = roger.NewProcNetDevReader(*procPath, netDevLogger)
+		netDevReader.NormalizeNames = *netDevNormalizeNames
+		netDevReader.SubsystemStyle = *netDevSubsystemStyle
+		netDevReader.LinkFaults = *netDevLinkFaults
+		netDevReader.Detect32BitWrap = *netDevDetectWrap
+		netDevReader.Timeout = *collectTimeout
+		if *netDevAliasFile != "" {
+			aliases, err := roger.NewInterfaceAliases(*netDevAliasFile)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to load interface aliases", "path", *netDevAliasFile, "err", err)
+				os.Exit(1)
+			}
+
+			netDevReader.Aliases = aliases
+			go reloadOnHangup(ctx, logger, aliases)
+		}
+		if *netDevTotalsExclude != "" {
+			netDevReader.TotalsExclude = totalsExclude
+		}
+		var netDevFilters []roger.InterfaceFilter
+		sys := roger.NewSysClassNet(*sysPath)
+		if *netDevUpOnly || *netDevBondAggregate {
+			if !sys.Exists() {
+				level.Warn(logger).Log("msg", "sysfs not available, ignoring --netdev.up-only and --netdev.bond-aggregate", "path", *sysPath)
+			} else {
+				if *netDevUpOnly {
+					netDevFilters = append(netDevFilters, sys.UpFilter())
+				}
+				if *netDevBondAggregate {
+					netDevReader.Bonds = sys.Bonds
+				}
+			}
+		}
+
+		if len(includeCIDRs) > 0 {
+			addrs := roger.NewProcNetAddrs(*procPath)
+			inventory.CheckProcFile(*procPath, "net/fib_trie")
+			inventory.CheckProcFile(*procPath, "net/route")
+			inventory.CheckProcFile(*procPath, "net/if_inet6")
+			if addrs.Exists() {
+				netDevFilters = append(netDevFilters, addrs.CIDRFilter(includeCIDRs))
+			} else {
+				level.Warn(logger).Log("msg", "interface addresses not available, exporting metrics for interfaces in any range", "path", *procPath)
+			}
+		}
+
+		if len(netDevFilters) > 0 {
+			netDevReader.Filter = roger.AllFilters(netDevFilters...)
+		}
+
+		netDevExists := netDevReader.Exists()
+		inventory.SetProcFile("net/dev", netDevExists)
+		if netDevExists {
+			register("netdev", netDevReader, netDevLogger)
+		} else {
+			collectorStatus.Disabled("netdev")
+		}
+
+		softnetLogger := collectorLogger("softnet", "")
+		softnetReader := roger.NewProcNetSoftnetReader(*procPath, softnetLogger)
+		softnetReader.Timeout = *collectTimeout
+		softnetExists := softnetReader.Exists()
+		inventory.SetProcFile("net/softnet_stat", softnetExists)
+		if softnetExists {
+			register("softnet", softnetReader, softnetLogger)
+		} else {
+			collectorStatus.Disabled("softnet")
+		}
+
+		// Interface attributes from sysfs use the same filters so that they line up
+		// with the interfaces net/dev metrics are exported for.
+		if *collectorSysNet {
+			sysNetLogger := collectorLogger("sysnet", *logLevelNetDev)
+			sysNetReader := roger.NewSysClassNetReader(*sysPath, sysNetLogger)
+			sysNetReader.Filter = netDevReader.Filter
+			sysNetReader.NormalizeNames = *netDevNormalizeNames
+			if sysNetReader.Exists() {
+				register("sysnet", sysNetReader, sysNetLogger)
+			} else {
+				collectorStatus.Disabled("sysnet")
+				level.Warn(logger).Log("msg", "sysfs not available, skipping sysnet collector", "path", *sysPath)
+			}
+		} else {
+			collectorStatus.Disabled("sysnet")
+		}
+
+		// Files from the config file come first and replace the defaults for any of the
+		// same files given by --proc.netstat.
+		var netStatNames []string
+		netStatNewReaders := make(map[string]func(logger log.Logger) *roger.ProcNetStatReader)
+		for _, f := range netStatFiles {
+			f := f
+			netStatNames = append(netStatNames, f.File)
+			netStatNewReaders[f.File] = func(logger log.Logger) *roger.ProcNetStatReader {
+				return roger.NewProcNetStatReaderFromConfig(*procPath, f, logger)
+			}
+		}
+
+		for _, variant := range *netStatVariants {
+			if _, ok := netStatNewReaders[variant]; ok {
+				continue
+			}
+
+			variant := variant
+			netStatNames = append(netStatNames, variant)
+			netStatNewReaders[variant] = func(logger log.Logger) *roger.ProcNetStatReader {
+				return roger.NewProcNetStatReader(*procPath, variant, logger)
+			}
+		}
+
+		// Multiple files may map to the same metric names (e.g. nf_conntrack and the legacy
+		// ip_conntrack) so only register the first one found for each subsystem.
+		netStatSubsystems := make(map[string]bool)
+		for _, variant := range netStatNames {
+			netStatLogger := collectorLogger(variant, *logLevelNetStat)
+			netStatReader := netStatNewReaders[variant](netStatLogger)
+			netStatReader.NormalizePerCPU = *netStatNormalize
+			netStatReader.Timeout = *collectTimeout
+			netStatExists := netStatReader.Exists()
+			inventory.SetProcFile("net/stat/"+variant, netStatExists)
+			if !netStatExists {
+				collectorStatus.Disabled(variant)
+				level.Debug(logger).Log("msg", "skipping missing net/stat file", "variant", variant)
+			} else if netStatSubsystems[netStatReader.Subsystem()] {
+				collectorStatus.Disabled(variant)
+				level.Debug(logger).Log("msg", "skipping net/stat file for already registered subsystem", "variant", variant, "subsystem", netStatReader.Subsystem())
+			} else {
+				netStatSubsystems[netStatReader.Subsystem()] = true
+				netStatReaders[variant] = netStatReader
+				netStatOrder = append(netStatOrder, variant)
+				register(variant, netStatReader, netStatLogger)
+			}
+		}
+
+		// Kernels built without conntrack procfs support still have the stats over netlink
+		if _, ok := netStatNewReaders["nf_conntrack"]; ok && !netStatSubsystems["nf_conntrack"] {
+			netStatLogger := collectorLogger("nf_conntrack", *logLevelNetStat)
+			netStatReader := roger.NewNetlinkConntrackStatReader(netStatLogger)
+			netStatReader.NormalizePerCPU = *netStatNormalize
+			if netStatReader.Exists() {
+				level.Info(logger).Log("msg", "reading conntrack stats over netlink since /proc/net/stat/nf_conntrack is missing")
+				netStatReaders["nf_conntrack"] = netStatReader
+				netStatOrder = append(netStatOrder, "nf_conntrack")
+				register("nf_conntrack", netStatReader, netStatLogger)
+			} else {
+				level.Debug(logger).Log("msg", "conntrack stats not available over netlink")
+			}
+		}
+
+		available, missing := inventory.ProcFiles()
+		level.Info(logger).Log("msg", "checked for files under /proc", "path", *procPath, "available", strings.Join(available, ","), "missing", strings.Join(missing, ","))
+	} else {
+		for _, name := range []string{"host", "conntrack", "dns_process", "netdev", "softnet", "sysnet"} {
+			collectorStatus.Disabled(name)
+		}
+		for _, variant := range *netStatVariants {
+			collectorStatus.Disabled(variant)
+		}
+	}
+
+	if *once {
+		failures, err := collectorStatus.Once(os.Stdout, gatherer)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to gather metrics", "err", err)
+		}
+
+		for _, f := range failures {
+			level.Error(logger).Log("msg", "collector failed", "collector", f.Name, "err", f.Err)
+		}
+
+		if err != nil || len(failures) > 0 {
+			os.Exit(1)
+		}
 
-	netDevReader := roger.NewProcNetDevReader(*procPath, logger)
-	if netDevReader.Exists() {
-		registry.MustRegister(netDevReader)
+		return
 	}
 
-	connTrack := roger.NewProcNetStatReader(*procPath, "nf_conntrack", logger)
-	if connTrack.Exists() {
-		registry.MustRegister(connTrack)
+	if *otlpEndpoint != "" {
+		if *otlpInterval <= 0 {
+			level.Error(logger).Log("msg", "invalid OTLP push interval", "interval", *otlpInterval)
+			os.Exit(1)
+		}
+
+		// Pushes on the interval fail in the background, the SDK reports errors to
+		// its global handler
+		otlpLogger := collectorLogger("otlp", "")
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			level.Error(otlpLogger).Log("msg", "failed to push metrics via OTLP", "endpoint", *otlpEndpoint, "err", err)
+		}))
+
+		pusher, err := roger.NewOTLPPusher(*otlpEndpoint, gatherer, *otlpInterval, otlpLogger)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to create OTLP exporter", "endpoint", *otlpEndpoint, "err", err)
+			os.Exit(1)
+		}
+
+		level.Info(logger).Log("msg", "pushing metrics via OTLP", "endpoint", *otlpEndpoint, "interval", *otlpInterval)
+		go pusher.Run(ctx)
 	}
 
-	arpCache := roger.NewProcNetStatReader(*procPath, "arp_cache", logger)
-	if arpCache.Exists() {
-		registry.MustRegister(arpCache)
+	if *graphiteAddress != "" {
+		if *graphiteInterval <= 0 {
+			level.Error(logger).Log("msg", "invalid Graphite push interval", "interval", *graphiteInterval)
+			os.Exit(1)
+		}
+
+		level.Info(logger).Log("msg", "pushing metrics to Graphite", "address", *graphiteAddress, "interval", *graphiteInterval)
+		go roger.NewGraphitePusher(*graphiteAddress, gatherer, *graphiteInterval, collectorLogger("graphite", "")).Run(ctx)
 	}
 
 	index, err := template.New("index").Parse(indexTpt)
@@ -105,15 +1003,89 @@ func main() {
 		os.Exit(1)
 	}
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	// Track scrapes being handled concurrently to diagnose scrape storms
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "roger",
+		Name:      "scrapes_in_flight",
+		Help:      "Number of scrapes of the metrics endpoint currently being handled",
+	})
+	registry.MustRegister(inFlight)
+
+	http.Handle(*metricsPath, promhttp.InstrumentHandlerInFlight(inFlight, handler))
+	if *webDebug {
+		http.Handle("/debug/dnsmasq", jsonHandler(logger, func(r *http.Request) (interface{}, error) {
+			// The first server with a reader is used unless another is picked with
+			// ?server=. That's the first server of the first pool, servers without a
+			// reader because they were skipped or replaced by a stats file aren't.
+			server := r.URL.Query().Get("server")
+			if server == "" && len(dnsmasqPoolOrder) > 0 {
+				server = dnsmasqPoolOrder[0]
+			}
+
+			reader, ok := dnsmasqReaders[server]
+			if !ok {
+				return nil, fmt.Errorf("%w for DNS server %q", errNotExported, server)
+			}
+
+			// Partial results include any dropped answers, return them instead of the error
+			res, err := reader.ReadMetrics()
+			if res != nil {
+				return res, nil
+			}
+			return nil, err
+		}))
+
+		http.Handle("/debug/netdev", jsonHandler(logger, func(r *http.Request) (interface{}, error) {
+			if netDevReader == nil {
+				return nil, fmt.Errorf("%w for net/dev", errNotExported)
+			}
+
+			return netDevReader.ReadMetrics()
+		}))
+
+		http.Handle("/debug/netstat", jsonHandler(logger, func(r *http.Request) (interface{}, error) {
+			// The first file found is used unless another is picked with ?variant=
+			variant := r.URL.Query().Get("variant")
+			if variant == "" && len(netStatOrder) > 0 {
+				variant = netStatOrder[0]
+			}
+
+			reader, ok := netStatReaders[variant]
+			if !ok {
+				return nil, fmt.Errorf("%w for net/stat file %q", errNotExported, variant)
+			}
+
+			return reader.ReadMetrics()
+		}))
+	}
+
+	// Collections are checked instead of always responding with 200 so that a wedged
+	// collector fails liveness checks, not only scrapes.
+	http.Handle("/healthz", roger.NewHealthCheck(collectorStatus).Handler())
+
+	// Status of the most recent collection by each collector, it doesn't collect so it's
+	// cheap enough to poll.
+	http.Handle("/status", jsonHandler(logger, func(r *http.Request) (interface{}, error) {
+		return collectorStatus.States(), nil
+	}))
+
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !startupGate.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if err := index.Execute(w, *metricsPath); err != nil {
 			level.Error(logger).Log("msg", "failed to render index", "err", err)
 		}
 	})
 
-	if err := http.ListenAndServe(*webAddr, nil); err != nil {
-		level.Error(logger).Log("err", err)
+	if err := serve(ctx, logger, *webAddrs); err != nil {
+		level.Error(logger).Log("msg", "failed to serve metrics", "err", err)
 		os.Exit(1)
 	}
 }